@@ -1,44 +1,391 @@
-package main
+package generator
 
 import (
-	"log"
+	"fmt"
+	"io"
+	"os"
 
+	"github.com/MrAjMann/crm/internal/model"
 	"github.com/go-pdf/fpdf"
 )
 
-func main() {
+// Business holds the details of the business that is printed on every invoice
+type Business struct {
+	Name              string
+	Tagline           string
+	ABN               string // Printed on tax invoices
+	Email             string
+	Phone             string
+	Website           string
+	BankAccountName   string
+	BankBSB           string
+	BankAccountNumber string
+	LogoPath          string
+}
+
+// BusinessFromEnv reads the business details from the environment, falling back to A&R Tech
+func BusinessFromEnv() Business {
+	return Business{
+		Name:              getEnv("BUSINESS_NAME", "A&R TECH"),
+		Tagline:           getEnv("BUSINESS_TAGLINE", "PC SUPPORT ON THE GO"),
+		ABN:               os.Getenv("BUSINESS_ABN"),
+		Email:             os.Getenv("BUSINESS_EMAIL"),
+		Phone:             os.Getenv("BUSINESS_PHONE"),
+		Website:           os.Getenv("BUSINESS_WEBSITE"),
+		BankAccountName:   os.Getenv("BANK_ACCOUNT_NAME"),
+		BankBSB:           os.Getenv("BANK_BSB"),
+		BankAccountNumber: os.Getenv("BANK_ACCOUNT_NUMBER"),
+		LogoPath:          getEnv("BUSINESS_LOGO", "generator/logo.png"),
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+type InvoiceGenerator struct {
+	business Business
+}
+
+func NewInvoiceGenerator(business Business) *InvoiceGenerator {
+	return &InvoiceGenerator{business: business}
+}
+
+// Column widths for the item table, they add up to the 190mm between the margins
+var itemColumns = []struct {
+	title string
+	width float64
+	align string
+}{
+	{"Item", 80, "L"},
+	{"Qty", 18, "R"},
+	{"Unit Price", 24, "R"},
+	{"Subtotal", 24, "R"},
+	{"Tax", 20, "R"},
+	{"Total", 24, "R"},
+}
+
+// document is what gets printed, invoices, estimates and credit notes share the same layout
+type document struct {
+	title           string
+	number          string
+	customerHeading string
+	customer        []string
+	details         [][2]string
+	items           []model.ItemList
+	taxSummary      []model.TaxSummaryLine
+	totals          [][2]string // The last total is printed in bold
+	totalsNote      string
+	footerHeading   string
+	footerText      string
+}
+
+// Render draws the invoice as an A4 PDF and writes it to w
+func (g *InvoiceGenerator) Render(w io.Writer, invoice model.Invoice) error {
+	title := "INVOICE"
+	if invoice.IsTaxInvoice() {
+		title = "TAX INVOICE"
+	}
+	switch invoice.Status {
+	case model.DraftInvoice:
+		title = "DRAFT " + title
+	case model.VoidInvoice:
+		title = "VOID " + title
+	}
+
+	taxLabel := "Tax"
+	if invoice.IsTaxInvoice() {
+		taxLabel = "GST"
+	}
+	totals := [][2]string{
+		{"Subtotal (excl. tax)", invoice.Subtotal.String()},
+		{taxLabel, invoice.Tax.String()},
+		{"Total (" + string(invoice.Currency) + ")", invoice.Total.String()},
+	}
+	if len(invoice.CreditNotes) > 0 {
+		totals = append(totals, [2]string{"Credited", invoice.Credited.String()})
+	}
+	if len(invoice.Payments) > 0 {
+		totals = append(totals, [2]string{"Amount Paid", invoice.AmountPaid.String()})
+	}
+	totals = append(totals, [2]string{"Balance Due", invoice.BalanceDue.String()})
+
+	// Drafts are numbered and dated when they are issued
+	number, invoiceDate := invoice.InvoiceNumber, invoice.InvoiceDate.Format("02/01/2006")
+	if invoice.Status == model.DraftInvoice {
+		number, invoiceDate = "Not issued", "Not issued"
+	}
+
+	doc := document{
+		title:           title,
+		number:          number,
+		customerHeading: "Bill To",
+		customer:        []string{invoice.CustomerName, invoice.CompanyName, invoice.CustomerAddress.String(), invoice.CustomerEmail, invoice.CustomerPhone},
+		details: [][2]string{
+			{"Invoice Number", number},
+			{"Invoice Date", invoiceDate},
+			{"Due Date", invoice.DueDate.Format("02/01/2006")},
+			{"Status", invoice.PaymentStatus.String()},
+		},
+		items:         invoice.ItemList,
+		taxSummary:    invoice.TaxSummary,
+		totals:        totals,
+		footerHeading: "Payment Instructions",
+		footerText:    g.paymentInstructions(invoice),
+	}
+	if invoice.PeriodStart != nil && invoice.PeriodEnd != nil {
+		period := invoice.PeriodStart.Format("02/01/2006") + " to " + invoice.PeriodEnd.Format("02/01/2006")
+		doc.details = append(doc.details, [2]string{"Service Period", period})
+	}
+	if invoice.PricesIncludeTax && invoice.IsTaxInvoice() {
+		doc.totalsNote = "Total price includes GST"
+	}
+	return g.render(w, doc)
+}
+
+// RenderEstimate draws the estimate as an A4 PDF and writes it to w
+func (g *InvoiceGenerator) RenderEstimate(w io.Writer, estimate model.Estimate) error {
+	title := "ESTIMATE"
+	if estimate.Status == model.DraftEstimate {
+		title = "DRAFT " + title
+	}
+
+	taxLabel := "Tax"
+	if estimate.IncludesGST() {
+		taxLabel = "GST"
+	}
+	doc := document{
+		title:           title,
+		number:          estimate.EstimateNumber,
+		customerHeading: "Prepared For",
+		customer:        []string{estimate.CustomerName, estimate.CompanyName, estimate.CustomerAddress.String(), estimate.CustomerEmail, estimate.CustomerPhone},
+		details: [][2]string{
+			{"Estimate Number", estimate.EstimateNumber},
+			{"Estimate Date", estimate.EstimateDate.Format("02/01/2006")},
+			{"Valid Until", estimate.ValidUntil.Format("02/01/2006")},
+		},
+		items:      estimate.ItemList,
+		taxSummary: estimate.TaxSummary,
+		totals: [][2]string{
+			{"Subtotal (excl. tax)", estimate.Subtotal.String()},
+			{taxLabel, estimate.Tax.String()},
+			{"Total (" + string(estimate.Currency) + ")", estimate.Total.String()},
+		},
+		footerHeading: "Accepting this Estimate",
+		footerText: fmt.Sprintf("This estimate is valid until %s.\nTo go ahead, get in touch quoting %s and we will send an invoice.",
+			estimate.ValidUntil.Format("02/01/2006"), estimate.EstimateNumber),
+	}
+	if estimate.PricesIncludeTax && estimate.IncludesGST() {
+		doc.totalsNote = "Total price includes GST"
+	}
+	return g.render(w, doc)
+}
+
+// RenderCreditNote draws the credit note as an A4 PDF and writes it to w
+func (g *InvoiceGenerator) RenderCreditNote(w io.Writer, note model.CreditNote) error {
+	taxLabel := "Tax"
+	if note.IncludesGST() {
+		taxLabel = "GST"
+	}
+
+	settlement := fmt.Sprintf("The credit of %s has been taken off the balance due on invoice %s.", note.Total, note.InvoiceNumber)
+	if note.Settlement == model.CreditRefund {
+		settlement = fmt.Sprintf("The credit of %s has been refunded by %s.", note.Total, note.RefundMethod)
+	}
+
+	doc := document{
+		title:           "CREDIT NOTE",
+		number:          note.CreditNoteNumber,
+		customerHeading: "Credit To",
+		customer:        []string{note.CustomerName, note.CompanyName, note.CustomerAddress.String(), note.CustomerEmail, note.CustomerPhone},
+		details: [][2]string{
+			{"Credit Note Number", note.CreditNoteNumber},
+			{"Credit Date", note.CreditDate.Format("02/01/2006")},
+			{"Original Invoice", note.InvoiceNumber},
+			{"Invoice Date", note.InvoiceDate.Format("02/01/2006")},
+		},
+		items:      note.ItemList,
+		taxSummary: note.TaxSummary,
+		totals: [][2]string{
+			{"Subtotal (excl. tax)", note.Subtotal.String()},
+			{taxLabel, note.Tax.String()},
+			{"Total Credit (" + string(note.Currency) + ")", note.Total.String()},
+		},
+		footerHeading: "Reason for Credit",
+		footerText:    note.Reason + "\n" + settlement,
+	}
+	if note.PricesIncludeTax && note.IncludesGST() {
+		doc.totalsNote = "Total credit includes GST"
+	}
+	return g.render(w, doc)
+}
+
+// render lays the document out on A4 pages with the business branding
+func (g *InvoiceGenerator) render(w io.Writer, doc document) error {
 	pdf := fpdf.New("P", "mm", "A4", "")
-	pdf.SetCompression(false) // Consider enabling for production
+	pdf.SetMargins(10, 10, 10)
+	pdf.SetAutoPageBreak(true, 25)
+	pdf.AliasNbPages("")
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
 
-	// // Define a template - 210 x 297 mm
-	template := pdf.CreateTemplate(func(tpl *fpdf.Tpl) {
-		tpl.Image("./logo.png", 6, 10, 15, 0, false, "", 0, "")
+	// Define a template - 210 x 297 mm
+	// The branding is drawn once and reused on every page
+	hasLogo := false
+	if _, err := os.Stat(g.business.LogoPath); err == nil {
+		hasLogo = true
+	}
+	branding := pdf.CreateTemplate(func(tpl *fpdf.Tpl) {
+		if hasLogo {
+			tpl.Image(g.business.LogoPath, 10, 10, 15, 0, false, "", 0, "")
+		}
 		tpl.SetFont("Arial", "B", 16)
-		tpl.Text(165, 280, "A&R TECH")
+		tpl.Text(10, 280, tr(g.business.Name))
 		tpl.SetFont("Arial", "B", 12)
-		tpl.Text(142, 287, "PC SUPPORT ON THE GO")
-
+		tpl.Text(10, 287, tr(g.business.Tagline))
 	})
 
-	template2 := pdf.CreateTemplate(func(tpl *fpdf.Tpl) {
-		tpl.UseTemplate(template)
-		subtemplate := tpl.CreateTemplate(func(tpl2 *fpdf.Tpl) {
-			tpl2.SetFont("Arial", "B", 16)
-			tpl2.Text(40, 200, "Subtemplate says hello")
-			tpl2.SetDrawColor(0, 200, 100)
-			tpl2.SetLineWidth(2.5)
-			tpl2.Line(102, 92, 112, 102)
-		})
-		tpl.UseTemplate(subtemplate)
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-15)
+		pdf.SetFont("Arial", "", 8)
+		pdf.CellFormat(0, 5, fmt.Sprintf("Page %d of {nb}", pdf.PageNo()), "", 0, "R", false, 0, "")
 	})
 
-	// Use the template
 	pdf.AddPage()
-	pdf.UseTemplate(template2)
+	pdf.UseTemplate(branding)
+
+	// Header
+	pdf.SetXY(110, 10)
+	pdf.SetFont("Arial", "B", 22)
+	pdf.CellFormat(90, 10, doc.title, "", 2, "R", false, 0, "")
+	pdf.SetFont("Arial", "", 10)
+	pdf.CellFormat(90, 5, tr(g.business.Name), "", 2, "R", false, 0, "")
+	if g.business.ABN != "" {
+		pdf.CellFormat(90, 5, tr("ABN "+g.business.ABN), "", 2, "R", false, 0, "")
+	}
+	for _, line := range []string{g.business.Email, g.business.Phone, g.business.Website} {
+		if line != "" {
+			pdf.CellFormat(90, 5, tr(line), "", 2, "R", false, 0, "")
+		}
+	}
+
+	// Customer block and document details side by side
+	top := 45.0
+	pdf.SetXY(10, top)
+	pdf.SetFont("Arial", "B", 11)
+	pdf.CellFormat(95, 6, doc.customerHeading, "", 2, "L", false, 0, "")
+	pdf.SetFont("Arial", "", 10)
+	for _, line := range doc.customer {
+		if line != "" {
+			pdf.CellFormat(95, 5, tr(line), "", 2, "L", false, 0, "")
+		}
+	}
+	customerBottom := pdf.GetY()
+
+	pdf.SetXY(110, top)
+	for _, detail := range doc.details {
+		pdf.SetX(110)
+		pdf.SetFont("Arial", "B", 10)
+		pdf.CellFormat(45, 6, detail[0], "", 0, "L", false, 0, "")
+		pdf.SetFont("Arial", "", 10)
+		pdf.CellFormat(45, 6, tr(detail[1]), "", 1, "R", false, 0, "")
+	}
+
+	if customerBottom > pdf.GetY() {
+		pdf.SetY(customerBottom)
+	}
+	pdf.Ln(8)
+
+	// Line items
+	drawItemHeader := func() {
+		pdf.SetFont("Arial", "B", 10)
+		pdf.SetFillColor(230, 230, 230)
+		for _, column := range itemColumns {
+			pdf.CellFormat(column.width, 7, column.title, "1", 0, column.align, true, 0, "")
+		}
+		pdf.Ln(-1)
+		pdf.SetFont("Arial", "", 10)
+	}
+	drawItemHeader()
+	_, pageHeight := pdf.GetPageSize()
+	for _, item := range doc.items {
+		if pdf.GetY()+7 > pageHeight-30 {
+			pdf.AddPage()
+			pdf.UseTemplate(branding)
+			pdf.SetY(30)
+			drawItemHeader()
+		}
+		values := []string{
+			tr(item.Item),
+			fmt.Sprint(item.Quantity),
+			item.UnitPrice.String(),
+			item.Subtotal.String(),
+			item.Tax.String(),
+			item.Total.String(),
+		}
+		for i, column := range itemColumns {
+			pdf.CellFormat(column.width, 7, values[i], "1", 0, column.align, false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+
+	// Tax summary on the left, totals on the right
+	pdf.Ln(4)
+	summaryTop := pdf.GetY()
+	pdf.SetFont("Arial", "B", 10)
+	pdf.CellFormat(50, 6, "Tax Summary", "", 0, "L", false, 0, "")
+	pdf.CellFormat(25, 6, "Net", "", 0, "R", false, 0, "")
+	pdf.CellFormat(25, 6, "Tax", "", 1, "R", false, 0, "")
+	pdf.SetFont("Arial", "", 9)
+	for _, line := range doc.taxSummary {
+		pdf.CellFormat(50, 5, tr(line.Name), "", 0, "L", false, 0, "")
+		pdf.CellFormat(25, 5, line.Net.String(), "", 0, "R", false, 0, "")
+		pdf.CellFormat(25, 5, line.Tax.String(), "", 1, "R", false, 0, "")
+	}
+	summaryBottom := pdf.GetY()
+
+	pdf.SetY(summaryTop)
+	for i, total := range doc.totals {
+		style := ""
+		if i == len(doc.totals)-1 {
+			style = "B"
+		}
+		pdf.SetFont("Arial", style, 10)
+		pdf.SetX(130)
+		pdf.CellFormat(40, 7, total[0], "", 0, "L", false, 0, "")
+		pdf.CellFormat(30, 7, total[1], "", 1, "R", false, 0, "")
+	}
+	if doc.totalsNote != "" {
+		pdf.SetFont("Arial", "I", 9)
+		pdf.SetX(130)
+		pdf.CellFormat(70, 5, doc.totalsNote, "", 1, "L", false, 0, "")
+	}
+	if pdf.GetY() < summaryBottom {
+		pdf.SetY(summaryBottom)
+	}
+
+	// Payment instructions or how to accept
+	pdf.Ln(6)
+	pdf.SetFont("Arial", "B", 11)
+	pdf.CellFormat(0, 6, doc.footerHeading, "", 1, "L", false, 0, "")
+	pdf.SetFont("Arial", "", 10)
+	pdf.MultiCell(0, 5, tr(doc.footerText), "", "L", false)
+
+	if pdf.Err() {
+		return fmt.Errorf("error rendering %s: %v", doc.number, pdf.Error())
+	}
+	return pdf.Output(w)
+}
 
-	// Output the PDF
-	err := pdf.OutputFileAndClose("hello1.pdf")
-	if err != nil {
-		log.Fatalf("Error creating PDF: %v", err) // More descriptive error handling
+// paymentInstructions builds the how-to-pay text from the business bank details
+func (g *InvoiceGenerator) paymentInstructions(invoice model.Invoice) string {
+	text := fmt.Sprintf("Please pay by %s.", invoice.DueDate.Format("02/01/2006"))
+	if g.business.BankAccountNumber != "" {
+		text += fmt.Sprintf("\nDirect deposit to %s\nBSB: %s   Account: %s",
+			g.business.BankAccountName, g.business.BankBSB, g.business.BankAccountNumber)
 	}
+	text += fmt.Sprintf("\nPlease use %s as the payment reference.", invoice.InvoiceNumber)
+	return text
 }
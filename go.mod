@@ -7,9 +7,10 @@ require (
 	github.com/lib/pq v1.10.9
 )
 
-require github.com/go-pdf/fpdf v0.9.0
-
 require (
-	github.com/gorilla/securecookie v1.1.2 // indirect
-	github.com/gorilla/sessions v1.2.2 // indirect
+	github.com/go-pdf/fpdf v0.9.0
+	github.com/gorilla/sessions v1.2.2
+	golang.org/x/crypto v0.21.0
 )
+
+require github.com/gorilla/securecookie v1.1.2
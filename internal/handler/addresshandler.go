@@ -0,0 +1,272 @@
+package handler
+
+import (
+	"database/sql"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/MrAjMann/crm/internal/model"
+	"github.com/MrAjMann/crm/internal/repository"
+)
+
+type AddressHandler struct {
+	repo *repository.AddressRepository
+	tmpl *template.Template
+}
+
+// AddressesData is what the addresses section on the customer page renders
+type AddressesData struct {
+	CustomerId int
+	Types      []model.AddressType
+	Addresses  []model.CustomerAddress
+}
+
+// AddressForm is an address with the choices for its type
+type AddressForm struct {
+	model.CustomerAddress
+	Types []model.AddressType
+}
+
+// AddressOptions is a customer's addresses to pick the billing address of an invoice from
+type AddressOptions struct {
+	Addresses []model.CustomerAddress
+	Selected  int // The address already picked, 0 picks the first
+}
+
+// NewAddress is the empty form for adding another address
+func (d AddressesData) NewAddress() AddressForm {
+	return AddressForm{CustomerAddress: model.CustomerAddress{CustomerId: d.CustomerId, Type: model.BillingAddress}, Types: d.Types}
+}
+
+func NewAddressHandler(repo *repository.AddressRepository, tmpl *template.Template) *AddressHandler {
+	return &AddressHandler{repo: repo, tmpl: tmpl}
+}
+
+// Get a customer's addresses as options for picking the billing address on an invoice
+func (h *AddressHandler) GetAddressOptions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	customerId, err := strconv.Atoi(r.URL.Query().Get("customerId"))
+	if err != nil {
+		http.Error(w, "Invalid customer ID", http.StatusBadRequest)
+		return
+	}
+
+	// selected keeps the address already on a draft invoice picked
+	selected, _ := strconv.Atoi(r.URL.Query().Get("selected"))
+
+	addresses, err := h.repo.GetAddressesForCustomer(customerId, "")
+	if err != nil {
+		http.Error(w, "Database error on fetching addresses", http.StatusInternalServerError)
+		log.Printf("Database error on fetching addresses: %v\n", err)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "address-options", AddressOptions{Addresses: addresses, Selected: selected})
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+// Add an Address to a customer
+func (h *AddressHandler) AddAddress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		log.Printf("Error parsing form: %v\n", err)
+		return
+	}
+
+	customerId, err := strconv.Atoi(r.FormValue("customerId"))
+	if err != nil {
+		http.Error(w, "Invalid customer ID", http.StatusBadRequest)
+		return
+	}
+
+	address := addressFromForm(r)
+	address.CustomerId = customerId
+	if !h.validAddress(w, address) {
+		return
+	}
+
+	if _, err := h.repo.AddAddress(address); err != nil {
+		http.Error(w, "Database error on inserting new address", http.StatusInternalServerError)
+		log.Printf("Database error on inserting new address: %v\n", err)
+		return
+	}
+
+	// A new default changes another address too, so send back the whole list
+	h.renderAddressList(w, customerId)
+}
+
+// Get an Address, used to put it back after cancelling an edit
+func (h *AddressHandler) GetAddress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/address/"), "/")
+	h.renderAddress(w, r, idStr, "address-item")
+}
+
+// Get the inline edit form for an Address
+func (h *AddressHandler) EditAddress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/address/edit/"), "/")
+	h.renderAddress(w, r, idStr, "address-edit-form")
+}
+
+// Update an Address
+func (h *AddressHandler) UpdateAddress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PUT" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/address/update/"), "/")
+	addressId, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid address ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		log.Printf("Error parsing form: %v\n", err)
+		return
+	}
+
+	address := addressFromForm(r)
+	address.AddressId = addressId
+	if !h.validAddress(w, address) {
+		return
+	}
+
+	address, err = h.repo.UpdateAddress(address)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on updating address", http.StatusInternalServerError)
+		log.Printf("Database error on updating address: %v\n", err)
+		return
+	}
+
+	h.renderAddressList(w, address.CustomerId)
+}
+
+// Delete an Address
+func (h *AddressHandler) DeleteAddress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/address/delete/"), "/")
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid address ID", http.StatusBadRequest)
+		return
+	}
+
+	err := h.repo.DeleteAddressById(idStr)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on deleting address", http.StatusInternalServerError)
+		log.Printf("Database error on deleting address: %v\n", err)
+		return
+	}
+
+	// htmx swaps the address out for this empty response
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *AddressHandler) renderAddress(w http.ResponseWriter, r *http.Request, idStr string, templateName string) {
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid address ID", http.StatusBadRequest)
+		return
+	}
+
+	address, err := h.repo.GetAddressById(idStr)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on fetching address", http.StatusInternalServerError)
+		log.Printf("Database error on fetching address: %v\n", err)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, templateName, AddressForm{CustomerAddress: address, Types: model.AddressTypes})
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+func (h *AddressHandler) renderAddressList(w http.ResponseWriter, customerId int) {
+	addresses, err := h.repo.GetAddressesForCustomer(customerId, "")
+	if err != nil {
+		http.Error(w, "Database error on fetching addresses", http.StatusInternalServerError)
+		log.Printf("Database error on fetching addresses: %v\n", err)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "address-list", AddressesData{CustomerId: customerId, Types: model.AddressTypes, Addresses: addresses})
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+// validAddress sends back a 400 when the address can't be saved
+func (h *AddressHandler) validAddress(w http.ResponseWriter, address model.CustomerAddress) bool {
+	if !address.Type.Valid() {
+		http.Error(w, "Invalid address type", http.StatusBadRequest)
+		return false
+	}
+	if address.StreetName == "" || address.City == "" {
+		http.Error(w, "An address needs at least a street and a city", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// addressFromForm reads the address fields, trimming the spaces people paste in
+func addressFromForm(r *http.Request) model.CustomerAddress {
+	field := func(name string) string {
+		return strings.TrimSpace(r.FormValue(name))
+	}
+	return model.CustomerAddress{
+		Type:      model.AddressType(field("type")),
+		IsDefault: r.FormValue("isDefault") == "on",
+		Address: model.Address{
+			UnitNumber:   field("unitNumber"),
+			StreetNumber: field("streetNumber"),
+			StreetName:   field("streetName"),
+			City:         field("city"),
+			State:        field("state"),
+			Postcode:     field("postcode"),
+		},
+	}
+}
@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/MrAjMann/crm/internal/auth"
+	"github.com/MrAjMann/crm/internal/model"
+	"github.com/MrAjMann/crm/internal/repository"
+	"github.com/gorilla/sessions"
+)
+
+const (
+	authSessionName = "crm-auth"
+	sessionUserId   = "userId"
+)
+
+type AuthHandler struct {
+	repo  *repository.UserRepository
+	tmpl  *template.Template
+	store *sessions.CookieStore
+}
+
+type LoginData struct {
+	Email string
+	Next  string
+	Error string
+}
+
+type userContextKey struct{}
+
+// NewAuthHandler keeps logins in a cookie signed with sessionKey, set secure when the site is served over https
+func NewAuthHandler(repo *repository.UserRepository, tmpl *template.Template, sessionKey []byte, secure bool) *AuthHandler {
+	store := sessions.NewCookieStore(sessionKey)
+	store.Options = &sessions.Options{
+		Path:     "/",
+		MaxAge:   12 * 60 * 60,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+	return &AuthHandler{repo: repo, tmpl: tmpl, store: store}
+}
+
+// CurrentUser returns the logged in user put on the request by RequireAuth
+func CurrentUser(r *http.Request) (model.User, bool) {
+	user, ok := r.Context().Value(userContextKey{}).(model.User)
+	return user, ok
+}
+
+// RequireAuth only lets logged in users through, everyone else is sent to the login page.
+// Static assets and the login page itself stay public.
+func (h *AuthHandler) RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isPublicPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, ok := h.sessionUser(r)
+		if !ok {
+			loginURL := "/login"
+			if r.Method == "GET" && !isHTMX(r) && r.URL.Path != "/" {
+				loginURL += "?next=" + r.URL.RequestURI()
+			}
+			redirect(w, r, loginURL)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userContextKey{}, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func isPublicPath(path string) bool {
+	return path == "/login" || strings.HasPrefix(path, "/src/") || strings.HasPrefix(path, "/css/")
+}
+
+// sessionUser loads the user from the session, checking they still exist
+func (h *AuthHandler) sessionUser(r *http.Request) (model.User, bool) {
+	session, err := h.store.Get(r, authSessionName)
+	if err != nil {
+		return model.User{}, false
+	}
+	userId, ok := session.Values[sessionUserId].(int)
+	if !ok {
+		return model.User{}, false
+	}
+	user, err := h.repo.GetUserById(userId)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Database error on fetching session user: %v\n", err)
+		}
+		return model.User{}, false
+	}
+	return user, true
+}
+
+// Login shows the login form and checks the submitted credentials
+func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		h.renderLogin(w, http.StatusOK, LoginData{Next: r.URL.Query().Get("next")})
+		return
+	case "POST":
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		log.Printf("Error parsing form: %v\n", err)
+		return
+	}
+
+	data := LoginData{Email: r.FormValue("email"), Next: r.FormValue("next")}
+	user, err := h.repo.GetUserByEmail(data.Email)
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, "Database error on fetching user", http.StatusInternalServerError)
+		log.Printf("Database error on fetching user: %v\n", err)
+		return
+	}
+	if !auth.CheckPassword(user.PasswordHash, r.FormValue("password")) {
+		data.Error = "Incorrect email or password"
+		h.renderLogin(w, http.StatusUnauthorized, data)
+		return
+	}
+
+	session, _ := h.store.Get(r, authSessionName)
+	session.Values[sessionUserId] = user.Id
+	if err := session.Save(r, w); err != nil {
+		log.Printf("Failed to save session: %v", err)
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("User %d logged in", user.Id)
+	http.Redirect(w, r, safeNext(data.Next), http.StatusSeeOther)
+}
+
+// Logout clears the session and returns to the login page
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, _ := h.store.Get(r, authSessionName)
+	session.Options.MaxAge = -1
+	delete(session.Values, sessionUserId)
+	if err := session.Save(r, w); err != nil {
+		log.Printf("Failed to clear session: %v", err)
+	}
+	redirect(w, r, "/login")
+}
+
+func (h *AuthHandler) renderLogin(w http.ResponseWriter, status int, data LoginData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := h.tmpl.ExecuteTemplate(w, "login.html", data); err != nil {
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+// safeNext only allows redirecting back to a path on this site after logging in
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
@@ -0,0 +1,249 @@
+package handler
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/MrAjMann/crm/generator"
+	"github.com/MrAjMann/crm/internal/model"
+	"github.com/MrAjMann/crm/internal/repository"
+)
+
+type CreditNoteHandler struct {
+	repo        *repository.CreditNoteRepository
+	invoiceRepo *repository.InvoiceRepository
+	tmpl        *template.Template
+	pdf         *generator.InvoiceGenerator
+}
+
+// CreditNoteFormData is the create credit note page, each invoice line with how much of it is left to credit
+type CreditNoteFormData struct {
+	Invoice     model.Invoice
+	Uncredited  map[int]int32
+	Settlements []model.CreditSettlement
+	Methods     []model.PaymentMethod
+}
+
+// CreditNotePageData is the credit note page along with the invoice it credits
+type CreditNotePageData struct {
+	model.CreditNote
+	Invoice model.Invoice
+}
+
+func NewCreditNoteHandler(repo *repository.CreditNoteRepository, invoiceRepo *repository.InvoiceRepository, tmpl *template.Template, pdf *generator.InvoiceGenerator) *CreditNoteHandler {
+	return &CreditNoteHandler{repo: repo, invoiceRepo: invoiceRepo, tmpl: tmpl, pdf: pdf}
+}
+
+// Get the create credit note page for an invoice, served from /create-credit-note/{invoiceId}
+func (h *CreditNoteHandler) CreateCreditNote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/create-credit-note/"), "/")
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid invoice ID", http.StatusBadRequest)
+		return
+	}
+
+	invoice, err := h.invoiceRepo.GetInvoiceById(idStr)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on fetching invoice", http.StatusInternalServerError)
+		log.Printf("Database error on fetching invoice: %v\n", err)
+		return
+	}
+	if !invoice.CanCredit() {
+		http.Error(w, "Nothing is left to credit on this invoice", http.StatusConflict)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "createCreditNote.html", CreditNoteFormData{
+		Invoice:     invoice,
+		Uncredited:  invoice.Uncredited(),
+		Settlements: model.CreditSettlements,
+		Methods:     model.PaymentMethods,
+	})
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+var creditQuantityPattern = regexp.MustCompile(`^quantity\[(\d+)\]$`)
+
+// Raise a credit note against an invoice. The form sends quantity[ItemId] for each invoice line,
+// or full to credit everything not credited yet.
+func (h *CreditNoteHandler) AddCreditNote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/add-credit-note/"), "/")
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid invoice ID", http.StatusBadRequest)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		log.Printf("Error parsing form: %v\n", err)
+		return
+	}
+
+	note := model.CreditNote{
+		InvoiceId:  idStr,
+		Reason:     strings.TrimSpace(r.FormValue("reason")),
+		Settlement: model.CreditSettlement(r.FormValue("settlement")),
+	}
+	if note.Reason == "" {
+		flash(w, r, h.tmpl, http.StatusUnprocessableEntity, "Please give a reason for the credit")
+		return
+	}
+	if !note.Settlement.Valid() {
+		flash(w, r, h.tmpl, http.StatusUnprocessableEntity, "Invalid settlement")
+		return
+	}
+	if note.Settlement == model.CreditRefund {
+		note.RefundMethod = model.PaymentMethod(r.FormValue("refundMethod"))
+		if !note.RefundMethod.Valid() {
+			flash(w, r, h.tmpl, http.StatusUnprocessableEntity, "Invalid refund method")
+			return
+		}
+	}
+
+	// nil credits everything left on the invoice
+	var quantities map[int]int32
+	if r.FormValue("full") == "" {
+		quantities = make(map[int]int32)
+		for key, values := range r.PostForm {
+			match := creditQuantityPattern.FindStringSubmatch(key)
+			if match == nil || len(values) == 0 || strings.TrimSpace(values[0]) == "" {
+				continue
+			}
+			itemId, _ := strconv.Atoi(match[1])
+			quantity, err := strconv.ParseInt(strings.TrimSpace(values[0]), 10, 32)
+			if err != nil {
+				flash(w, r, h.tmpl, http.StatusUnprocessableEntity, "Invalid quantity")
+				return
+			}
+			quantities[itemId] = int32(quantity)
+		}
+	}
+
+	user, _ := CurrentUser(r)
+	note.CreatedById = user.Id
+
+	note, err = h.repo.AddCreditNote(note, quantities)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err == repository.ErrNothingToCredit || err == repository.ErrOverCredit {
+		flash(w, r, h.tmpl, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if err == repository.ErrInvoiceNotCreditable || err == repository.ErrCreditMoreThanBalance || err == repository.ErrRefundMoreThanPaid {
+		flash(w, r, h.tmpl, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on creating credit note", http.StatusInternalServerError)
+		log.Printf("Database error on creating credit note: %v\n", err)
+		return
+	}
+	log.Printf("User %d raised credit note %s for %s against invoice %s", user.Id, note.CreditNoteNumber, note.Total, note.InvoiceNumber)
+
+	redirect(w, r, "/credit-note/view/"+note.CreditNoteId)
+}
+
+// Get a Credit Note
+func (h *CreditNoteHandler) GetCreditNote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/credit-note/view/"), "/")
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid credit note ID", http.StatusBadRequest)
+		return
+	}
+
+	note, invoice, err := h.repo.GetCreditNoteById(idStr)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on fetching credit note", http.StatusInternalServerError)
+		log.Printf("Database error on fetching credit note: %v\n", err)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "creditNote.html", CreditNotePageData{CreditNote: note, Invoice: invoice})
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+// Get a Credit Note as a PDF, served from /credit-note/{id}/pdf
+func (h *CreditNoteHandler) GetCreditNotePDF(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 || parts[2] != "pdf" {
+		http.NotFound(w, r)
+		return
+	}
+	idStr := parts[1]
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid credit note ID", http.StatusBadRequest)
+		return
+	}
+
+	note, _, err := h.repo.GetCreditNoteById(idStr)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on fetching credit note", http.StatusInternalServerError)
+		log.Printf("Database error on fetching credit note: %v\n", err)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := h.pdf.RenderCreditNote(&buf, note); err != nil {
+		http.Error(w, "Error generating credit note PDF", http.StatusInternalServerError)
+		log.Printf("Error generating credit note PDF: %v\n", err)
+		return
+	}
+
+	disposition := "inline"
+	if r.URL.Query().Get("download") != "" {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=%q", disposition, note.CreditNoteNumber+".pdf"))
+	if _, err := buf.WriteTo(w); err != nil {
+		log.Printf("Failed to write credit note PDF: %v", err)
+	}
+}
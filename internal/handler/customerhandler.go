@@ -1,10 +1,11 @@
 package handler
 
 import (
-	"fmt"
+	"database/sql"
 	"html/template"
 	"log"
 	"net/http"
+	"net/mail"
 	"strconv"
 	"strings"
 
@@ -14,12 +15,30 @@ import (
 )
 
 type CustomerHandler struct {
-	repo *repository.CustomerRepository
-	tmpl *template.Template
+	repo        *repository.CustomerRepository
+	noteRepo    *repository.NoteRepository
+	addressRepo *repository.AddressRepository
+	invoiceRepo *repository.InvoiceRepository
+	tmpl        *template.Template
 }
 
-func NewCustomerHandler(repo *repository.CustomerRepository, tmpl *template.Template) *CustomerHandler {
-	return &CustomerHandler{repo: repo, tmpl: tmpl}
+// CustomerData is what the customer page renders
+type CustomerData struct {
+	model.Customer
+	NotesSection     NotesData
+	AddressesSection AddressesData
+	History          []model.FinancialRecord // Invoices and credit notes, only loaded for users who can view invoices
+	ShowHistory      bool
+}
+
+// CustomerForm is the inline edit form, Error is set when a submitted form is shown again
+type CustomerForm struct {
+	model.Customer
+	Error string
+}
+
+func NewCustomerHandler(repo *repository.CustomerRepository, noteRepo *repository.NoteRepository, addressRepo *repository.AddressRepository, invoiceRepo *repository.InvoiceRepository, tmpl *template.Template) *CustomerHandler {
+	return &CustomerHandler{repo: repo, noteRepo: noteRepo, addressRepo: addressRepo, invoiceRepo: invoiceRepo, tmpl: tmpl}
 }
 
 func (h *CustomerHandler) GetAllCustomers(w http.ResponseWriter, r *http.Request) {
@@ -112,8 +131,38 @@ func (h *CustomerHandler) GetCustomer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	customer.Notes, err = h.noteRepo.GetNotesForCustomer(customer.Id, "")
+	if err != nil {
+		http.Error(w, "Database error on fetching notes", http.StatusInternalServerError)
+		log.Printf("Database error on fetching notes: %v\n", err)
+		return
+	}
+
+	customer.Addresses, err = h.addressRepo.GetAddressesForCustomer(customer.Id, "")
+	if err != nil {
+		http.Error(w, "Database error on fetching addresses", http.StatusInternalServerError)
+		log.Printf("Database error on fetching addresses: %v\n", err)
+		return
+	}
+
+	data := CustomerData{
+		Customer:         customer,
+		NotesSection:     NotesData{CustomerId: customer.Id, Categories: model.NoteCategories, Notes: customer.Notes},
+		AddressesSection: AddressesData{CustomerId: customer.Id, Types: model.AddressTypes, Addresses: customer.Addresses},
+	}
+
+	if user, ok := CurrentUser(r); ok && user.Role.Can(model.ViewInvoices) {
+		invoices, err := h.invoiceRepo.SearchInvoices(model.InvoiceFilter{CustomerId: strconv.Itoa(customer.Id)})
+		if err != nil {
+			http.Error(w, "Database error on fetching invoices", http.StatusInternalServerError)
+			log.Printf("Database error on fetching invoices: %v\n", err)
+			return
+		}
+		data.History, data.ShowHistory = model.CustomerFinancialHistory(invoices), true
+	}
+
 	// Assuming tmpl is a template instance parsed at application initialization
-	err = h.tmpl.ExecuteTemplate(w, "customer.html", customer)
+	err = h.tmpl.ExecuteTemplate(w, "customer.html", data)
 	if err != nil {
 		http.Error(w, "Error executing template", http.StatusInternalServerError)
 		log.Printf("Error executing template: %v\n", err)
@@ -143,91 +192,244 @@ func (h *CustomerHandler) HandleSearchCustomers(w http.ResponseWriter, r *http.R
 	}
 
 	w.Header().Set("Content-Type", "text/html")
-	var htmlOutput string
-
-	htmlOutput += `
-    <style>
-        .customer-list { list-style-type: none; margin: 0; padding: 0; }
-        .customer-item { background-color: #f9f9f9; border-left: 5px solid #007bff; margin-bottom: 8px; padding: 12px; border-radius: 4px; cursor: pointer; transition: background-color 0.3s; }
-        .customer-item:hover { background-color: #f0f0f0; }
-        .customer-info { margin: 0; color: #333; }
-        .customer-info span { font-weight: bold; }
-    </style>
-    <ul class='customer-list'>`
-
-	for _, customer := range customers {
-		htmlOutput += fmt.Sprintf(`
-        <li class='customer-item'>
-            <p class='customer-info'><span>ID:</span> %d</p>
-            <p class='customer-info'><span>Name:</span> %s %s</p>
-            <p class='customer-info'><span>Email:</span> %s</p>
-            <p class='customer-info'><span>Phone:</span> %s</p>
-            <p class='customer-info'><span>Company:</span> %s</p>
-        </li>`, customer.Id, customer.FirstName, customer.LastName, customer.Email, customer.Phone, customer.CompanyName)
-	}
-	htmlOutput += "</ul>"
-
-	// Additional JavaScript for interactive functionality
-	htmlOutput += `
-    <script>
-        document.querySelectorAll('.customer-item').forEach(item => {
-            item.addEventListener('click', function() {
-                // Highlight the selected item
-                document.querySelectorAll('.customer-item').forEach(i => {
-                    i.style.borderLeft = '5px solid #007bff';
-                });
-                this.style.borderLeft = '5px solid #ff7f00';  // Highlight color change on click
-                
-                // Example of potentially useful data handling
-                const customerId = this.querySelector('.customer-info:nth-child(1)').innerText.split(':')[1].trim();
-                console.log('Selected Customer ID:', customerId);  // Just logging to console for demo
-            });
-        });
-    </script>`
-
-	if _, err = w.Write([]byte(htmlOutput)); err != nil {
-		log.Printf("Failed to write data: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	err = h.tmpl.ExecuteTemplate(w, "customer-search-results", customers)
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+// Get the inline edit form for a Customer
+func (h *CustomerHandler) EditCustomer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/customer/edit/"), "/")
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid customer ID", http.StatusBadRequest)
+		return
+	}
+
+	customer, err := h.repo.GetCustomerById(idStr)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on fetching customer", http.StatusInternalServerError)
+		log.Printf("Database error on fetching customer: %v\n", err)
 		return
 	}
 
+	err = h.tmpl.ExecuteTemplate(w, "customer-edit-form", CustomerForm{Customer: customer})
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
 }
 
-// Update a Customer
+// Update a Customer. PUT replaces every field, PATCH only changes the fields that were sent.
+func (h *CustomerHandler) UpdateCustomer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PUT" && r.Method != "PATCH" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-// Delete a Customer
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/customer/update/"), "/")
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid customer ID", http.StatusBadRequest)
+		return
+	}
 
-func (h *CustomerHandler) DeleteCustomer(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		log.Printf("Error parsing form: %v\n", err)
+		return
+	}
+
+	customer, err := h.repo.GetCustomerById(idStr)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on fetching customer", http.StatusInternalServerError)
+		log.Printf("Database error on fetching customer: %v\n", err)
+		return
+	}
+
+	fields := map[string]*string{
+		"firstName":   &customer.FirstName,
+		"lastName":    &customer.LastName,
+		"email":       &customer.Email,
+		"phone":       &customer.Phone,
+		"companyName": &customer.CompanyName,
+		"title":       &customer.Title,
+		"website":     &customer.Website,
+		"industry":    &customer.Industry,
+	}
+	for name, field := range fields {
+		if _, sent := r.PostForm[name]; sent || r.Method == "PUT" {
+			*field = strings.TrimSpace(r.PostFormValue(name))
+		}
+	}
+	// The form sends "off" from a hidden field ahead of the checkbox, so an unticked box still counts as sent
+	if values, sent := r.PostForm["paymentReminders"]; sent || r.Method == "PUT" {
+		customer.NoReminders = len(values) == 0 || values[len(values)-1] != "on"
+	}
+
+	if problem := validateCustomer(customer); problem != "" {
+		// 422 responses are swapped in by the page so the form is shown again with the problem
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		if err := h.tmpl.ExecuteTemplate(w, "customer-edit-form", CustomerForm{Customer: customer, Error: problem}); err != nil {
+			log.Printf("Error executing template: %v\n", err)
+		}
+		return
+	}
+
+	customer, err = h.repo.UpdateCustomer(customer)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on updating customer", http.StatusInternalServerError)
+		log.Printf("Database error on updating customer: %v\n", err)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "customer-details", customer)
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+// validateCustomer returns what is wrong with the customer's details, or "" when they can be saved
+func validateCustomer(customer model.Customer) string {
+	if customer.FirstName == "" && customer.LastName == "" && customer.CompanyName == "" {
+		return "A customer needs a name or a company name"
+	}
+	if customer.Email != "" {
+		if _, err := mail.ParseAddress(customer.Email); err != nil {
+			return "That email address doesn't look right"
+		}
+	}
+	return ""
+}
 
+// Delete a Customer. This only archives them, everything attached to the customer is kept and they can be restored.
+func (h *CustomerHandler) DeleteCustomer(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "DELETE" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Parsing the customer ID from the URL path
-	parts := strings.Split(r.URL.Path, "/")
-	if len(parts) < 4 {
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/customer/delete/"), "/")
+	if _, err := strconv.Atoi(idStr); err != nil {
 		http.Error(w, "Invalid customer ID", http.StatusBadRequest)
 		return
 	}
-	idStr := parts[3] // Assuming URL is formatted as /customer/delete/{id}
 
-	if idStr == "" {
+	archivedCustomer, err := h.repo.ArchiveCustomerById(idStr)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on archiving customer", http.StatusInternalServerError)
+		log.Printf("Database error on archiving customer: %v\n", err)
+		return
+	}
+	log.Printf("Archived customer: %d - %s %s", archivedCustomer.Id, archivedCustomer.FirstName, archivedCustomer.LastName)
+
+	// htmx swaps the customer's row out for this empty response
+	w.WriteHeader(http.StatusOK)
+}
+
+// Get all archived Customers
+func (h *CustomerHandler) GetArchivedCustomers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	customers, err := h.repo.GetArchivedCustomers()
+	if err != nil {
+		http.Error(w, "Database error on fetching archived customers", http.StatusInternalServerError)
+		log.Printf("Database error on fetching archived customers: %v\n", err)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "archivedCustomers.html", customers)
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+// Restore an archived Customer
+func (h *CustomerHandler) RestoreCustomer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/customer/restore/"), "/")
+	if _, err := strconv.Atoi(idStr); err != nil {
 		http.Error(w, "Invalid customer ID", http.StatusBadRequest)
 		return
 	}
-	// Get the customer by id from the repository
-	deletedCustomer, err := h.repo.DeleteCustomerById(idStr)
+
+	restoredCustomer, err := h.repo.RestoreCustomerById(idStr)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Database error on fetching customer", http.StatusInternalServerError)
-		log.Printf("Database error on fetching customer: %v\n", err)
+		http.Error(w, "Database error on restoring customer", http.StatusInternalServerError)
+		log.Printf("Database error on restoring customer: %v\n", err)
 		return
 	}
-	log.Printf("Deleted customer: %+v", deletedCustomer)
-	// Assuming tmpl is a template instance parsed at application initialization
+	log.Printf("Restored customer: %d - %s %s", restoredCustomer.Id, restoredCustomer.FirstName, restoredCustomer.LastName)
+
+	// The customer is no longer archived, so their row leaves the archived list
 	w.WriteHeader(http.StatusOK)
+}
 
-	fmt.Fprintf(w, "Customer deleted successfully: %d - %s %s", deletedCustomer.Id, deletedCustomer.FirstName, deletedCustomer.LastName)
+// Purge an archived Customer for good, refused while they have invoices
+func (h *CustomerHandler) PurgeCustomer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/customer/purge/"), "/")
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid customer ID", http.StatusBadRequest)
+		return
+	}
+
+	purgedCustomer, err := h.repo.PurgeCustomerById(idStr)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err == repository.ErrCustomerNotArchived || err == repository.ErrCustomerHasFinancialRecords {
+		flash(w, r, h.tmpl, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on purging customer", http.StatusInternalServerError)
+		log.Printf("Database error on purging customer: %v\n", err)
+		return
+	}
+
+	user, _ := CurrentUser(r)
+	log.Printf("User %d purged customer: %d - %s %s", user.Id, purgedCustomer.Id, purgedCustomer.FirstName, purgedCustomer.LastName)
+	w.WriteHeader(http.StatusOK)
 }
 
 var store = sessions.NewCookieStore([]byte("askjdn23undm-dc2-3njdknwr"))
@@ -4,18 +4,33 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+
+	"github.com/MrAjMann/crm/internal/model"
+	"github.com/MrAjMann/crm/internal/repository"
 )
 
 type DashboardHandler struct {
-	tmpl *template.Template
+	leadRepo *repository.LeadRepository
+	tmpl     *template.Template
+}
+
+type DashboardData struct {
+	WinRate model.WinRateReport
 }
 
-func NewDashboardHandler(tmpl *template.Template) *DashboardHandler {
-	return &DashboardHandler{tmpl: tmpl}
+func NewDashboardHandler(leadRepo *repository.LeadRepository, tmpl *template.Template) *DashboardHandler {
+	return &DashboardHandler{leadRepo: leadRepo, tmpl: tmpl}
 }
 
 func (h *DashboardHandler) Dashboard(w http.ResponseWriter, r *http.Request) {
-	err := h.tmpl.ExecuteTemplate(w, "index.html", nil)
+	winRate, err := h.leadRepo.GetWinRateReport()
+	if err != nil {
+		http.Error(w, "Database error on fetching win rate", http.StatusInternalServerError)
+		log.Printf("Database error on fetching win rate: %v\n", err)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "index.html", DashboardData{WinRate: winRate})
 	if err != nil {
 		log.Printf("Error executing template: %v\n", err)
 		http.Error(w, "Error executing template", http.StatusInternalServerError)
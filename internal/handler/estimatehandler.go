@@ -0,0 +1,327 @@
+package handler
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MrAjMann/crm/generator"
+	"github.com/MrAjMann/crm/internal/model"
+	"github.com/MrAjMann/crm/internal/repository"
+)
+
+type EstimateHandler struct {
+	repo        *repository.EstimateRepository
+	taxCodeRepo *repository.TaxCodeRepository
+	productRepo *repository.ProductRepository
+	tmpl        *template.Template
+	pdf         *generator.InvoiceGenerator
+}
+
+type EstimateData struct {
+	Estimates []model.Estimate
+}
+
+func NewEstimateHandler(repo *repository.EstimateRepository, taxCodeRepo *repository.TaxCodeRepository, productRepo *repository.ProductRepository, tmpl *template.Template, pdf *generator.InvoiceGenerator) *EstimateHandler {
+	return &EstimateHandler{repo: repo, taxCodeRepo: taxCodeRepo, productRepo: productRepo, tmpl: tmpl, pdf: pdf}
+}
+
+// Get the estimates page
+func (h *EstimateHandler) GetAllEstimates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	estimates, err := h.repo.GetAllEstimates()
+	if err != nil {
+		http.Error(w, "Database error on fetching estimates", http.StatusInternalServerError)
+		log.Printf("Database error on fetching estimates: %v\n", err)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "estimates.html", EstimateData{Estimates: estimates})
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+// Get the create estimate page, the same form as a new invoice
+func (h *EstimateHandler) CreateEstimate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data := InvoiceFormData{Estimate: true}
+	var err error
+	if data.TaxCodes, err = h.taxCodeRepo.GetAllTaxCodes(false); err == nil {
+		data.Products, err = h.productRepo.GetAllProducts(false)
+	}
+	if err != nil {
+		http.Error(w, "Database error on fetching tax codes and products", http.StatusInternalServerError)
+		log.Printf("Database error on fetching tax codes and products: %v\n", err)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "createInvoice.html", data)
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+func (h *EstimateHandler) AddNewEstimate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		log.Printf("Error parsing form: %v\n", err)
+		return
+	}
+
+	customerId := r.FormValue("customerId")
+	if _, err := strconv.Atoi(customerId); err != nil {
+		http.Error(w, "Please select a customer", http.StatusBadRequest)
+		return
+	}
+
+	// Like invoices, the form has a separate button for saving a draft
+	status := model.SentEstimate
+	if r.FormValue("status") == string(model.DraftEstimate) {
+		status = model.DraftEstimate
+	}
+
+	var billingAddressId int
+	if billingAddressStr := r.FormValue("billingAddressId"); billingAddressStr != "" {
+		billingAddressId, err = strconv.Atoi(billingAddressStr)
+		if err != nil {
+			http.Error(w, "Invalid billing address", http.StatusBadRequest)
+			return
+		}
+	}
+
+	validUntil := time.Now().AddDate(0, 0, 30)
+	if validUntilStr := r.FormValue("ValidUntil"); validUntilStr != "" {
+		validUntil, err = time.Parse("2006-01-02", validUntilStr)
+		if err != nil {
+			http.Error(w, "Invalid valid until date", http.StatusBadRequest)
+			return
+		}
+	}
+
+	pricesIncludeTax := r.FormValue("pricesIncludeTax") != ""
+
+	taxCodes, err := h.taxCodeRepo.GetAllTaxCodes(false)
+	if err != nil {
+		http.Error(w, "Database error on fetching tax codes", http.StatusInternalServerError)
+		log.Printf("Database error on fetching tax codes: %v\n", err)
+		return
+	}
+	products, err := h.productRepo.GetAllProducts(false)
+	if err != nil {
+		http.Error(w, "Database error on fetching products", http.StatusInternalServerError)
+		log.Printf("Database error on fetching products: %v\n", err)
+		return
+	}
+
+	items, err := parseItemList(r.PostForm, taxCodes, products, pricesIncludeTax)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	estimate := model.Estimate{
+		CustomerId:       customerId,
+		ValidUntil:       validUntil,
+		ItemList:         items,
+		BillingAddressId: billingAddressId,
+		Status:           status,
+		Currency:         model.DefaultCurrency,
+		TaxRounding:      model.DefaultTaxRounding,
+		PricesIncludeTax: pricesIncludeTax,
+	}
+	estimate.CalculateTotals()
+	if user, ok := CurrentUser(r); ok {
+		estimate.CreatedById = user.Id
+	}
+
+	estimateId, err := h.repo.AddNewEstimate(estimate)
+	if err != nil {
+		http.Error(w, "Database error on creating new estimate", http.StatusInternalServerError)
+		log.Printf("Database error on creating new estimate: %v\n", err)
+		return
+	}
+
+	w.Header().Set("HX-Redirect", fmt.Sprintf("/estimate/view/%s", estimateId))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// Get an Estimate
+func (h *EstimateHandler) GetEstimate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/estimate/view/"), "/")
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid estimate ID", http.StatusBadRequest)
+		return
+	}
+
+	estimate, err := h.repo.GetEstimateById(idStr)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on fetching estimate", http.StatusInternalServerError)
+		log.Printf("Database error on fetching estimate: %v\n", err)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "estimate.html", estimate)
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+// Get an Estimate as a PDF, served from /estimate/{id}/pdf
+func (h *EstimateHandler) GetEstimatePDF(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 || parts[2] != "pdf" {
+		http.NotFound(w, r)
+		return
+	}
+	idStr := parts[1]
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid estimate ID", http.StatusBadRequest)
+		return
+	}
+
+	estimate, err := h.repo.GetEstimateById(idStr)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on fetching estimate", http.StatusInternalServerError)
+		log.Printf("Database error on fetching estimate: %v\n", err)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := h.pdf.RenderEstimate(&buf, estimate); err != nil {
+		http.Error(w, "Error generating estimate PDF", http.StatusInternalServerError)
+		log.Printf("Error generating estimate PDF: %v\n", err)
+		return
+	}
+
+	disposition := "inline"
+	if r.URL.Query().Get("download") != "" {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=%q", disposition, estimate.EstimateNumber+".pdf"))
+	if _, err := buf.WriteTo(w); err != nil {
+		log.Printf("Failed to write estimate PDF: %v", err)
+	}
+}
+
+// Move an Estimate to another status, such as sent or accepted
+func (h *EstimateHandler) UpdateEstimateStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/estimate/status/"), "/")
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid estimate ID", http.StatusBadRequest)
+		return
+	}
+
+	status := model.EstimateStatus(r.FormValue("status"))
+	if !status.Valid() {
+		http.Error(w, "Invalid status", http.StatusBadRequest)
+		return
+	}
+
+	err := h.repo.UpdateEstimateStatus(idStr, status)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err == repository.ErrEstimateStatusChange {
+		flash(w, r, h.tmpl, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on updating estimate status", http.StatusInternalServerError)
+		log.Printf("Database error on updating estimate status: %v\n", err)
+		return
+	}
+
+	redirect(w, r, "/estimate/view/"+idStr)
+}
+
+// Convert an Estimate into an issued invoice and open the invoice
+func (h *EstimateHandler) ConvertEstimate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/estimate/convert/"), "/")
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid estimate ID", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	dueDate := time.Now().AddDate(0, 0, 30)
+	if dueDateStr := r.FormValue("DueDate"); dueDateStr != "" {
+		dueDate, err = time.Parse("2006-01-02", dueDateStr)
+		if err != nil {
+			flash(w, r, h.tmpl, http.StatusUnprocessableEntity, "Invalid due date")
+			return
+		}
+	}
+
+	user, _ := CurrentUser(r)
+	invoiceId, err := h.repo.ConvertEstimate(idStr, dueDate, user.Id)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err == repository.ErrEstimateConverted || err == repository.ErrEstimateNotConvertible || err == repository.ErrDueDatePassed {
+		flash(w, r, h.tmpl, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on converting estimate", http.StatusInternalServerError)
+		log.Printf("Database error on converting estimate: %v\n", err)
+		return
+	}
+	log.Printf("User %d converted estimate %s into invoice %s", user.Id, idStr, invoiceId)
+
+	redirect(w, r, "/invoice/view/"+invoiceId)
+}
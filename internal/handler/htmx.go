@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+)
+
+// isHTMX reports whether the request was made by htmx rather than a normal page load
+func isHTMX(r *http.Request) bool {
+	return r.Header.Get("HX-Request") == "true"
+}
+
+// redirect sends the browser to url, using HX-Redirect for htmx requests so the
+// whole page changes instead of the response being swapped into the target
+func redirect(w http.ResponseWriter, r *http.Request, url string) {
+	if isHTMX(r) {
+		w.Header().Set("HX-Redirect", url)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	http.Redirect(w, r, url, http.StatusSeeOther)
+}
+
+// flash tells the user why their request was refused. htmx requests get the message in the
+// page's flash area, anything else gets it as plain text.
+func flash(w http.ResponseWriter, r *http.Request, tmpl *template.Template, status int, message string) {
+	if !isHTMX(r) {
+		http.Error(w, message, status)
+		return
+	}
+
+	w.Header().Set("HX-Retarget", "#flash-messages")
+	w.Header().Set("HX-Reswap", "innerHTML")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := tmpl.ExecuteTemplate(w, "flash", message); err != nil {
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"database/sql"
+	"html/template"
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"github.com/MrAjMann/crm/internal/invoicemail"
+	"github.com/MrAjMann/crm/internal/model"
+	"github.com/MrAjMann/crm/internal/repository"
+)
+
+type InvoiceEmailHandler struct {
+	repo   *repository.InvoiceRepository
+	sender *invoicemail.Sender
+	tmpl   *template.Template
+}
+
+// InvoiceEmailsData is the emails section of an invoice, the send form and every email sent so far
+type InvoiceEmailsData struct {
+	Invoice model.Invoice
+	Enabled bool   // There is a mail server to send through
+	Error   string // Why the last attempt failed
+}
+
+func NewInvoiceEmailHandler(repo *repository.InvoiceRepository, sender *invoicemail.Sender, tmpl *template.Template) *InvoiceEmailHandler {
+	return &InvoiceEmailHandler{repo: repo, sender: sender, tmpl: tmpl}
+}
+
+// Email an Invoice to the customer with the PDF attached
+func (h *InvoiceEmailHandler) SendInvoice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/invoice/send/"), "/")
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid invoice ID", http.StatusBadRequest)
+		return
+	}
+
+	if !h.sender.Enabled() {
+		flash(w, r, h.tmpl, http.StatusServiceUnavailable, "Email isn't set up, add SMTP_HOST to .env to send invoices")
+		return
+	}
+
+	invoice, err := h.repo.GetInvoiceById(idStr)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on fetching invoice", http.StatusInternalServerError)
+		log.Printf("Database error on fetching invoice: %v\n", err)
+		return
+	}
+	if invoice.Status != model.IssuedInvoice {
+		flash(w, r, h.tmpl, http.StatusConflict, "Only issued invoices can be sent")
+		return
+	}
+
+	recipient := strings.TrimSpace(r.FormValue("to"))
+	if recipient == "" {
+		recipient = invoice.CustomerEmail
+	}
+	if _, err := mail.ParseAddress(recipient); err != nil {
+		flash(w, r, h.tmpl, http.StatusUnprocessableEntity, "Enter the email address to send the invoice to")
+		return
+	}
+
+	var sentById int
+	if user, ok := CurrentUser(r); ok {
+		sentById = user.Id
+	}
+
+	data := InvoiceEmailsData{Invoice: invoice, Enabled: true}
+	email, err := h.sender.SendInvoice(invoice, recipient, strings.TrimSpace(r.FormValue("message")), sentById)
+	if err != nil && email.EmailId == 0 {
+		// Nothing was logged, so the email never got as far as the mail server
+		http.Error(w, "Error sending invoice", http.StatusInternalServerError)
+		log.Printf("Error sending invoice: %v\n", err)
+		return
+	}
+	if err != nil {
+		data.Error = email.Error
+		log.Printf("Error sending invoice: %v\n", err)
+	} else {
+		log.Printf("Invoice %s emailed to %s, message id %s", invoice.InvoiceNumber, recipient, email.MessageId)
+	}
+
+	data.Invoice.Emails = append([]model.InvoiceEmail{email}, invoice.Emails...)
+	if err := h.tmpl.ExecuteTemplate(w, "invoice-emails", data); err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
@@ -1,27 +1,80 @@
 package handler
 
 import (
+	"bytes"
+	"database/sql"
+	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/MrAjMann/crm/generator"
+	"github.com/MrAjMann/crm/internal/invoicemail"
 	"github.com/MrAjMann/crm/internal/model"
 	"github.com/MrAjMann/crm/internal/repository"
 )
 
 type InvoiceHandler struct {
-	repo *repository.InvoiceRepository
-	tmpl *template.Template
+	repo        *repository.InvoiceRepository
+	taxCodeRepo *repository.TaxCodeRepository
+	productRepo *repository.ProductRepository
+	tmpl        *template.Template
+	pdf         *generator.InvoiceGenerator
+	sender      *invoicemail.Sender
 }
 
 type InvoiceData struct {
-	Invoices []model.Invoice
+	Invoices        []model.Invoice
+	Statuses        []model.InvoiceStatus
+	PaymentStatuses []model.PaymentStatus
 }
 
-func NewInvoiceHandler(repo *repository.InvoiceRepository, tmpl *template.Template) *InvoiceHandler {
-	return &InvoiceHandler{repo: repo, tmpl: tmpl}
+// InvoicePageData is the invoice page, the invoice with its payments and emails sections
+type InvoicePageData struct {
+	model.Invoice
+	Payments PaymentsData
+	Emails   InvoiceEmailsData
+}
+
+// InvoiceFormData is what the create invoice page offers for each line
+type InvoiceFormData struct {
+	TaxCodes  []model.TaxCode
+	Products  []model.Product
+	Estimate  bool // The page creates an estimate rather than an invoice
+	Recurring bool // The page creates a recurring invoice schedule
+	Units     []model.RecurrenceUnit
+	Invoice   *model.Invoice // The draft being edited, nil for a new invoice
+}
+
+// InvoiceFormRow is one line on the invoice form with the tax codes and products to pick from
+type InvoiceFormRow struct {
+	Index    int
+	Item     model.ItemList
+	TaxCodes []model.TaxCode
+	Products []model.Product
+}
+
+// Rows gives the lines to show on the form, the draft's lines when editing or else one blank line
+func (d InvoiceFormData) Rows() []InvoiceFormRow {
+	items := []model.ItemList{{}}
+	if d.Invoice != nil && len(d.Invoice.ItemList) > 0 {
+		items = d.Invoice.ItemList
+	}
+	rows := make([]InvoiceFormRow, len(items))
+	for i, item := range items {
+		rows[i] = InvoiceFormRow{Index: i, Item: item, TaxCodes: d.TaxCodes, Products: d.Products}
+	}
+	return rows
+}
+
+func NewInvoiceHandler(repo *repository.InvoiceRepository, taxCodeRepo *repository.TaxCodeRepository, productRepo *repository.ProductRepository, tmpl *template.Template, pdf *generator.InvoiceGenerator, sender *invoicemail.Sender) *InvoiceHandler {
+	return &InvoiceHandler{repo: repo, taxCodeRepo: taxCodeRepo, productRepo: productRepo, tmpl: tmpl, pdf: pdf, sender: sender}
 }
 
 func (h *InvoiceHandler) GetAllInvoices(w http.ResponseWriter, r *http.Request) {
@@ -32,7 +85,9 @@ func (h *InvoiceHandler) GetAllInvoices(w http.ResponseWriter, r *http.Request)
 	}
 
 	data := InvoiceData{
-		Invoices: invoices,
+		Invoices:        invoices,
+		Statuses:        model.InvoiceStatuses,
+		PaymentStatuses: model.PaymentStatuses,
 	}
 
 	err = h.tmpl.ExecuteTemplate(w, "invoices.html", data)
@@ -42,6 +97,93 @@ func (h *InvoiceHandler) GetAllInvoices(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// Search for invoices by number, customer, status and invoice date
+func (h *InvoiceHandler) SearchInvoices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, err := parseInvoiceFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	invoices, err := h.repo.SearchInvoices(filter)
+	if err != nil {
+		http.Error(w, "Database error on fetching invoices", http.StatusInternalServerError)
+		log.Printf("Database error on fetching invoices: %v\n", err)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "invoice-list", invoices)
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+// parseInvoiceFilter reads the search box, the status select and the date range.
+// The status can be an invoice status (draft, issued, void) or a payment status (paid, pending, overdue).
+func parseInvoiceFilter(query url.Values) (model.InvoiceFilter, error) {
+	filter := model.InvoiceFilter{Query: query.Get("search")}
+
+	if status := query.Get("status"); status != "" {
+		if model.InvoiceStatus(status).Valid() {
+			filter.Status = model.InvoiceStatus(status)
+		} else {
+			for _, paymentStatus := range model.PaymentStatuses {
+				if strings.EqualFold(status, paymentStatus.String()) {
+					paymentStatus := paymentStatus
+					filter.PaymentStatus = &paymentStatus
+				}
+			}
+			if filter.PaymentStatus == nil {
+				return filter, fmt.Errorf("invalid status %q", status)
+			}
+		}
+	}
+
+	var err error
+	if from := query.Get("from"); from != "" {
+		if filter.From, err = time.Parse("2006-01-02", from); err != nil {
+			return filter, fmt.Errorf("invalid from date %q", from)
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		if filter.To, err = time.Parse("2006-01-02", to); err != nil {
+			return filter, fmt.Errorf("invalid to date %q", to)
+		}
+	}
+	return filter, nil
+}
+
+// Get the create invoice page with the active tax codes and products to pick from
+func (h *InvoiceHandler) CreateInvoice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data InvoiceFormData
+	var err error
+	if data.TaxCodes, err = h.taxCodeRepo.GetAllTaxCodes(false); err == nil {
+		data.Products, err = h.productRepo.GetAllProducts(false)
+	}
+	if err != nil {
+		http.Error(w, "Database error on fetching tax codes and products", http.StatusInternalServerError)
+		log.Printf("Database error on fetching tax codes and products: %v\n", err)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "createInvoice.html", data)
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
 func (h *InvoiceHandler) AddNewInvoice(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		log.Printf("error Method not allowed %v\n", r.Method)
@@ -49,62 +191,510 @@ func (h *InvoiceHandler) AddNewInvoice(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	invoice, ok := h.invoiceFromForm(w, r)
+	if !ok {
+		return
+	}
+	if user, ok := CurrentUser(r); ok {
+		invoice.CreatedById = user.Id
+	}
+
+	invoiceId, err := h.repo.AddNewInvoice(invoice)
+	if err == repository.ErrDueDatePassed {
+		flash(w, r, h.tmpl, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on creating new invoice", http.StatusInternalServerError)
+		log.Printf("Database error on creating new invoice: %v\n", err)
+		return
+	}
+
+	// Send the browser to the new invoice once htmx has the response
+	w.Header().Set("HX-Redirect", fmt.Sprintf("/invoice/view/%s", invoiceId))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// invoiceFromForm reads the invoice posted by the create or edit invoice form, writing the error
+// response and returning false when anything on it is invalid
+func (h *InvoiceHandler) invoiceFromForm(w http.ResponseWriter, r *http.Request) (model.Invoice, bool) {
 	err := r.ParseForm()
 	if err != nil {
 		http.Error(w, "Error parsing form", http.StatusBadRequest)
 		log.Printf("Error parsing form: %v\n", err)
+		return model.Invoice{}, false
+	}
+
+	customerId := r.FormValue("customerId")
+	if _, err := strconv.Atoi(customerId); err != nil {
+		http.Error(w, "Please select a customer", http.StatusBadRequest)
+		log.Printf("Invalid customer id %q: %v\n", customerId, err)
+		return model.Invoice{}, false
+	}
+
+	// The form has a separate button for saving a draft
+	status := model.IssuedInvoice
+	if r.FormValue("status") == string(model.DraftInvoice) {
+		status = model.DraftInvoice
+	}
+
+	// No billingAddressId means the customer's default billing address
+	var billingAddressId int
+	if billingAddressStr := r.FormValue("billingAddressId"); billingAddressStr != "" {
+		billingAddressId, err = strconv.Atoi(billingAddressStr)
+		if err != nil {
+			http.Error(w, "Invalid billing address", http.StatusBadRequest)
+			return model.Invoice{}, false
+		}
+	}
+
+	dueDate := time.Now().AddDate(0, 0, 30)
+	if dueDateStr := r.FormValue("DueDate"); dueDateStr != "" {
+		dueDate, err = time.Parse("2006-01-02", dueDateStr)
+		if err != nil {
+			http.Error(w, "Invalid due date", http.StatusBadRequest)
+			log.Printf("Invalid due date %q: %v\n", dueDateStr, err)
+			return model.Invoice{}, false
+		}
+	}
+
+	pricesIncludeTax := r.FormValue("pricesIncludeTax") != ""
+
+	taxCodes, err := h.taxCodeRepo.GetAllTaxCodes(false)
+	if err != nil {
+		http.Error(w, "Database error on fetching tax codes", http.StatusInternalServerError)
+		log.Printf("Database error on fetching tax codes: %v\n", err)
+		return model.Invoice{}, false
+	}
+	products, err := h.productRepo.GetAllProducts(false)
+	if err != nil {
+		http.Error(w, "Database error on fetching products", http.StatusInternalServerError)
+		log.Printf("Database error on fetching products: %v\n", err)
+		return model.Invoice{}, false
+	}
+
+	items, err := parseItemList(r.PostForm, taxCodes, products, pricesIncludeTax)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		log.Printf("Invalid invoice items: %v\n", err)
+		return model.Invoice{}, false
+	}
+
+	invoice := model.Invoice{
+		CustomerId:       customerId,
+		DueDate:          dueDate,
+		PaymentStatus:    model.Pending, // Payments are recorded against the invoice once it is issued
+		ItemList:         items,
+		BillingAddressId: billingAddressId,
+		Status:           status,
+		Currency:         model.DefaultCurrency,
+		TaxRounding:      model.DefaultTaxRounding,
+		PricesIncludeTax: pricesIncludeTax,
+	}
+	invoice.CalculateTotals()
+	return invoice, true
+}
+
+// Get the edit page for a draft Invoice, the create invoice form filled in with the draft
+func (h *InvoiceHandler) EditInvoice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/invoice/edit/"), "/")
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid invoice ID", http.StatusBadRequest)
 		return
 	}
 
-	paymentStatusStr := r.FormValue("paymentStatus")
-	paymentStatusInt, err := strconv.Atoi(paymentStatusStr)
+	invoice, err := h.repo.GetInvoiceById(idStr)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Invalid payment status", http.StatusBadRequest)
-		log.Printf("Invalid payment status: %v\n", err)
+		http.Error(w, "Database error on fetching invoice", http.StatusInternalServerError)
+		log.Printf("Database error on fetching invoice: %v\n", err)
+		return
+	}
+	if invoice.Status != model.DraftInvoice {
+		flash(w, r, h.tmpl, http.StatusConflict, repository.ErrInvoiceLocked.Error())
 		return
 	}
 
-	// Validate payment status
-	if paymentStatusInt < int(model.Paid) || paymentStatusInt > int(model.Overdue) {
-		http.Error(w, "Payment status out of range", http.StatusBadRequest)
-		log.Printf("Payment status out of range: received %v\n", paymentStatusInt)
+	data := InvoiceFormData{Invoice: &invoice}
+	if data.TaxCodes, err = h.taxCodeRepo.GetAllTaxCodes(false); err == nil {
+		data.Products, err = h.productRepo.GetAllProducts(false)
+	}
+	if err != nil {
+		http.Error(w, "Database error on fetching tax codes and products", http.StatusInternalServerError)
+		log.Printf("Database error on fetching tax codes and products: %v\n", err)
 		return
 	}
 
-	invoice := model.Invoice{
-		CustomerName:  r.FormValue("customerName"),
-		DueDate:       time.Now().AddDate(0, 0, 30),
-		CustomerEmail: r.FormValue("email"),
-		CompanyName:   r.FormValue("companyName"),
-		CustomerPhone: r.FormValue("phone"),
+	err = h.tmpl.ExecuteTemplate(w, "createInvoice.html", data)
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
 	}
+}
 
-	invoiceId, err := h.repo.AddNewInvoice(invoice)
+// Save the edit invoice form over a draft Invoice, issuing it too unless it is saved as a draft again
+func (h *InvoiceHandler) UpdateInvoice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/invoice/update/"), "/")
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid invoice ID", http.StatusBadRequest)
+		return
+	}
+
+	invoice, ok := h.invoiceFromForm(w, r)
+	if !ok {
+		return
+	}
+	invoice.InvoiceId = idStr
+	if user, ok := CurrentUser(r); ok {
+		invoice.IssuedById = user.Id
+	}
+
+	err := h.repo.UpdateDraftInvoice(invoice)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err == repository.ErrInvoiceLocked || err == repository.ErrDueDatePassed {
+		flash(w, r, h.tmpl, http.StatusConflict, err.Error())
+		return
+	}
 	if err != nil {
-		http.Error(w, "Database error on creating new invoice", http.StatusInternalServerError)
-		log.Printf("Database error on creating new invoice: %v\n", err)
+		http.Error(w, "Database error on updating invoice", http.StatusInternalServerError)
+		log.Printf("Database error on updating invoice: %v\n", err)
 		return
 	}
 
-	// Corrected to use the structure InvoiceData with a slice of Invoices
-	data := InvoiceData{
-		Invoices: []model.Invoice{
-			{
-				InvoiceId:     invoiceId, // Now including InvoiceId
-				InvoiceNumber: invoice.InvoiceNumber,
-				InvoiceDate:   invoice.InvoiceDate,
-				DueDate:       invoice.DueDate,
-				CustomerEmail: invoice.CustomerEmail,
-				CompanyName:   invoice.CompanyName,
-				CustomerPhone: invoice.CustomerPhone,
-				PaymentStatus: invoice.PaymentStatus,
-			},
-		},
-	}
-
-	err = h.tmpl.ExecuteTemplate(w, "invoice-list-element", data)
+	redirect(w, r, "/invoice/view/"+idStr)
+}
+
+// Issue a draft Invoice, numbering it and locking its lines
+func (h *InvoiceHandler) IssueInvoice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/invoice/issue/"), "/")
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid invoice ID", http.StatusBadRequest)
+		return
+	}
+
+	user, _ := CurrentUser(r)
+	err := h.repo.IssueInvoice(idStr, user.Id)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err == repository.ErrInvoiceIssued || err == repository.ErrDueDatePassed {
+		flash(w, r, h.tmpl, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on issuing invoice", http.StatusInternalServerError)
+		log.Printf("Database error on issuing invoice: %v\n", err)
+		return
+	}
+	log.Printf("User %d issued invoice %s", user.Id, idStr)
+
+	redirect(w, r, "/invoice/view/"+idStr)
+}
+
+// Get an Invoice
+func (h *InvoiceHandler) GetInvoice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/invoice/view/")
+	idStr = strings.TrimSuffix(idStr, "/")
+
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid invoice ID", http.StatusBadRequest)
+		return
+	}
+
+	invoice, err := h.repo.GetInvoiceById(idStr)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on fetching invoice", http.StatusInternalServerError)
+		log.Printf("Database error on fetching invoice: %v\n", err)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "invoice.html", InvoicePageData{
+		Invoice:  invoice,
+		Payments: NewPaymentsData(invoice),
+		Emails:   InvoiceEmailsData{Invoice: invoice, Enabled: h.sender.Enabled()},
+	})
 	if err != nil {
 		http.Error(w, "Error executing template", http.StatusInternalServerError)
 		log.Printf("Error executing template: %v\n", err)
 	}
 }
+
+// Get an Invoice as a PDF, served from /invoice/{id}/pdf
+func (h *InvoiceHandler) GetInvoicePDF(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 || parts[2] != "pdf" {
+		http.NotFound(w, r)
+		return
+	}
+	idStr := parts[1]
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid invoice ID", http.StatusBadRequest)
+		return
+	}
+
+	invoice, err := h.repo.GetInvoiceById(idStr)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on fetching invoice", http.StatusInternalServerError)
+		log.Printf("Database error on fetching invoice: %v\n", err)
+		return
+	}
+
+	// Render into a buffer first so a failure can still be reported as an error
+	var buf bytes.Buffer
+	if err := h.pdf.Render(&buf, invoice); err != nil {
+		http.Error(w, "Error generating invoice PDF", http.StatusInternalServerError)
+		log.Printf("Error generating invoice PDF: %v\n", err)
+		return
+	}
+
+	disposition := "inline"
+	if r.URL.Query().Get("download") != "" {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	filename := invoice.InvoiceNumber
+	if filename == "" {
+		filename = "draft-invoice-" + invoice.InvoiceId
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=%q", disposition, filename+".pdf"))
+	if _, err := buf.WriteTo(w); err != nil {
+		log.Printf("Failed to write invoice PDF: %v", err)
+	}
+}
+
+var itemFieldPattern = regexp.MustCompile(`^items\[(\d+)\]\.(\w+)$`)
+
+// parseItemList collects the indexed items[N].Field values posted by the invoice form.
+// Indexes do not need to be contiguous, blank rows are skipped and the amounts are
+// calculated here rather than taken from the form. A line with a product gets the product's
+// name, price and tax code for anything left blank.
+func parseItemList(form url.Values, taxCodes []model.TaxCode, products []model.Product, pricesIncludeTax bool) ([]model.ItemList, error) {
+	rows := make(map[int]map[string]string)
+	for key, values := range form {
+		match := itemFieldPattern.FindStringSubmatch(key)
+		if match == nil || len(values) == 0 {
+			continue
+		}
+		index, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid item index in %q", key)
+		}
+		if rows[index] == nil {
+			rows[index] = make(map[string]string)
+		}
+		rows[index][match[2]] = strings.TrimSpace(values[0])
+	}
+
+	indexes := make([]int, 0, len(rows))
+	for index := range rows {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	var items []model.ItemList
+	for _, index := range indexes {
+		row := rows[index]
+		if row["Item"] == "" && row["Quantity"] == "" && row["UnitPrice"] == "" && row["ProductId"] == "" {
+			continue
+		}
+
+		var product model.Product
+		if row["ProductId"] != "" {
+			productId, _ := strconv.Atoi(row["ProductId"])
+			found := false
+			for _, p := range products {
+				if p.ProductId == productId {
+					product, found = p, true
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("item %d has a product that isn't available", index+1)
+			}
+			if row["Item"] == "" {
+				row["Item"] = product.Name
+			}
+			if row["TaxCode"] == "" {
+				row["TaxCode"] = product.TaxCode
+			}
+		}
+		if row["Item"] == "" {
+			return nil, fmt.Errorf("item %d is missing a description", index+1)
+		}
+
+		quantity, err := strconv.ParseInt(row["Quantity"], 10, 32)
+		if err != nil || quantity <= 0 {
+			return nil, fmt.Errorf("item %q needs a quantity greater than zero", row["Item"])
+		}
+
+		var taxCode model.TaxCode
+		for _, t := range taxCodes {
+			if t.Code == row["TaxCode"] {
+				taxCode = t
+			}
+		}
+		if taxCode.Code == "" {
+			return nil, fmt.Errorf("item %q needs a tax code", row["Item"])
+		}
+
+		var unitPrice model.Money
+		if row["UnitPrice"] == "" && product.ProductId != 0 {
+			// Product prices exclude tax
+			unitPrice = product.UnitPrice
+			if pricesIncludeTax {
+				unitPrice = unitPrice.Add(taxCode.TaxOn(unitPrice, false))
+			}
+		} else {
+			unitPrice, err = model.ParseMoney(row["UnitPrice"], model.DefaultCurrency)
+			if err != nil || unitPrice.Cents < 0 {
+				return nil, fmt.Errorf("item %q has an invalid unit price, use dollars and cents like 12.50", row["Item"])
+			}
+		}
+
+		item := model.ItemList{
+			Item:      row["Item"],
+			Quantity:  int32(quantity),
+			ProductId: product.ProductId,
+			UnitPrice: unitPrice,
+			TaxCode:   taxCode.Code,
+			TaxName:   taxCode.Name,
+			TaxRate:   taxCode.Rate,
+		}
+		item.CalculateTotals(pricesIncludeTax)
+		items = append(items, item)
+	}
+
+	if len(items) == 0 {
+		return nil, fmt.Errorf("at least one item is needed")
+	}
+	return items, nil
+}
+
+// Void an issued Invoice, keeping it and its number on record
+func (h *InvoiceHandler) VoidInvoice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/invoice/void/"), "/")
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid invoice ID", http.StatusBadRequest)
+		return
+	}
+
+	// The reason comes from hx-prompt, or a form field when posted without htmx
+	reason := strings.TrimSpace(r.Header.Get("HX-Prompt"))
+	if reason == "" {
+		reason = strings.TrimSpace(r.FormValue("reason"))
+	}
+	if reason == "" {
+		flash(w, r, h.tmpl, http.StatusUnprocessableEntity, "A reason is needed to void an invoice")
+		return
+	}
+
+	user, _ := CurrentUser(r)
+	err := h.repo.VoidInvoice(idStr, reason, user.Id)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err == repository.ErrInvoiceNotIssued || err == repository.ErrInvoiceCredited || err == repository.ErrInvoicePaid {
+		flash(w, r, h.tmpl, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on voiding invoice", http.StatusInternalServerError)
+		log.Printf("Database error on voiding invoice: %v\n", err)
+		return
+	}
+	log.Printf("User %d voided invoice %s: %s", user.Id, idStr, reason)
+
+	// Rows in the invoice list are swapped in place, anywhere else reloads the invoice
+	if r.Header.Get("HX-Target") != "invoice-"+idStr {
+		redirect(w, r, "/invoice/view/"+idStr)
+		return
+	}
+	invoice, err := h.repo.GetInvoiceById(idStr)
+	if err != nil {
+		http.Error(w, "Database error on fetching invoice", http.StatusInternalServerError)
+		log.Printf("Database error on fetching invoice: %v\n", err)
+		return
+	}
+	err = h.tmpl.ExecuteTemplate(w, "invoice-list-element", invoice)
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+// Delete a draft Invoice, issued invoices have to be voided instead
+func (h *InvoiceHandler) DeleteInvoice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/invoice/delete/"), "/")
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid invoice ID", http.StatusBadRequest)
+		return
+	}
+
+	err := h.repo.DeleteDraftInvoice(idStr)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err == repository.ErrInvoiceNotDraft || err == repository.ErrDraftNumbered {
+		flash(w, r, h.tmpl, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on deleting invoice", http.StatusInternalServerError)
+		log.Printf("Database error on deleting invoice: %v\n", err)
+		return
+	}
+
+	// htmx swaps the invoice's row out for this empty response
+	w.WriteHeader(http.StatusOK)
+}
@@ -1,26 +1,36 @@
 package handler
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"path"
+	"strconv"
+	"strings"
 
 	"github.com/MrAjMann/crm/internal/model"
 	"github.com/MrAjMann/crm/internal/repository"
 )
 
 type LeadHandler struct {
-	repo *repository.LeadRepository
-	tmpl *template.Template
+	repo     *repository.LeadRepository
+	noteRepo *repository.NoteRepository
+	tmpl     *template.Template
 }
 
-func NewLeadHandler(repo *repository.LeadRepository, tmpl *template.Template) *LeadHandler {
-	return &LeadHandler{repo: repo, tmpl: tmpl}
+// LeadData is what the lead page renders, the lead plus the statuses it can move to
+type LeadData struct {
+	model.Lead
+	Statuses     []model.Status
+	NotesSection NotesData
 }
 
-
+func NewLeadHandler(repo *repository.LeadRepository, noteRepo *repository.NoteRepository, tmpl *template.Template) *LeadHandler {
+	return &LeadHandler{repo: repo, noteRepo: noteRepo, tmpl: tmpl}
+}
 
 // Get all leads
 func (h *LeadHandler) GetAllLeads(w http.ResponseWriter, r *http.Request) {
@@ -37,7 +47,26 @@ func (h *LeadHandler) GetAllLeads(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Search for a lead, an empty search lists every lead again
+func (h *LeadHandler) SearchLeads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
+	leads, err := h.repo.SearchLeads(r.URL.Query().Get("search"))
+	if err != nil {
+		http.Error(w, "Database error on fetching leads", http.StatusInternalServerError)
+		log.Printf("Database error on fetching leads: %v\n", err)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "lead-list", leads)
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
 
 func (h *LeadHandler) AddLead(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
@@ -99,14 +128,27 @@ func (h *LeadHandler) GetLead(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tmpl, err := template.ParseFiles("src/templates/lead.html")
+	statuses, err := h.repo.GetAllStatuses()
+	if err != nil {
+		http.Error(w, "Database error on fetching statuses", http.StatusInternalServerError)
+		log.Printf("Database error on fetching statuses: %v\n", err)
+		return
+	}
+
+	lead.Notes, err = h.noteRepo.GetNotesForLead(lead.LeadId, "")
 	if err != nil {
-		http.Error(w, "Error loading template", http.StatusInternalServerError)
-		log.Printf("Error loading template: %v\n", err)
+		http.Error(w, "Database error on fetching notes", http.StatusInternalServerError)
+		log.Printf("Database error on fetching notes: %v\n", err)
 		return
 	}
 
-	err = tmpl.ExecuteTemplate(w, "lead.html", lead)
+	data := LeadData{
+		Lead:         lead,
+		Statuses:     statuses,
+		NotesSection: NotesData{LeadId: lead.LeadId, Categories: model.NoteCategories, Notes: lead.Notes},
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "lead.html", data)
 
 	if err != nil {
 		http.Error(w, "Error executing lead template", http.StatusInternalServerError)
@@ -114,3 +156,202 @@ func (h *LeadHandler) GetLead(w http.ResponseWriter, r *http.Request) {
 	}
 
 }
+
+// Move a Lead to another status
+func (h *LeadHandler) UpdateLeadStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/lead/status/")
+	idStr = strings.TrimSuffix(idStr, "/")
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid lead ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		log.Printf("Error parsing form: %v\n", err)
+		return
+	}
+
+	statusId, err := strconv.Atoi(r.FormValue("statusId"))
+	if err != nil {
+		http.Error(w, "Invalid status", http.StatusBadRequest)
+		return
+	}
+
+	user, _ := CurrentUser(r)
+	err = h.repo.UpdateLeadStatus(idStr, statusId, user.Id, user.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on updating lead status", http.StatusInternalServerError)
+		log.Printf("Database error on updating lead status: %v\n", err)
+		return
+	}
+
+	lead, err := h.repo.GetLeadById(idStr)
+	if err != nil {
+		http.Error(w, "Database error on fetching lead", http.StatusInternalServerError)
+		log.Printf("Database error on fetching lead: %v\n", err)
+		return
+	}
+	statuses, err := h.repo.GetAllStatuses()
+	if err != nil {
+		http.Error(w, "Database error on fetching statuses", http.StatusInternalServerError)
+		log.Printf("Database error on fetching statuses: %v\n", err)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "lead-status", LeadData{Lead: lead, Statuses: statuses})
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+// Convert a Lead into a Customer
+func (h *LeadHandler) ConvertLead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/lead/convert/")
+	idStr = strings.TrimSuffix(idStr, "/")
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid lead ID", http.StatusBadRequest)
+		return
+	}
+
+	user, _ := CurrentUser(r)
+	customerId, err := h.repo.ConvertLeadToCustomer(idStr, user.Id, user.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on converting lead", http.StatusInternalServerError)
+		log.Printf("Database error on converting lead: %v\n", err)
+		return
+	}
+
+	redirect(w, r, fmt.Sprintf("/customer/%s", customerId))
+}
+
+// Delete a Lead. This only archives it, the notes and history are kept and it can be restored.
+func (h *LeadHandler) DeleteLead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/lead/delete/"), "/")
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid lead ID", http.StatusBadRequest)
+		return
+	}
+
+	archivedLead, err := h.repo.ArchiveLeadById(idStr)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on archiving lead", http.StatusInternalServerError)
+		log.Printf("Database error on archiving lead: %v\n", err)
+		return
+	}
+	log.Printf("Archived lead: %d - %s %s", archivedLead.LeadId, archivedLead.FirstName, archivedLead.LastName)
+
+	// htmx swaps the lead's row out for this empty response
+	w.WriteHeader(http.StatusOK)
+}
+
+// Get all archived Leads
+func (h *LeadHandler) GetArchivedLeads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	leads, err := h.repo.GetArchivedLeads()
+	if err != nil {
+		http.Error(w, "Database error on fetching archived leads", http.StatusInternalServerError)
+		log.Printf("Database error on fetching archived leads: %v\n", err)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "archivedLeads.html", leads)
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+// Restore an archived Lead
+func (h *LeadHandler) RestoreLead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/lead/restore/"), "/")
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid lead ID", http.StatusBadRequest)
+		return
+	}
+
+	restoredLead, err := h.repo.RestoreLeadById(idStr)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on restoring lead", http.StatusInternalServerError)
+		log.Printf("Database error on restoring lead: %v\n", err)
+		return
+	}
+	log.Printf("Restored lead: %d - %s %s", restoredLead.LeadId, restoredLead.FirstName, restoredLead.LastName)
+
+	// The lead is no longer archived, so its row leaves the archived list
+	w.WriteHeader(http.StatusOK)
+}
+
+// Purge an archived Lead for good
+func (h *LeadHandler) PurgeLead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/lead/purge/"), "/")
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid lead ID", http.StatusBadRequest)
+		return
+	}
+
+	purgedLead, err := h.repo.PurgeLeadById(idStr)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err == repository.ErrLeadNotArchived {
+		flash(w, r, h.tmpl, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on purging lead", http.StatusInternalServerError)
+		log.Printf("Database error on purging lead: %v\n", err)
+		return
+	}
+
+	user, _ := CurrentUser(r)
+	log.Printf("User %d purged lead: %d - %s %s", user.Id, purgedLead.LeadId, purgedLead.FirstName, purgedLead.LastName)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,283 @@
+package handler
+
+import (
+	"database/sql"
+	"html/template"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/MrAjMann/crm/internal/model"
+	"github.com/MrAjMann/crm/internal/repository"
+)
+
+type NoteHandler struct {
+	repo *repository.NoteRepository
+	tmpl *template.Template
+}
+
+// NotesData is what the notes section on the customer and lead pages renders.
+// Only one of CustomerId and LeadId is set.
+type NotesData struct {
+	CustomerId int
+	LeadId     int
+	Category   model.NoteCategory
+	Categories []model.NoteCategory
+	Notes      []model.Note
+}
+
+func NewNoteHandler(repo *repository.NoteRepository, tmpl *template.Template) *NoteHandler {
+	return &NoteHandler{repo: repo, tmpl: tmpl}
+}
+
+// Get the notes for a customer or lead, optionally filtered by category
+func (h *NoteHandler) GetNotes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, ok := notesOwner(w, r)
+	if !ok {
+		return
+	}
+
+	data.Category = model.NoteCategory(r.URL.Query().Get("category"))
+	if data.Category != "" && !data.Category.Valid() {
+		http.Error(w, "Invalid note category", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if data.CustomerId != 0 {
+		data.Notes, err = h.repo.GetNotesForCustomer(data.CustomerId, data.Category)
+	} else {
+		data.Notes, err = h.repo.GetNotesForLead(data.LeadId, data.Category)
+	}
+	if err != nil {
+		http.Error(w, "Database error on fetching notes", http.StatusInternalServerError)
+		log.Printf("Database error on fetching notes: %v\n", err)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "note-list", data)
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+// Add a Note to a customer or lead
+func (h *NoteHandler) AddNote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		log.Printf("Error parsing form: %v\n", err)
+		return
+	}
+
+	owner, ok := notesOwner(w, r)
+	if !ok {
+		return
+	}
+
+	note, ok := noteFromForm(w, r)
+	if !ok {
+		return
+	}
+	if owner.CustomerId != 0 {
+		note.CustomerId = &owner.CustomerId
+	} else {
+		note.LeadId = &owner.LeadId
+	}
+	if user, ok := CurrentUser(r); ok {
+		note.AuthorId = user.Id
+		note.AuthorName = user.Name
+	}
+
+	note, err := h.repo.AddNote(note)
+	if err != nil {
+		http.Error(w, "Database error on inserting new note", http.StatusInternalServerError)
+		log.Printf("Database error on inserting new note: %v\n", err)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "note-item", note)
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+// Get a Note, used to put it back after cancelling an edit
+func (h *NoteHandler) GetNote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	base, idStr := path.Split(r.URL.Path)
+	if base != "/note/" {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	h.renderNote(w, r, idStr, "note-item")
+}
+
+// Get the inline edit form for a Note
+func (h *NoteHandler) EditNote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/note/edit/"), "/")
+	h.renderNote(w, r, idStr, "note-edit-form")
+}
+
+// Update a Note
+func (h *NoteHandler) UpdateNote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PUT" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/note/update/"), "/")
+	noteId, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid note ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		log.Printf("Error parsing form: %v\n", err)
+		return
+	}
+
+	note, ok := noteFromForm(w, r)
+	if !ok {
+		return
+	}
+	note.NoteId = noteId
+
+	note, err = h.repo.UpdateNote(note)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on updating note", http.StatusInternalServerError)
+		log.Printf("Database error on updating note: %v\n", err)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "note-item", note)
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+// Delete a Note
+func (h *NoteHandler) DeleteNote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/note/delete/"), "/")
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid note ID", http.StatusBadRequest)
+		return
+	}
+
+	err := h.repo.DeleteNoteById(idStr)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on deleting note", http.StatusInternalServerError)
+		log.Printf("Database error on deleting note: %v\n", err)
+		return
+	}
+
+	// htmx swaps the note out for this empty response
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *NoteHandler) renderNote(w http.ResponseWriter, r *http.Request, idStr string, templateName string) {
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid note ID", http.StatusBadRequest)
+		return
+	}
+
+	note, err := h.repo.GetNoteById(idStr)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on fetching note", http.StatusInternalServerError)
+		log.Printf("Database error on fetching note: %v\n", err)
+		return
+	}
+
+	data := struct {
+		model.Note
+		Categories []model.NoteCategory
+	}{note, model.NoteCategories}
+
+	err = h.tmpl.ExecuteTemplate(w, templateName, data)
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+// notesOwner reads which customer or lead the notes belong to, exactly one must be given
+func notesOwner(w http.ResponseWriter, r *http.Request) (NotesData, bool) {
+	data := NotesData{Categories: model.NoteCategories}
+	customerIdStr, leadIdStr := r.FormValue("customerId"), r.FormValue("leadId")
+
+	if (customerIdStr == "") == (leadIdStr == "") {
+		http.Error(w, "Notes need either a customerId or a leadId", http.StatusBadRequest)
+		return data, false
+	}
+
+	var err error
+	if customerIdStr != "" {
+		data.CustomerId, err = strconv.Atoi(customerIdStr)
+	} else {
+		data.LeadId, err = strconv.Atoi(leadIdStr)
+	}
+	if err != nil {
+		http.Error(w, "Invalid customer or lead ID", http.StatusBadRequest)
+		return data, false
+	}
+	return data, true
+}
+
+// noteFromForm reads and validates the category and content fields
+func noteFromForm(w http.ResponseWriter, r *http.Request) (model.Note, bool) {
+	note := model.Note{
+		Category: model.NoteCategory(r.FormValue("category")),
+		Content:  strings.TrimSpace(r.FormValue("content")),
+	}
+	if !note.Category.Valid() {
+		http.Error(w, "Invalid note category", http.StatusBadRequest)
+		return note, false
+	}
+	if note.Content == "" {
+		http.Error(w, "A note needs some content", http.StatusBadRequest)
+		return note, false
+	}
+	return note, true
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"database/sql"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MrAjMann/crm/internal/model"
+	"github.com/MrAjMann/crm/internal/repository"
+)
+
+type PaymentHandler struct {
+	repo *repository.PaymentRepository
+	tmpl *template.Template
+}
+
+// PaymentsData is the payments section of an invoice, with the methods to choose from
+type PaymentsData struct {
+	Invoice model.Invoice
+	Methods []model.PaymentMethod
+	Today   time.Time
+}
+
+func NewPaymentHandler(repo *repository.PaymentRepository, tmpl *template.Template) *PaymentHandler {
+	return &PaymentHandler{repo: repo, tmpl: tmpl}
+}
+
+// Record a full or partial Payment against an Invoice
+func (h *PaymentHandler) RecordPayment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/invoice/payment/"), "/")
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid invoice ID", http.StatusBadRequest)
+		return
+	}
+
+	payment := model.Payment{
+		InvoiceId: idStr,
+		Method:    model.PaymentMethod(r.FormValue("method")),
+		Reference: strings.TrimSpace(r.FormValue("reference")),
+	}
+
+	var err error
+	payment.Amount, err = model.ParseMoney(r.FormValue("amount"), model.DefaultCurrency)
+	if err != nil || payment.Amount.Cents <= 0 {
+		flash(w, r, h.tmpl, http.StatusUnprocessableEntity, "Enter the amount paid in dollars and cents, like 12.50")
+		return
+	}
+	payment.PaidOn, err = time.Parse("2006-01-02", r.FormValue("paidOn"))
+	if err != nil {
+		flash(w, r, h.tmpl, http.StatusUnprocessableEntity, "Enter the date the payment was made")
+		return
+	}
+	if payment.PaidOn.After(time.Now()) {
+		flash(w, r, h.tmpl, http.StatusUnprocessableEntity, "Payments can't be dated in the future")
+		return
+	}
+	if !payment.Method.Valid() {
+		flash(w, r, h.tmpl, http.StatusUnprocessableEntity, "Choose how the payment was made")
+		return
+	}
+	if user, ok := CurrentUser(r); ok {
+		payment.RecordedById = user.Id
+	}
+
+	invoice, err := h.repo.RecordPayment(payment)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err == repository.ErrInvoiceNotPayable || err == repository.ErrOverpayment {
+		flash(w, r, h.tmpl, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on recording payment", http.StatusInternalServerError)
+		log.Printf("Database error on recording payment: %v\n", err)
+		return
+	}
+
+	h.renderPayments(w, invoice)
+}
+
+// Delete a Payment recorded by mistake
+func (h *PaymentHandler) DeletePayment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/payment/delete/"), "/")
+	paymentId, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid payment ID", http.StatusBadRequest)
+		return
+	}
+
+	var deletedById int
+	if user, ok := CurrentUser(r); ok {
+		deletedById = user.Id
+	}
+	invoice, err := h.repo.DeletePayment(paymentId, deletedById)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err == repository.ErrRefundNotDeleted || err == repository.ErrPaymentRefunded || err == repository.ErrPaymentNotDeleted {
+		flash(w, r, h.tmpl, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on deleting payment", http.StatusInternalServerError)
+		log.Printf("Database error on deleting payment: %v\n", err)
+		return
+	}
+	log.Printf("Payment %d deleted from invoice %s", paymentId, invoice.InvoiceNumber)
+
+	h.renderPayments(w, invoice)
+}
+
+func (h *PaymentHandler) renderPayments(w http.ResponseWriter, invoice model.Invoice) {
+	err := h.tmpl.ExecuteTemplate(w, "invoice-payments", NewPaymentsData(invoice))
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+// NewPaymentsData builds the payments section for an invoice
+func NewPaymentsData(invoice model.Invoice) PaymentsData {
+	return PaymentsData{Invoice: invoice, Methods: model.PaymentMethods, Today: time.Now()}
+}
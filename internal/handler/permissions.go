@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/MrAjMann/crm/internal/model"
+)
+
+type ForbiddenData struct {
+	User       model.User
+	Permission model.Permission
+}
+
+// Require only lets users whose role has the permission through to next.
+// Everyone else gets a 403, as a fragment for htmx requests or a full page otherwise.
+func (h *AuthHandler) Require(permission model.Permission, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := CurrentUser(r)
+		if ok && user.Role.Can(permission) {
+			next(w, r)
+			return
+		}
+
+		log.Printf("User %d (%s) was refused %s on %s %s", user.Id, user.Role, permission, r.Method, r.URL.Path)
+		h.forbidden(w, r, ForbiddenData{User: user, Permission: permission})
+	}
+}
+
+func (h *AuthHandler) forbidden(w http.ResponseWriter, r *http.Request, data ForbiddenData) {
+	templateName := "forbidden.html"
+	if isHTMX(r) {
+		// Show the message in the page's flash area rather than wherever the request was aimed
+		templateName = "forbidden"
+		w.Header().Set("HX-Retarget", "#flash-messages")
+		w.Header().Set("HX-Reswap", "innerHTML")
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusForbidden)
+	if err := h.tmpl.ExecuteTemplate(w, templateName, data); err != nil {
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
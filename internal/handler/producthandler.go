@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/MrAjMann/crm/internal/model"
+	"github.com/MrAjMann/crm/internal/repository"
+)
+
+type ProductHandler struct {
+	repo        *repository.ProductRepository
+	taxCodeRepo *repository.TaxCodeRepository
+	tmpl        *template.Template
+}
+
+// ProductRow is a row on the products page, with the tax codes to choose from. Inactive codes
+// are included so a product keeps its code until it is changed.
+type ProductRow struct {
+	model.Product
+	TaxCodes []model.TaxCode
+}
+
+// ProductsData is the products page
+type ProductsData struct {
+	Products []ProductRow
+	TaxCodes []model.TaxCode
+}
+
+func NewProductHandler(repo *repository.ProductRepository, taxCodeRepo *repository.TaxCodeRepository, tmpl *template.Template) *ProductHandler {
+	return &ProductHandler{repo: repo, taxCodeRepo: taxCodeRepo, tmpl: tmpl}
+}
+
+// Get all Products, including inactive ones
+func (h *ProductHandler) GetAllProducts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	products, err := h.repo.GetAllProducts(true)
+	if err != nil {
+		http.Error(w, "Database error on fetching products", http.StatusInternalServerError)
+		log.Printf("Database error on fetching products: %v\n", err)
+		return
+	}
+	taxCodes, err := h.taxCodeRepo.GetAllTaxCodes(true)
+	if err != nil {
+		http.Error(w, "Database error on fetching tax codes", http.StatusInternalServerError)
+		log.Printf("Database error on fetching tax codes: %v\n", err)
+		return
+	}
+
+	data := ProductsData{TaxCodes: taxCodes}
+	for _, product := range products {
+		data.Products = append(data.Products, ProductRow{Product: product, TaxCodes: taxCodes})
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "products.html", data)
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+// Add a new Product
+func (h *ProductHandler) AddProduct(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	product, err := h.parseProductForm(r)
+	if err != nil {
+		flash(w, r, h.tmpl, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	product.Active = true
+
+	product, err = h.repo.AddProduct(product)
+	if err == repository.ErrProductExists {
+		flash(w, r, h.tmpl, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on adding product", http.StatusInternalServerError)
+		log.Printf("Database error on adding product: %v\n", err)
+		return
+	}
+
+	h.renderRow(w, product)
+}
+
+// Update a Product's details
+func (h *ProductHandler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PUT" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/product/update/"), "/")
+	productId, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	product, err := h.parseProductForm(r)
+	if err != nil {
+		flash(w, r, h.tmpl, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	product.ProductId = productId
+	product.Active = r.FormValue("active") != ""
+
+	product, err = h.repo.UpdateProduct(product)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err == repository.ErrProductExists {
+		flash(w, r, h.tmpl, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on updating product", http.StatusInternalServerError)
+		log.Printf("Database error on updating product: %v\n", err)
+		return
+	}
+
+	h.renderRow(w, product)
+}
+
+func (h *ProductHandler) renderRow(w http.ResponseWriter, product model.Product) {
+	taxCodes, err := h.taxCodeRepo.GetAllTaxCodes(true)
+	if err != nil {
+		http.Error(w, "Database error on fetching tax codes", http.StatusInternalServerError)
+		log.Printf("Database error on fetching tax codes: %v\n", err)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "product-row", ProductRow{Product: product, TaxCodes: taxCodes})
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+// parseProductForm reads and checks the product fields, the tax code has to exist
+func (h *ProductHandler) parseProductForm(r *http.Request) (model.Product, error) {
+	product := model.Product{
+		Name:        strings.TrimSpace(r.FormValue("name")),
+		Description: strings.TrimSpace(r.FormValue("description")),
+		TaxCode:     r.FormValue("taxCode"),
+	}
+	if product.Name == "" {
+		return product, fmt.Errorf("a product needs a name")
+	}
+
+	var err error
+	product.UnitPrice, err = model.ParseMoney(r.FormValue("unitPrice"), model.DefaultCurrency)
+	if err != nil || product.UnitPrice.Cents < 0 {
+		return product, fmt.Errorf("invalid unit price, use dollars and cents like 12.50")
+	}
+
+	if _, err := h.taxCodeRepo.GetTaxCode(product.TaxCode); err == sql.ErrNoRows {
+		return product, fmt.Errorf("unknown tax code %q", product.TaxCode)
+	} else if err != nil {
+		return product, err
+	}
+	return product, nil
+}
@@ -0,0 +1,265 @@
+package handler
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MrAjMann/crm/internal/model"
+	"github.com/MrAjMann/crm/internal/repository"
+)
+
+type RecurringInvoiceHandler struct {
+	repo        *repository.RecurringInvoiceRepository
+	invoiceRepo *repository.InvoiceRepository
+	taxCodeRepo *repository.TaxCodeRepository
+	productRepo *repository.ProductRepository
+	tmpl        *template.Template
+}
+
+// RecurringInvoicePageData is a schedule's page, the schedule with the invoices it has raised
+type RecurringInvoicePageData struct {
+	model.RecurringInvoice
+	Invoices []model.Invoice
+}
+
+func NewRecurringInvoiceHandler(repo *repository.RecurringInvoiceRepository, invoiceRepo *repository.InvoiceRepository, taxCodeRepo *repository.TaxCodeRepository, productRepo *repository.ProductRepository, tmpl *template.Template) *RecurringInvoiceHandler {
+	return &RecurringInvoiceHandler{repo: repo, invoiceRepo: invoiceRepo, taxCodeRepo: taxCodeRepo, productRepo: productRepo, tmpl: tmpl}
+}
+
+// Get the recurring invoices page
+func (h *RecurringInvoiceHandler) GetAllRecurringInvoices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	schedules, err := h.repo.GetAllRecurringInvoices()
+	if err != nil {
+		http.Error(w, "Database error on fetching recurring invoices", http.StatusInternalServerError)
+		log.Printf("Database error on fetching recurring invoices: %v\n", err)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "recurringInvoices.html", schedules)
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+// Get the create recurring invoice page, the same form as a new invoice with the schedule in place of the due date
+func (h *RecurringInvoiceHandler) CreateRecurringInvoice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data := InvoiceFormData{Recurring: true, Units: model.RecurrenceUnits}
+	var err error
+	if data.TaxCodes, err = h.taxCodeRepo.GetAllTaxCodes(false); err == nil {
+		data.Products, err = h.productRepo.GetAllProducts(false)
+	}
+	if err != nil {
+		http.Error(w, "Database error on fetching tax codes and products", http.StatusInternalServerError)
+		log.Printf("Database error on fetching tax codes and products: %v\n", err)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "createInvoice.html", data)
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+func (h *RecurringInvoiceHandler) AddRecurringInvoice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		log.Printf("Error parsing form: %v\n", err)
+		return
+	}
+
+	customerId := r.FormValue("customerId")
+	if _, err := strconv.Atoi(customerId); err != nil {
+		http.Error(w, "Please select a customer", http.StatusBadRequest)
+		return
+	}
+
+	var billingAddressId int
+	if billingAddressStr := r.FormValue("billingAddressId"); billingAddressStr != "" {
+		billingAddressId, err = strconv.Atoi(billingAddressStr)
+		if err != nil {
+			http.Error(w, "Invalid billing address", http.StatusBadRequest)
+			return
+		}
+	}
+
+	unit := model.RecurrenceUnit(r.FormValue("unit"))
+	if !unit.Valid() {
+		http.Error(w, "Invalid interval", http.StatusBadRequest)
+		return
+	}
+	every, err := strconv.Atoi(r.FormValue("every"))
+	if err != nil || every < 1 || every > 52 {
+		http.Error(w, "Invoice every 1 to 52 weeks, months or years", http.StatusBadRequest)
+		return
+	}
+
+	startDate, err := time.Parse("2006-01-02", r.FormValue("StartDate"))
+	if err != nil {
+		http.Error(w, "Invalid start date", http.StatusBadRequest)
+		return
+	}
+	var endDate *time.Time
+	if endDateStr := r.FormValue("EndDate"); endDateStr != "" {
+		end, err := time.Parse("2006-01-02", endDateStr)
+		if err != nil || end.Before(startDate) {
+			http.Error(w, "Invalid end date, it can't be before the start date", http.StatusBadRequest)
+			return
+		}
+		endDate = &end
+	}
+
+	dueDays := 14
+	if dueDaysStr := r.FormValue("dueDays"); dueDaysStr != "" {
+		dueDays, err = strconv.Atoi(dueDaysStr)
+		if err != nil || dueDays < 0 || dueDays > 365 {
+			http.Error(w, "Invalid days to pay", http.StatusBadRequest)
+			return
+		}
+	}
+
+	pricesIncludeTax := r.FormValue("pricesIncludeTax") != ""
+
+	taxCodes, err := h.taxCodeRepo.GetAllTaxCodes(false)
+	if err != nil {
+		http.Error(w, "Database error on fetching tax codes", http.StatusInternalServerError)
+		log.Printf("Database error on fetching tax codes: %v\n", err)
+		return
+	}
+	products, err := h.productRepo.GetAllProducts(false)
+	if err != nil {
+		http.Error(w, "Database error on fetching products", http.StatusInternalServerError)
+		log.Printf("Database error on fetching products: %v\n", err)
+		return
+	}
+
+	items, err := parseItemList(r.PostForm, taxCodes, products, pricesIncludeTax)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	schedule := model.RecurringInvoice{
+		CustomerId:       customerId,
+		BillingAddressId: billingAddressId,
+		Description:      strings.TrimSpace(r.FormValue("description")),
+		Unit:             unit,
+		Every:            every,
+		StartDate:        startDate,
+		EndDate:          endDate,
+		DueDays:          dueDays,
+		AutoSend:         r.FormValue("autoSend") != "",
+		ItemList:         items,
+		Currency:         model.DefaultCurrency,
+		TaxRounding:      model.DefaultTaxRounding,
+		PricesIncludeTax: pricesIncludeTax,
+	}
+	schedule.CalculateTotals()
+	if user, ok := CurrentUser(r); ok {
+		schedule.CreatedById = user.Id
+	}
+
+	scheduleId, err := h.repo.AddRecurringInvoice(schedule)
+	if err != nil {
+		http.Error(w, "Database error on creating recurring invoice", http.StatusInternalServerError)
+		log.Printf("Database error on creating recurring invoice: %v\n", err)
+		return
+	}
+
+	w.Header().Set("HX-Redirect", fmt.Sprintf("/recurring-invoice/view/%s", scheduleId))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// Get a Recurring Invoice schedule and the invoices it has raised
+func (h *RecurringInvoiceHandler) GetRecurringInvoice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/recurring-invoice/view/"), "/")
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid recurring invoice ID", http.StatusBadRequest)
+		return
+	}
+
+	schedule, err := h.repo.GetRecurringInvoiceById(idStr)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on fetching recurring invoice", http.StatusInternalServerError)
+		log.Printf("Database error on fetching recurring invoice: %v\n", err)
+		return
+	}
+
+	invoices, err := h.invoiceRepo.SearchInvoices(model.InvoiceFilter{ScheduleId: idStr})
+	if err != nil {
+		http.Error(w, "Database error on fetching invoices", http.StatusInternalServerError)
+		log.Printf("Database error on fetching invoices: %v\n", err)
+		return
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "recurringInvoice.html", RecurringInvoicePageData{RecurringInvoice: schedule, Invoices: invoices})
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+// Pause or resume a Recurring Invoice schedule
+func (h *RecurringInvoiceHandler) SetRecurringInvoiceActive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/recurring-invoice/active/"), "/")
+	if _, err := strconv.Atoi(idStr); err != nil {
+		http.Error(w, "Invalid recurring invoice ID", http.StatusBadRequest)
+		return
+	}
+
+	active, err := strconv.ParseBool(r.FormValue("active"))
+	if err != nil {
+		http.Error(w, "Invalid active value", http.StatusBadRequest)
+		return
+	}
+
+	err = h.repo.SetRecurringInvoiceActive(idStr, active)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on updating recurring invoice", http.StatusInternalServerError)
+		log.Printf("Database error on updating recurring invoice: %v\n", err)
+		return
+	}
+
+	redirect(w, r, "/recurring-invoice/view/"+idStr)
+}
@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/MrAjMann/crm/internal/invoicemail"
+	"github.com/MrAjMann/crm/internal/model"
+	"github.com/MrAjMann/crm/internal/repository"
+)
+
+type ReminderHandler struct {
+	repo   *repository.ReminderRepository
+	sender *invoicemail.Sender
+	tmpl   *template.Template
+}
+
+// ReminderStepRow is a row on the reminders page, with the email templates to choose from
+type ReminderStepRow struct {
+	model.ReminderStep
+	Templates []string
+}
+
+// RemindersData is the reminders page
+type RemindersData struct {
+	Steps     []ReminderStepRow
+	Templates []string
+	Enabled   bool // There is a mail server to send reminders through
+}
+
+func NewReminderHandler(repo *repository.ReminderRepository, sender *invoicemail.Sender, tmpl *template.Template) *ReminderHandler {
+	return &ReminderHandler{repo: repo, sender: sender, tmpl: tmpl}
+}
+
+// reminderTemplatePrefix is how the payment reminder emails in src/templates/email are named
+const reminderTemplatePrefix = "reminder"
+
+// Get the payment reminder sequence
+func (h *ReminderHandler) GetReminderSteps(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	steps, err := h.repo.GetAllReminderSteps()
+	if err != nil {
+		http.Error(w, "Database error on fetching reminder steps", http.StatusInternalServerError)
+		log.Printf("Database error on fetching reminder steps: %v\n", err)
+		return
+	}
+
+	templates := h.sender.Templates().Names(reminderTemplatePrefix)
+	data := RemindersData{Templates: templates, Enabled: h.sender.Enabled()}
+	for _, step := range steps {
+		data.Steps = append(data.Steps, ReminderStepRow{ReminderStep: step, Templates: templates})
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "reminders.html", data)
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+// Add a step to the payment reminder sequence
+func (h *ReminderHandler) AddReminderStep(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	step, err := h.parseReminderStepForm(r)
+	if err != nil {
+		flash(w, r, h.tmpl, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	step.Active = true
+
+	step, err = h.repo.AddReminderStep(step)
+	if err == repository.ErrReminderStepExists {
+		flash(w, r, h.tmpl, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on adding reminder step", http.StatusInternalServerError)
+		log.Printf("Database error on adding reminder step: %v\n", err)
+		return
+	}
+
+	h.renderRow(w, step)
+}
+
+// Update a reminder step's timing, wording and whether it is sent
+func (h *ReminderHandler) UpdateReminderStep(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PUT" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/reminder-step/update/"), "/")
+	stepId, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid reminder step ID", http.StatusBadRequest)
+		return
+	}
+
+	step, err := h.parseReminderStepForm(r)
+	if err != nil {
+		flash(w, r, h.tmpl, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	step.StepId = stepId
+	step.Active = r.FormValue("active") != ""
+
+	step, err = h.repo.UpdateReminderStep(step)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err == repository.ErrReminderStepExists {
+		flash(w, r, h.tmpl, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on updating reminder step", http.StatusInternalServerError)
+		log.Printf("Database error on updating reminder step: %v\n", err)
+		return
+	}
+
+	h.renderRow(w, step)
+}
+
+func (h *ReminderHandler) renderRow(w http.ResponseWriter, step model.ReminderStep) {
+	err := h.tmpl.ExecuteTemplate(w, "reminder-step-row", ReminderStepRow{ReminderStep: step, Templates: h.sender.Templates().Names(reminderTemplatePrefix)})
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+// parseReminderStepForm reads and checks the step fields. The subject is tried out on a made up invoice so
+// a mistake in it shows up now rather than when the reminders go out.
+func (h *ReminderHandler) parseReminderStepForm(r *http.Request) (model.ReminderStep, error) {
+	step := model.ReminderStep{
+		Name:     strings.TrimSpace(r.FormValue("name")),
+		Subject:  strings.TrimSpace(r.FormValue("subject")),
+		Template: r.FormValue("template"),
+	}
+	if step.Name == "" {
+		return step, fmt.Errorf("a reminder needs a name")
+	}
+
+	days, err := strconv.Atoi(strings.TrimSpace(r.FormValue("daysAfterDue")))
+	if err != nil || days < -90 || days > 365 {
+		return step, fmt.Errorf("days from the due date must be a whole number between -90 and 365")
+	}
+	step.DaysAfterDue = days
+
+	if !slices.Contains(h.sender.Templates().Names(reminderTemplatePrefix), step.Template) {
+		return step, fmt.Errorf("choose the email to send")
+	}
+	if step.Subject == "" {
+		return step, fmt.Errorf("a reminder needs a subject")
+	}
+	sample := invoicemail.ReminderData{
+		EmailData: invoicemail.EmailData{Invoice: model.Invoice{InvoiceNumber: "INV0001"}},
+		Step:      step,
+	}
+	if _, err := invoicemail.ExecuteSubject(step.Subject, sample); err != nil {
+		return step, fmt.Errorf("the subject can't be filled in: %v", err)
+	}
+	return step, nil
+}
@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/MrAjMann/crm/internal/model"
+	"github.com/MrAjMann/crm/internal/repository"
+)
+
+type TaxCodeHandler struct {
+	repo *repository.TaxCodeRepository
+	tmpl *template.Template
+}
+
+// TaxCodeRow is a row on the tax codes page, with the kinds to choose from
+type TaxCodeRow struct {
+	model.TaxCode
+	Kinds []model.TaxKind
+}
+
+func NewTaxCodeHandler(repo *repository.TaxCodeRepository, tmpl *template.Template) *TaxCodeHandler {
+	return &TaxCodeHandler{repo: repo, tmpl: tmpl}
+}
+
+// Get all Tax Codes, including inactive ones
+func (h *TaxCodeHandler) GetAllTaxCodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	taxCodes, err := h.repo.GetAllTaxCodes(true)
+	if err != nil {
+		http.Error(w, "Database error on fetching tax codes", http.StatusInternalServerError)
+		log.Printf("Database error on fetching tax codes: %v\n", err)
+		return
+	}
+
+	rows := make([]TaxCodeRow, 0, len(taxCodes))
+	for _, taxCode := range taxCodes {
+		rows = append(rows, TaxCodeRow{TaxCode: taxCode, Kinds: model.TaxKinds})
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "taxCodes.html", rows)
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+// Add a new Tax Code
+func (h *TaxCodeHandler) AddTaxCode(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	taxCode, err := parseTaxCodeForm(r, r.FormValue("code"))
+	if err != nil {
+		flash(w, r, h.tmpl, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	taxCode.Active = true
+
+	taxCode, err = h.repo.AddTaxCode(taxCode)
+	if err == repository.ErrTaxCodeExists {
+		flash(w, r, h.tmpl, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on adding tax code", http.StatusInternalServerError)
+		log.Printf("Database error on adding tax code: %v\n", err)
+		return
+	}
+
+	h.renderRow(w, taxCode)
+}
+
+// Update a Tax Code's name, kind, rate and whether it can be used on new invoices
+func (h *TaxCodeHandler) UpdateTaxCode(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PUT" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	code := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/tax-code/update/"), "/")
+	taxCode, err := parseTaxCodeForm(r, code)
+	if err != nil {
+		flash(w, r, h.tmpl, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	taxCode.Active = r.FormValue("active") != ""
+
+	taxCode, err = h.repo.UpdateTaxCode(taxCode)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error on updating tax code", http.StatusInternalServerError)
+		log.Printf("Database error on updating tax code: %v\n", err)
+		return
+	}
+
+	h.renderRow(w, taxCode)
+}
+
+func (h *TaxCodeHandler) renderRow(w http.ResponseWriter, taxCode model.TaxCode) {
+	err := h.tmpl.ExecuteTemplate(w, "tax-code-row", TaxCodeRow{TaxCode: taxCode, Kinds: model.TaxKinds})
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+var taxCodePattern = regexp.MustCompile(`^[A-Z0-9-]{1,10}$`)
+
+// parseTaxCodeForm reads and checks the tax code fields, only taxable codes can have a rate
+func parseTaxCodeForm(r *http.Request, code string) (model.TaxCode, error) {
+	taxCode := model.TaxCode{
+		Code: strings.ToUpper(strings.TrimSpace(code)),
+		Name: strings.TrimSpace(r.FormValue("name")),
+		Kind: model.TaxKind(r.FormValue("kind")),
+	}
+	if !taxCodePattern.MatchString(taxCode.Code) {
+		return taxCode, fmt.Errorf("codes are up to 10 letters, numbers or dashes")
+	}
+	if taxCode.Name == "" {
+		return taxCode, fmt.Errorf("a tax code needs a name")
+	}
+	if !taxCode.Kind.Valid() {
+		return taxCode, fmt.Errorf("invalid kind %q", taxCode.Kind)
+	}
+
+	var err error
+	if rate := r.FormValue("rate"); rate != "" {
+		if taxCode.Rate, err = model.ParseTaxRate(rate); err != nil {
+			return taxCode, err
+		}
+	}
+	if taxCode.Kind != model.Taxable && taxCode.Rate != 0 {
+		return taxCode, fmt.Errorf("%s supplies don't have a tax rate", taxCode.Kind)
+	}
+	return taxCode, nil
+}
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"database/sql"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/MrAjMann/crm/internal/model"
+	"github.com/MrAjMann/crm/internal/repository"
+)
+
+type UserHandler struct {
+	repo *repository.UserRepository
+	tmpl *template.Template
+}
+
+// UserRow is a row on the users page, with the roles to choose from
+type UserRow struct {
+	model.User
+	Roles []model.Role
+	Error string
+}
+
+func NewUserHandler(repo *repository.UserRepository, tmpl *template.Template) *UserHandler {
+	return &UserHandler{repo: repo, tmpl: tmpl}
+}
+
+// Get all Users, for assigning roles
+func (h *UserHandler) GetAllUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	users, err := h.repo.GetAllUsers()
+	if err != nil {
+		http.Error(w, "Database error on fetching users", http.StatusInternalServerError)
+		log.Printf("Database error on fetching users: %v\n", err)
+		return
+	}
+
+	rows := make([]UserRow, 0, len(users))
+	for _, user := range users {
+		rows = append(rows, UserRow{User: user, Roles: model.Roles})
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "users.html", rows)
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
+
+// Update a User's role
+func (h *UserHandler) UpdateUserRole(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/user/role/"), "/")
+	userId, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	role := model.Role(r.FormValue("role"))
+	if !role.Valid() {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+
+	row := UserRow{Roles: model.Roles}
+	row.User, err = h.repo.UpdateUserRole(userId, role)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err == repository.ErrLastAdmin {
+		// Put the row back as it was with the reason the change was refused
+		row.User.Role = model.AdminRole
+		row.Error = err.Error()
+	} else if err != nil {
+		http.Error(w, "Database error on updating user role", http.StatusInternalServerError)
+		log.Printf("Database error on updating user role: %v\n", err)
+		return
+	} else {
+		log.Printf("User %d role changed to %s", userId, role)
+	}
+
+	err = h.tmpl.ExecuteTemplate(w, "user-list-element", row)
+	if err != nil {
+		http.Error(w, "Error executing template", http.StatusInternalServerError)
+		log.Printf("Error executing template: %v\n", err)
+	}
+}
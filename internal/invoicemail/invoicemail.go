@@ -0,0 +1,271 @@
+// Package invoicemail emails invoices and payment reminders to customers: it renders the email templates,
+// attaches the invoice PDF, sends it with a mailer and logs the attempt against the invoice.
+package invoicemail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/MrAjMann/crm/generator"
+	"github.com/MrAjMann/crm/internal/mailer"
+	"github.com/MrAjMann/crm/internal/model"
+	"github.com/MrAjMann/crm/internal/repository"
+)
+
+var (
+	ErrEmailDisabled = errors.New("email isn't set up, set SMTP_HOST in .env to send invoices")
+	ErrNotSendable   = errors.New("only issued invoices can be sent")
+)
+
+// Templates are the emails sent to customers. Each email has a NAME.txt text/template and a NAME.html html/template.
+type Templates struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// ParseTemplates reads the *.txt and *.html email templates in dir
+func ParseTemplates(dir string) (*Templates, error) {
+	text, err := texttemplate.ParseGlob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing text email templates: %v", err)
+	}
+	html, err := htmltemplate.ParseGlob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing HTML email templates: %v", err)
+	}
+	return &Templates{text: text, html: html}, nil
+}
+
+// render executes NAME.txt and NAME.html with data
+func (t *Templates) render(name string, data any) (text string, html string, err error) {
+	var textBuf, htmlBuf bytes.Buffer
+	if err := t.text.ExecuteTemplate(&textBuf, name+".txt", data); err != nil {
+		return "", "", fmt.Errorf("error executing email template %s.txt: %v", name, err)
+	}
+	if err := t.html.ExecuteTemplate(&htmlBuf, name+".html", data); err != nil {
+		return "", "", fmt.Errorf("error executing email template %s.html: %v", name, err)
+	}
+	return textBuf.String(), htmlBuf.String(), nil
+}
+
+// Names lists the emails starting with prefix that have both a text and an HTML template
+func (t *Templates) Names(prefix string) []string {
+	var names []string
+	for _, text := range t.text.Templates() {
+		name, ok := strings.CutSuffix(text.Name(), ".txt")
+		if ok && strings.HasPrefix(name, prefix) && t.html.Lookup(name+".html") != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// EmailData is what the email templates are executed with
+type EmailData struct {
+	Invoice  model.Invoice
+	Business generator.Business
+	Message  string // An optional note from whoever sent the invoice
+}
+
+type Sender struct {
+	repo      *repository.InvoiceRepository
+	reminders *repository.ReminderRepository
+	pdf       *generator.InvoiceGenerator
+	mailer    mailer.Mailer // nil when email is turned off
+	templates *Templates
+	business  generator.Business
+}
+
+func NewSender(repo *repository.InvoiceRepository, reminders *repository.ReminderRepository, pdf *generator.InvoiceGenerator, mail mailer.Mailer, templates *Templates, business generator.Business) *Sender {
+	return &Sender{repo: repo, reminders: reminders, pdf: pdf, mailer: mail, templates: templates, business: business}
+}
+
+// Templates are the email templates the sender renders
+func (s *Sender) Templates() *Templates {
+	return s.templates
+}
+
+// Enabled reports whether there is a mail server to send through
+func (s *Sender) Enabled() bool {
+	return s.mailer != nil
+}
+
+// SendInvoice emails an issued invoice to recipient with the PDF attached. Every attempt that reaches the mail server
+// is logged against the invoice, a delivery failure is returned as an error alongside the logged email.
+func (s *Sender) SendInvoice(invoice model.Invoice, recipient string, message string, sentById int) (model.InvoiceEmail, error) {
+	if s.mailer == nil {
+		return model.InvoiceEmail{}, ErrEmailDisabled
+	}
+	if invoice.Status != model.IssuedInvoice {
+		return model.InvoiceEmail{}, ErrNotSendable
+	}
+
+	subject := fmt.Sprintf("Invoice %s from %s", invoice.InvoiceNumber, s.business.Name)
+	if invoice.IsTaxInvoice() {
+		subject = "Tax " + subject
+	}
+	text, html, err := s.templates.render("invoiceEmail", EmailData{Invoice: invoice, Business: s.business, Message: message})
+	if err != nil {
+		return model.InvoiceEmail{}, err
+	}
+
+	email := model.InvoiceEmail{InvoiceId: invoice.InvoiceId, Recipient: recipient, Subject: subject, SentById: sentById}
+	return s.send(invoice, email, text, html)
+}
+
+// send delivers an email about the invoice with its PDF attached and logs the attempt
+func (s *Sender) send(invoice model.Invoice, email model.InvoiceEmail, text string, html string) (model.InvoiceEmail, error) {
+	var pdf bytes.Buffer
+	if err := s.pdf.Render(&pdf, invoice); err != nil {
+		return email, fmt.Errorf("error generating invoice PDF: %v", err)
+	}
+
+	messageId, sendErr := s.mailer.Send(mailer.Message{
+		To:      []string{email.Recipient},
+		Subject: email.Subject,
+		Text:    text,
+		HTML:    html,
+		Attachments: []mailer.Attachment{
+			{Filename: invoice.InvoiceNumber + ".pdf", ContentType: "application/pdf", Data: pdf.Bytes()},
+		},
+	})
+	email.MessageId = messageId
+	if sendErr != nil {
+		email.Error = sendErr.Error()
+	}
+
+	email, err := s.repo.LogInvoiceEmail(email)
+	if err != nil {
+		return email, err
+	}
+	if sendErr != nil {
+		return email, fmt.Errorf("error sending %q to %s: %v", email.Subject, email.Recipient, sendErr)
+	}
+	return email, nil
+}
+
+// ReminderData is what payment reminder templates, and their subject lines, are executed with
+type ReminderData struct {
+	EmailData
+	Step        model.ReminderStep
+	DaysOverdue int // Negative before the due date
+}
+
+// DaysUntilDue is how many days are left to pay, negative once the invoice is overdue
+func (d ReminderData) DaysUntilDue() int {
+	return -d.DaysOverdue
+}
+
+// SendReminder emails the customer a payment reminder for an invoice using the step's subject and template
+func (s *Sender) SendReminder(invoice model.Invoice, step model.ReminderStep) (model.InvoiceEmail, error) {
+	if s.mailer == nil {
+		return model.InvoiceEmail{}, ErrEmailDisabled
+	}
+	if invoice.Status != model.IssuedInvoice {
+		return model.InvoiceEmail{}, ErrNotSendable
+	}
+
+	data := ReminderData{
+		EmailData:   EmailData{Invoice: invoice, Business: s.business},
+		Step:        step,
+		DaysOverdue: daysBetween(invoice.DueDate, time.Now()),
+	}
+	subject, err := ExecuteSubject(step.Subject, data)
+	if err != nil {
+		return model.InvoiceEmail{}, err
+	}
+	text, html, err := s.templates.render(step.Template, data)
+	if err != nil {
+		return model.InvoiceEmail{}, err
+	}
+
+	email := model.InvoiceEmail{InvoiceId: invoice.InvoiceId, Recipient: invoice.CustomerEmail, Subject: subject, ReminderStepId: step.StepId, ReminderName: step.Name}
+	return s.send(invoice, email, text, html)
+}
+
+// SendDueReminders sends every payment reminder that is due and returns how many went out.
+// An invoice that can't be reminded is logged and skipped, it is tried again on a later run.
+func (s *Sender) SendDueReminders() (int, error) {
+	if s.mailer == nil {
+		return 0, nil
+	}
+	due, err := s.reminders.GetDueReminders()
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, reminder := range due {
+		invoice, err := s.repo.GetInvoiceById(reminder.InvoiceId)
+		if err != nil {
+			return sent, fmt.Errorf("error fetching invoice %s for a reminder: %v", reminder.InvoiceId, err)
+		}
+		if invoice.BalanceDue.Cents <= 0 {
+			continue
+		}
+		if _, err := s.SendReminder(invoice, reminder.Step); err != nil {
+			log.Printf("Error sending %s reminder for invoice %s: %v\n", reminder.Step.Name, invoice.InvoiceNumber, err)
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// SendRecurringInvoices emails each issued invoice raised by a recurring schedule set to send automatically,
+// returning how many went out. An invoice that can't be sent is logged and left to be sent from its page.
+func (s *Sender) SendRecurringInvoices(raised []repository.GeneratedInvoice) (int, error) {
+	if s.mailer == nil {
+		return 0, nil
+	}
+
+	sent := 0
+	for _, generated := range raised {
+		if !generated.AutoSend {
+			continue
+		}
+		invoice, err := s.repo.GetInvoiceById(generated.InvoiceId)
+		if err != nil {
+			return sent, fmt.Errorf("error fetching recurring invoice %s: %v", generated.InvoiceId, err)
+		}
+		if invoice.CustomerEmail == "" {
+			log.Printf("Recurring invoice %s wasn't emailed, the customer has no email address\n", invoice.InvoiceNumber)
+			continue
+		}
+		if _, err := s.SendInvoice(invoice, invoice.CustomerEmail, "", 0); err != nil {
+			log.Printf("Error sending recurring invoice %s: %v\n", invoice.InvoiceNumber, err)
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// ExecuteSubject fills in a reminder step's subject line
+func ExecuteSubject(subject string, data ReminderData) (string, error) {
+	tmpl, err := texttemplate.New("subject").Option("missingkey=error").Parse(subject)
+	if err != nil {
+		return "", fmt.Errorf("error parsing subject %q: %v", subject, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("error executing subject %q: %v", subject, err)
+	}
+	return strings.Join(strings.Fields(buf.String()), " "), nil
+}
+
+// daysBetween counts the calendar days from one date to another
+func daysBetween(from time.Time, to time.Time) int {
+	fromDay := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	toDay := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	return int(toDay.Sub(fromDay).Hours() / 24)
+}
@@ -0,0 +1,146 @@
+// Package mailer sends email. Handlers depend on the Mailer interface, SMTPMailer delivers through any SMTP server.
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Message is an email with a plain text body, an optional HTML alternative and any attachments
+type Message struct {
+	From        string // Defaults to the mailer's from address
+	To          []string
+	Subject     string
+	Text        string
+	HTML        string
+	Attachments []Attachment
+}
+
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Mailer delivers a message and returns the Message-ID it was sent with, along with any error delivering it
+type Mailer interface {
+	Send(msg Message) (messageId string, err error)
+}
+
+// build writes out the message as MIME: a text and HTML alternative, wrapped with the attachments when there are any
+func build(msg Message, messageId string, date time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	body := multipart.NewWriter(&buf)
+	contentType := "multipart/alternative"
+	if len(msg.Attachments) > 0 {
+		contentType = "multipart/mixed"
+	}
+
+	var head bytes.Buffer
+	fmt.Fprintf(&head, "From: %s\r\n", msg.From)
+	fmt.Fprintf(&head, "To: %s\r\n", strings.Join(msg.To, ", "))
+	fmt.Fprintf(&head, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&head, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	fmt.Fprintf(&head, "Message-ID: %s\r\n", messageId)
+	fmt.Fprintf(&head, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&head, "Content-Type: %s; boundary=%s\r\n\r\n", contentType, body.Boundary())
+
+	alternatives := body
+	if len(msg.Attachments) > 0 {
+		// The text and HTML go in their own multipart/alternative part ahead of the attachments
+		boundary := multipart.NewWriter(io.Discard).Boundary()
+		part, err := body.CreatePart(textproto.MIMEHeader{"Content-Type": {"multipart/alternative; boundary=" + boundary}})
+		if err != nil {
+			return nil, err
+		}
+		alternatives = multipart.NewWriter(part)
+		if err := alternatives.SetBoundary(boundary); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeText(alternatives, "text/plain; charset=utf-8", msg.Text); err != nil {
+		return nil, err
+	}
+	if msg.HTML != "" {
+		if err := writeText(alternatives, "text/html; charset=utf-8", msg.HTML); err != nil {
+			return nil, err
+		}
+	}
+	if alternatives != body {
+		if err := alternatives.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, attachment := range msg.Attachments {
+		part, err := body.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(attachment.ContentType, map[string]string{"name": attachment.Filename})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeBase64(part, attachment.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	return append(head.Bytes(), buf.Bytes()...), nil
+}
+
+func writeText(w *multipart.Writer, contentType string, text string) error {
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write([]byte(text)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// writeBase64 writes data base64 encoded in lines of 76 characters
+func writeBase64(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		if _, err := fmt.Fprintf(w, "%s\r\n", encoded[:76]); err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err := fmt.Fprintf(w, "%s\r\n", encoded)
+	return err
+}
+
+// newMessageId makes a unique Message-ID on the sender's domain, e.g. <3f9a...@example.com>
+func newMessageId(from string) (string, error) {
+	domain := "localhost"
+	if address, err := mail.ParseAddress(from); err == nil {
+		if _, host, ok := strings.Cut(address.Address, "@"); ok {
+			domain = host
+		}
+	}
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(random), domain), nil
+}
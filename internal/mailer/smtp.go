@@ -0,0 +1,129 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strconv"
+	"time"
+)
+
+// SMTPMailer delivers mail through an SMTP server, upgrading to TLS with STARTTLS whenever the server offers it
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string // Leave blank for servers that don't need a login, such as a local relay
+	Password string
+	From     string // The From header, e.g. "A&R Tech <accounts@example.com>"
+	Timeout  time.Duration
+}
+
+// FromEnv reads the SMTP settings from the environment. It returns nil when SMTP_HOST isn't set, so email is turned off.
+func FromEnv() (Mailer, error) {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return nil, nil
+	}
+
+	port := 587
+	if value := os.Getenv("SMTP_PORT"); value != "" {
+		var err error
+		if port, err = strconv.Atoi(value); err != nil {
+			return nil, fmt.Errorf("SMTP_PORT must be a number, got %q", value)
+		}
+	}
+
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		from = os.Getenv("BUSINESS_EMAIL")
+	}
+	if _, err := mail.ParseAddress(from); err != nil {
+		return nil, fmt.Errorf("SMTP_FROM must be an email address such as \"A&R Tech <accounts@example.com>\", got %q", from)
+	}
+
+	return &SMTPMailer{
+		Host:     host,
+		Port:     port,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     from,
+		Timeout:  30 * time.Second,
+	}, nil
+}
+
+// Send delivers the message to every recipient in one SMTP transaction. The Message-ID is returned even when
+// delivery fails, so the attempt can be matched up with the mail server's logs.
+func (m *SMTPMailer) Send(msg Message) (string, error) {
+	if msg.From == "" {
+		msg.From = m.From
+	}
+	sender, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return "", fmt.Errorf("invalid from address %q: %v", msg.From, err)
+	}
+	msg.From = sender.String()
+
+	messageId, err := newMessageId(sender.Address)
+	if err != nil {
+		return "", fmt.Errorf("error making message id: %v", err)
+	}
+	data, err := build(msg, messageId, time.Now())
+	if err != nil {
+		return messageId, fmt.Errorf("error building email: %v", err)
+	}
+
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+	conn, err := net.DialTimeout("tcp", addr, m.Timeout)
+	if err != nil {
+		return messageId, fmt.Errorf("error connecting to %s: %v", addr, err)
+	}
+	if m.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(m.Timeout))
+	}
+	client, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		return messageId, fmt.Errorf("error starting SMTP session with %s: %v", addr, err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: m.Host}); err != nil {
+			return messageId, fmt.Errorf("error starting TLS with %s: %v", addr, err)
+		}
+	}
+	if m.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.Username, m.Password, m.Host)); err != nil {
+			return messageId, fmt.Errorf("error logging in to %s: %v", addr, err)
+		}
+	}
+
+	if err := client.Mail(sender.Address); err != nil {
+		return messageId, fmt.Errorf("error sending from %s: %v", sender.Address, err)
+	}
+	for _, to := range msg.To {
+		recipient, err := mail.ParseAddress(to)
+		if err != nil {
+			return messageId, fmt.Errorf("invalid recipient %q: %v", to, err)
+		}
+		if err := client.Rcpt(recipient.Address); err != nil {
+			return messageId, fmt.Errorf("recipient %s was refused: %v", recipient.Address, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return messageId, fmt.Errorf("error starting message data: %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return messageId, fmt.Errorf("error writing message: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return messageId, fmt.Errorf("message was not accepted: %v", err)
+	}
+	// The server has the message once the data is accepted, so a failed QUIT doesn't matter
+	client.Quit()
+	return messageId, nil
+}
@@ -0,0 +1,180 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTP is a local SMTP server that accepts one session, refusing recipients in refuse
+type fakeSMTP struct {
+	listener net.Listener
+	refuse   map[string]bool
+	from     string
+	to       []string
+	data     []byte
+	done     chan struct{}
+}
+
+func newFakeSMTP(t *testing.T, refuse ...string) *fakeSMTP {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTP{listener: listener, refuse: make(map[string]bool), done: make(chan struct{})}
+	for _, address := range refuse {
+		s.refuse[address] = true
+	}
+	t.Cleanup(func() { listener.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *fakeSMTP) mailer() *SMTPMailer {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	return &SMTPMailer{Host: addr.IP.String(), Port: addr.Port, From: "A&R Tech <accounts@example.com>", Timeout: 5 * time.Second}
+}
+
+func (s *fakeSMTP) serve() {
+	defer close(s.done)
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+	reply("220 fake ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 fake")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			s.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			to := strings.Trim(line[len("RCPT TO:"):], "<>")
+			if s.refuse[to] {
+				reply("550 No such user")
+				continue
+			}
+			s.to = append(s.to, to)
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 Go ahead")
+			var data bytes.Buffer
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			s.data = data.Bytes()
+			reply("250 Queued")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Not implemented")
+		}
+	}
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	server := newFakeSMTP(t)
+	pdf := []byte("%PDF-1.4 \x00\x01\x02\xff invoice bytes that need encoding")
+
+	messageId, err := server.mailer().Send(Message{
+		To:          []string{"Bob Smith <bob@example.net>"},
+		Subject:     "Invoice INV0001 from A&R Tech",
+		Text:        "Please find attached invoice INV0001.",
+		HTML:        "<p>Please find attached invoice <strong>INV0001</strong>.</p>",
+		Attachments: []Attachment{{Filename: "INV0001.pdf", ContentType: "application/pdf", Data: pdf}},
+	})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	<-server.done
+
+	if server.from != "accounts@example.com" {
+		t.Errorf("MAIL FROM = %q, want accounts@example.com", server.from)
+	}
+	if len(server.to) != 1 || server.to[0] != "bob@example.net" {
+		t.Errorf("RCPT TO = %q, want [bob@example.net]", server.to)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(server.data))
+	if err != nil {
+		t.Fatalf("reading sent message: %v", err)
+	}
+	if got := msg.Header.Get("Message-ID"); got != messageId {
+		t.Errorf("Message-ID header = %q, Send returned %q", got, messageId)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Content-Type = %q, want multipart/mixed", msg.Header.Get("Content-Type"))
+	}
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	var attachment []byte
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("reading message part: %v", err)
+		}
+		if part.FileName() != "INV0001.pdf" {
+			continue
+		}
+		if part.Header.Get("Content-Transfer-Encoding") != "base64" {
+			t.Errorf("attachment encoding = %q, want base64", part.Header.Get("Content-Transfer-Encoding"))
+		}
+		if attachment, err = io.ReadAll(base64.NewDecoder(base64.StdEncoding, part)); err != nil {
+			t.Fatalf("decoding attachment: %v", err)
+		}
+	}
+	if !bytes.Equal(attachment, pdf) {
+		t.Errorf("attachment decoded to %q, want %q", attachment, pdf)
+	}
+}
+
+func TestSMTPMailerSendRefusedRecipient(t *testing.T) {
+	server := newFakeSMTP(t, "nobody@example.net")
+
+	messageId, err := server.mailer().Send(Message{To: []string{"nobody@example.net"}, Subject: "Invoice", Text: "Hello"})
+	if err == nil {
+		t.Fatal("Send succeeded, want an error for the refused recipient")
+	}
+	if !strings.Contains(err.Error(), "nobody@example.net was refused") {
+		t.Errorf("error = %v, want it to name the refused recipient", err)
+	}
+	if messageId == "" {
+		t.Error("Send returned no message id along with the error")
+	}
+	<-server.done
+	if server.data != nil {
+		t.Error("message data was sent after the recipient was refused")
+	}
+}
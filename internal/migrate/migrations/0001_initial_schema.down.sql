@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS service_entry;
+DROP TABLE IF EXISTS address;
+DROP TABLE IF EXISTS item_lists;
+DROP TABLE IF EXISTS notes;
+DROP TABLE IF EXISTS invoices;
+DROP TABLE IF EXISTS customers;
+DROP TABLE IF EXISTS leads;
+DROP TABLE IF EXISTS status;
@@ -0,0 +1,112 @@
+-- Baseline schema. Everything uses IF NOT EXISTS so databases created by the
+-- old CreateTables start tracking migrations without losing data.
+CREATE TABLE IF NOT EXISTS status (
+    StatusId SERIAL PRIMARY KEY,
+    StatusValue TEXT NOT NULL UNIQUE,
+    IsClosed BOOLEAN NOT NULL DEFAULT FALSE,
+    ClosedStatusValue TEXT
+);
+
+INSERT INTO status (StatusValue, IsClosed, ClosedStatusValue) VALUES
+('New Lead', FALSE, NULL),
+('Contacted', FALSE, NULL),
+('Engaged', FALSE, NULL),
+('Qualified', FALSE, NULL),
+('Needs Analysis', FALSE, NULL),
+('Proposal Sent', FALSE, NULL),
+('Negotiation', FALSE, NULL),
+('Closed', TRUE, 'Still Fighting'),
+('Closed', TRUE, 'Won'),
+('Closed', TRUE, 'Lost')
+ON CONFLICT (StatusValue) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS leads (
+    Id SERIAL PRIMARY KEY,
+    FirstName TEXT,
+    LastName TEXT,
+    CompanyName TEXT,
+    Email TEXT,
+    Phone TEXT,
+    StatusId INTEGER NOT NULL,
+    Title TEXT,
+    Website TEXT,
+    Industry TEXT,
+    ServiceType TEXT,
+    Source TEXT,
+    CreatedAt TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UpdatedAt TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (StatusId) REFERENCES status(StatusId)
+);
+
+CREATE TABLE IF NOT EXISTS customers (
+    Id SERIAL PRIMARY KEY,
+    FirstName TEXT,
+    LastName TEXT,
+    Email TEXT,
+    CompanyName TEXT,
+    Phone TEXT,
+    Title TEXT,
+    Website TEXT,
+    Industry TEXT,
+    Source TEXT
+);
+
+CREATE TABLE IF NOT EXISTS invoices (
+    InvoiceId SERIAL PRIMARY KEY,
+    InvoiceNumber TEXT,
+    InvoiceDate TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+    DueDate DATE,
+    CustomerId INTEGER NOT NULL,
+    CustomerName TEXT NOT NULL,
+    CompanyName TEXT,
+    CustomerPhone TEXT NOT NULL,
+    CustomerEmail TEXT NOT NULL,
+    PaymentStatus INTEGER NOT NULL,
+    CustomerAddress TEXT NOT NULL,
+    CreatedAt TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UpdatedAt TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (CustomerId) REFERENCES customers(Id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS notes (
+    NoteId SERIAL PRIMARY KEY,
+    CustomerId INTEGER,
+    LeadId INTEGER,
+    Category TEXT NOT NULL,
+    AuthorId INTEGER,
+    AuthorName TEXT,
+    Content TEXT,
+    CreatedAt TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UpdatedAt TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (CustomerId) REFERENCES customers(Id) ON DELETE SET NULL,
+    FOREIGN KEY (LeadId) REFERENCES leads(Id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS item_lists (
+    ItemId SERIAL PRIMARY KEY,
+    InvoiceId INTEGER NOT NULL,
+    Item TEXT NOT NULL,
+    Quantity INTEGER NOT NULL,
+    UnitPrice DECIMAL NOT NULL,
+    Subtotal DECIMAL NOT NULL,
+    Tax DECIMAL NOT NULL,
+    Total DECIMAL NOT NULL,
+    FOREIGN KEY (InvoiceId) REFERENCES invoices(InvoiceId) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS address (
+    UnitNumber TEXT,
+    StreetNumber TEXT,
+    StreetName TEXT,
+    City TEXT,
+    Postcode TEXT,
+    PRIMARY KEY (StreetNumber, StreetName, City, Postcode)
+);
+
+CREATE TABLE IF NOT EXISTS service_entry (
+    EntryId SERIAL PRIMARY KEY,
+    ServiceType TEXT,
+    StartDate DATE,
+    DueDate DATE,
+    EndDate DATE
+);
@@ -0,0 +1,18 @@
+CREATE TABLE lead_status_history (
+    HistoryId SERIAL PRIMARY KEY,
+    LeadId INTEGER NOT NULL,
+    FromStatusId INTEGER,
+    ToStatusId INTEGER NOT NULL,
+    ChangedById INTEGER,
+    ChangedByName TEXT,
+    ChangedAt TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (LeadId) REFERENCES leads(Id) ON DELETE CASCADE,
+    FOREIGN KEY (FromStatusId) REFERENCES status(StatusId),
+    FOREIGN KEY (ToStatusId) REFERENCES status(StatusId)
+);
+
+CREATE INDEX lead_status_history_lead_idx ON lead_status_history (LeadId, ChangedAt);
+
+-- Record where existing leads currently sit so every lead has a history
+INSERT INTO lead_status_history (LeadId, FromStatusId, ToStatusId, ChangedAt)
+SELECT Id, NULL, StatusId, COALESCE(CreatedAt, CURRENT_TIMESTAMP) FROM leads;
@@ -0,0 +1,15 @@
+-- Fold Won and Lost back into the single Closed status before removing them
+UPDATE leads SET StatusId = (SELECT StatusId FROM status WHERE StatusValue = 'Closed' AND ClosedStatusValue = 'Still Fighting')
+WHERE StatusId IN (SELECT StatusId FROM status WHERE StatusValue = 'Closed' AND ClosedStatusValue IN ('Won', 'Lost'));
+
+UPDATE lead_status_history SET FromStatusId = (SELECT StatusId FROM status WHERE StatusValue = 'Closed' AND ClosedStatusValue = 'Still Fighting')
+WHERE FromStatusId IN (SELECT StatusId FROM status WHERE StatusValue = 'Closed' AND ClosedStatusValue IN ('Won', 'Lost'));
+
+UPDATE lead_status_history SET ToStatusId = (SELECT StatusId FROM status WHERE StatusValue = 'Closed' AND ClosedStatusValue = 'Still Fighting')
+WHERE ToStatusId IN (SELECT StatusId FROM status WHERE StatusValue = 'Closed' AND ClosedStatusValue IN ('Won', 'Lost'));
+
+DELETE FROM status WHERE StatusValue = 'Closed' AND ClosedStatusValue IN ('Won', 'Lost');
+
+ALTER TABLE status DROP CONSTRAINT IF EXISTS status_closed_outcome_check;
+DROP INDEX IF EXISTS status_value_outcome_key;
+ALTER TABLE status ADD CONSTRAINT status_statusvalue_key UNIQUE (StatusValue);
@@ -0,0 +1,14 @@
+-- StatusValue on its own was unique, so only the first of the three Closed
+-- rows was ever inserted. Closed statuses are told apart by their outcome.
+ALTER TABLE status DROP CONSTRAINT IF EXISTS status_statusvalue_key;
+
+CREATE UNIQUE INDEX status_value_outcome_key ON status (StatusValue, COALESCE(ClosedStatusValue, ''));
+
+ALTER TABLE status ADD CONSTRAINT status_closed_outcome_check
+    CHECK (IsClosed = (ClosedStatusValue IS NOT NULL));
+
+INSERT INTO status (StatusValue, IsClosed, ClosedStatusValue) VALUES
+('Closed', TRUE, 'Still Fighting'),
+('Closed', TRUE, 'Won'),
+('Closed', TRUE, 'Lost')
+ON CONFLICT DO NOTHING;
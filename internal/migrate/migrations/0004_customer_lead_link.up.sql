@@ -0,0 +1,2 @@
+-- A lead converts into at most one customer
+ALTER TABLE customers ADD COLUMN LeadId INTEGER UNIQUE REFERENCES leads(Id) ON DELETE SET NULL;
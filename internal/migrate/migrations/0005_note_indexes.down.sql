@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS notes_lead_idx;
+DROP INDEX IF EXISTS notes_customer_idx;
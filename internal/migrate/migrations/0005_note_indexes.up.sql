@@ -0,0 +1,3 @@
+-- Notes are always listed for one customer or lead, newest first
+CREATE INDEX notes_customer_idx ON notes (CustomerId, CreatedAt DESC);
+CREATE INDEX notes_lead_idx ON notes (LeadId, CreatedAt DESC);
@@ -0,0 +1,4 @@
+ALTER TABLE invoices DROP COLUMN IF EXISTS CreatedById;
+ALTER TABLE lead_status_history DROP CONSTRAINT IF EXISTS lead_status_history_changedbyid_fkey;
+ALTER TABLE notes DROP CONSTRAINT IF EXISTS notes_authorid_fkey;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,15 @@
+CREATE TABLE users (
+    Id SERIAL PRIMARY KEY,
+    Email TEXT NOT NULL,
+    Name TEXT NOT NULL,
+    PasswordHash TEXT NOT NULL,
+    CreatedAt TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UpdatedAt TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX users_email_key ON users (LOWER(Email));
+
+-- Authors and creators now point at real users
+ALTER TABLE notes ADD CONSTRAINT notes_authorid_fkey FOREIGN KEY (AuthorId) REFERENCES users(Id) ON DELETE SET NULL;
+ALTER TABLE lead_status_history ADD CONSTRAINT lead_status_history_changedbyid_fkey FOREIGN KEY (ChangedById) REFERENCES users(Id) ON DELETE SET NULL;
+ALTER TABLE invoices ADD COLUMN CreatedById INTEGER REFERENCES users(Id) ON DELETE SET NULL;
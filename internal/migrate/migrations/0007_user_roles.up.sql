@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN Role TEXT NOT NULL DEFAULT 'sales'
+    CHECK (Role IN ('admin', 'sales', 'technician', 'bookkeeper'));
+
+-- Someone has to be able to hand out roles, make the first user an admin
+UPDATE users SET Role = 'admin' WHERE Id = (SELECT MIN(Id) FROM users);
@@ -0,0 +1,3 @@
+ALTER TABLE customers
+    DROP COLUMN IF EXISTS UpdatedAt,
+    DROP COLUMN IF EXISTS CreatedAt;
@@ -0,0 +1,28 @@
+ALTER TABLE invoices ADD COLUMN CustomerAddress TEXT NOT NULL DEFAULT '';
+
+UPDATE invoices SET CustomerAddress = CONCAT_WS(', ',
+    NULLIF(TRIM(CONCAT(NULLIF(AddressUnitNumber, '') || '/', AddressStreetNumber, ' ', AddressStreetName)), ''),
+    NULLIF(AddressCity, ''),
+    NULLIF(TRIM(CONCAT(AddressState, ' ', AddressPostcode)), ''));
+
+ALTER TABLE invoices ALTER COLUMN CustomerAddress DROP DEFAULT;
+
+ALTER TABLE invoices
+    DROP COLUMN BillingAddressId,
+    DROP COLUMN AddressUnitNumber,
+    DROP COLUMN AddressStreetNumber,
+    DROP COLUMN AddressStreetName,
+    DROP COLUMN AddressCity,
+    DROP COLUMN AddressState,
+    DROP COLUMN AddressPostcode;
+
+DROP TABLE IF EXISTS customer_addresses;
+
+CREATE TABLE IF NOT EXISTS address (
+    UnitNumber TEXT,
+    StreetNumber TEXT,
+    StreetName TEXT,
+    City TEXT,
+    Postcode TEXT,
+    PRIMARY KEY (StreetNumber, StreetName, City, Postcode)
+);
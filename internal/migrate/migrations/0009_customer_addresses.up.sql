@@ -0,0 +1,37 @@
+-- The old address table was never linked to anything, nothing reads or writes it
+DROP TABLE IF EXISTS address;
+
+CREATE TABLE customer_addresses (
+    AddressId SERIAL PRIMARY KEY,
+    CustomerId INTEGER NOT NULL REFERENCES customers(Id) ON DELETE CASCADE,
+    Type TEXT NOT NULL CHECK (Type IN ('billing', 'service')),
+    IsDefault BOOLEAN NOT NULL DEFAULT FALSE,
+    UnitNumber TEXT NOT NULL DEFAULT '',
+    StreetNumber TEXT NOT NULL DEFAULT '',
+    StreetName TEXT NOT NULL DEFAULT '',
+    City TEXT NOT NULL DEFAULT '',
+    State TEXT NOT NULL DEFAULT '',
+    Postcode TEXT NOT NULL DEFAULT '',
+    CreatedAt TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UpdatedAt TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX customer_addresses_customer_idx ON customer_addresses (CustomerId);
+
+-- At most one default billing and one default service address per customer
+CREATE UNIQUE INDEX customer_addresses_default_idx ON customer_addresses (CustomerId, Type) WHERE IsDefault;
+
+-- Invoices keep a copy of the address they were sent to, the link is only for reference
+ALTER TABLE invoices
+    ADD COLUMN BillingAddressId INTEGER REFERENCES customer_addresses(AddressId) ON DELETE SET NULL,
+    ADD COLUMN AddressUnitNumber TEXT NOT NULL DEFAULT '',
+    ADD COLUMN AddressStreetNumber TEXT NOT NULL DEFAULT '',
+    ADD COLUMN AddressStreetName TEXT NOT NULL DEFAULT '',
+    ADD COLUMN AddressCity TEXT NOT NULL DEFAULT '',
+    ADD COLUMN AddressState TEXT NOT NULL DEFAULT '',
+    ADD COLUMN AddressPostcode TEXT NOT NULL DEFAULT '';
+
+-- Keep whatever single line address older invoices had, it prints the same way
+UPDATE invoices SET AddressStreetName = CustomerAddress WHERE CustomerAddress <> '';
+
+ALTER TABLE invoices DROP COLUMN CustomerAddress;
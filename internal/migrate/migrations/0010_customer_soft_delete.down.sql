@@ -0,0 +1,7 @@
+ALTER TABLE invoices DROP CONSTRAINT IF EXISTS invoices_customerid_fkey;
+ALTER TABLE invoices ADD CONSTRAINT invoices_customerid_fkey
+    FOREIGN KEY (CustomerId) REFERENCES customers(Id) ON DELETE CASCADE;
+
+DROP INDEX IF EXISTS customers_active_idx;
+
+ALTER TABLE customers DROP COLUMN IF EXISTS DeletedAt;
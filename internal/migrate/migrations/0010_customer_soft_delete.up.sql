@@ -0,0 +1,8 @@
+ALTER TABLE customers ADD COLUMN DeletedAt TIMESTAMP WITHOUT TIME ZONE;
+
+CREATE INDEX customers_active_idx ON customers (Id) WHERE DeletedAt IS NULL;
+
+-- Deleting a customer used to take their invoices with them, now a customer with invoices can't be deleted
+ALTER TABLE invoices DROP CONSTRAINT IF EXISTS invoices_customerid_fkey;
+ALTER TABLE invoices ADD CONSTRAINT invoices_customerid_fkey
+    FOREIGN KEY (CustomerId) REFERENCES customers(Id) ON DELETE RESTRICT;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS leads_active_idx;
+
+ALTER TABLE leads DROP COLUMN IF EXISTS DeletedAt;
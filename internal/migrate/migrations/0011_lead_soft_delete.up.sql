@@ -0,0 +1,3 @@
+ALTER TABLE leads ADD COLUMN DeletedAt TIMESTAMP WITHOUT TIME ZONE;
+
+CREATE INDEX leads_active_idx ON leads (Id) WHERE DeletedAt IS NULL;
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS invoices_date_idx;
+
+ALTER TABLE invoices
+    DROP CONSTRAINT IF EXISTS invoices_void_check,
+    DROP COLUMN IF EXISTS VoidReason,
+    DROP COLUMN IF EXISTS VoidedById,
+    DROP COLUMN IF EXISTS VoidedAt,
+    DROP COLUMN IF EXISTS Status;
@@ -0,0 +1,11 @@
+-- Everything raised so far has been sent to a customer
+ALTER TABLE invoices
+    ADD COLUMN Status TEXT NOT NULL DEFAULT 'issued' CHECK (Status IN ('draft', 'issued', 'void')),
+    ADD COLUMN VoidedAt TIMESTAMP WITHOUT TIME ZONE,
+    ADD COLUMN VoidedById INTEGER REFERENCES users(Id) ON DELETE SET NULL,
+    ADD COLUMN VoidReason TEXT,
+    ADD CONSTRAINT invoices_void_check CHECK ((Status = 'void') = (VoidedAt IS NOT NULL AND COALESCE(VoidReason, '') <> ''));
+
+ALTER TABLE invoices ALTER COLUMN Status SET DEFAULT 'draft';
+
+CREATE INDEX invoices_date_idx ON invoices (InvoiceDate);
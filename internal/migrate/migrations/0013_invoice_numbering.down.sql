@@ -0,0 +1,3 @@
+ALTER TABLE invoices DROP CONSTRAINT IF EXISTS invoices_invoicenumber_key;
+
+DROP TABLE IF EXISTS invoice_number_counters;
@@ -0,0 +1,27 @@
+-- One counter per numbering series and year (0 when the number doesn't include the year).
+-- The counter row is locked by the invoice insert until it commits, so numbers are never shared or skipped.
+CREATE TABLE invoice_number_counters (
+    Series TEXT NOT NULL,
+    Year INTEGER NOT NULL DEFAULT 0,
+    LastNumber BIGINT NOT NULL CHECK (LastNumber >= 0),
+    UpdatedAt TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (Series, Year)
+);
+
+-- Carry on from the highest INV number handed out so far
+INSERT INTO invoice_number_counters (Series, Year, LastNumber)
+SELECT 'default', 0, COALESCE(MAX(substring(InvoiceNumber FROM 4)::BIGINT), 0)
+FROM invoices
+WHERE InvoiceNumber ~ '^INV[0-9]+$';
+
+-- Earlier concurrent inserts could hand out the same number twice, the later duplicates keep theirs with the invoice id appended
+UPDATE invoices SET InvoiceNumber = InvoiceNumber || '-' || InvoiceId
+WHERE InvoiceId IN (
+    SELECT InvoiceId FROM (
+        SELECT InvoiceId, ROW_NUMBER() OVER (PARTITION BY InvoiceNumber ORDER BY InvoiceId) AS Duplicate
+        FROM invoices
+    ) numbered
+    WHERE Duplicate > 1
+);
+
+ALTER TABLE invoices ADD CONSTRAINT invoices_invoicenumber_key UNIQUE (InvoiceNumber);
@@ -0,0 +1,10 @@
+ALTER TABLE invoices
+    DROP COLUMN IF EXISTS TaxRounding,
+    DROP COLUMN IF EXISTS Currency;
+
+ALTER TABLE item_lists
+    DROP COLUMN IF EXISTS TaxRate,
+    ALTER COLUMN UnitPrice TYPE DECIMAL,
+    ALTER COLUMN Subtotal TYPE DECIMAL,
+    ALTER COLUMN Tax TYPE DECIMAL,
+    ALTER COLUMN Total TYPE DECIMAL;
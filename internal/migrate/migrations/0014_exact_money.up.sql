@@ -0,0 +1,15 @@
+-- Amounts are exact to the cent, the application holds them as integer cents
+ALTER TABLE item_lists
+    ALTER COLUMN UnitPrice TYPE NUMERIC(14, 2),
+    ALTER COLUMN Subtotal TYPE NUMERIC(14, 2),
+    ALTER COLUMN Tax TYPE NUMERIC(14, 2),
+    ALTER COLUMN Total TYPE NUMERIC(14, 2),
+    -- Hundredths of a percent, 1000 is 10%
+    ADD COLUMN TaxRate INTEGER NOT NULL DEFAULT 0 CHECK (TaxRate BETWEEN 0 AND 10000);
+
+-- The rate used to be thrown away once the tax was worked out
+UPDATE item_lists SET TaxRate = ROUND(Tax * 10000 / Subtotal) WHERE Subtotal > 0 AND Tax > 0;
+
+ALTER TABLE invoices
+    ADD COLUMN Currency TEXT NOT NULL DEFAULT 'AUD' CHECK (Currency ~ '^[A-Z]{3}$'),
+    ADD COLUMN TaxRounding TEXT NOT NULL DEFAULT 'line' CHECK (TaxRounding IN ('line', 'invoice'));
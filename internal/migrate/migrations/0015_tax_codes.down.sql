@@ -0,0 +1,9 @@
+ALTER TABLE invoices DROP COLUMN IF EXISTS PricesIncludeTax;
+
+ALTER TABLE item_lists
+    DROP COLUMN IF EXISTS ProductId,
+    DROP COLUMN IF EXISTS TaxName,
+    DROP COLUMN IF EXISTS TaxCode;
+
+DROP TABLE IF EXISTS products;
+DROP TABLE IF EXISTS tax_codes;
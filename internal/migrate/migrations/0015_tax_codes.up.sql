@@ -0,0 +1,41 @@
+-- Taxable supplies carry GST at the rate, GST-free and input-taxed supplies carry none but are reported differently on the BAS
+CREATE TABLE tax_codes (
+    Code TEXT PRIMARY KEY CHECK (Code ~ '^[A-Z0-9-]{1,10}$'),
+    Name TEXT NOT NULL,
+    Kind TEXT NOT NULL CHECK (Kind IN ('taxable', 'gst-free', 'input-taxed')),
+    -- Hundredths of a percent, 1000 is 10%
+    Rate INTEGER NOT NULL CHECK (Rate BETWEEN 0 AND 10000),
+    Active BOOLEAN NOT NULL DEFAULT true,
+    CreatedAt TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UpdatedAt TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK (Kind = 'taxable' OR Rate = 0)
+);
+
+INSERT INTO tax_codes (Code, Name, Kind, Rate) VALUES
+    ('GST', 'GST 10%', 'taxable', 1000),
+    ('FRE', 'GST-free', 'gst-free', 0),
+    ('INP', 'Input taxed', 'input-taxed', 0);
+
+-- Products and services we sell, with the price and tax code filled in when one is put on an invoice
+CREATE TABLE products (
+    ProductId SERIAL PRIMARY KEY,
+    Name TEXT NOT NULL UNIQUE,
+    Description TEXT NOT NULL DEFAULT '',
+    UnitPrice NUMERIC(14, 2) NOT NULL DEFAULT 0 CHECK (UnitPrice >= 0),
+    TaxCode TEXT NOT NULL REFERENCES tax_codes(Code),
+    Active BOOLEAN NOT NULL DEFAULT true,
+    CreatedAt TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UpdatedAt TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- The rate and name are copied onto each line so later changes to a tax code don't alter issued invoices
+ALTER TABLE item_lists
+    ADD COLUMN TaxCode TEXT REFERENCES tax_codes(Code),
+    ADD COLUMN TaxName TEXT NOT NULL DEFAULT '',
+    ADD COLUMN ProductId INTEGER REFERENCES products(ProductId) ON DELETE SET NULL;
+
+UPDATE item_lists SET TaxCode = 'GST', TaxName = 'GST 10%' WHERE TaxRate = 1000;
+UPDATE item_lists SET TaxCode = 'FRE', TaxName = 'GST-free' WHERE TaxRate = 0;
+
+-- Tax inclusive invoices have GST inside the unit prices, exclusive ones add it on top
+ALTER TABLE invoices ADD COLUMN PricesIncludeTax BOOLEAN NOT NULL DEFAULT false;
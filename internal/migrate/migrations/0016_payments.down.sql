@@ -0,0 +1,5 @@
+ALTER TABLE invoices DROP CONSTRAINT IF EXISTS invoices_paymentstatus_check;
+
+UPDATE invoices SET PaymentStatus = 1 WHERE PaymentStatus = 3;
+
+DROP TABLE IF EXISTS payments;
@@ -0,0 +1,24 @@
+CREATE TABLE payments (
+    PaymentId SERIAL PRIMARY KEY,
+    InvoiceId INTEGER NOT NULL REFERENCES invoices(InvoiceId) ON DELETE RESTRICT,
+    Amount NUMERIC(14, 2) NOT NULL CHECK (Amount > 0),
+    PaidOn DATE NOT NULL,
+    Method TEXT NOT NULL CHECK (Method IN ('bank transfer', 'card', 'cash', 'cheque', 'other')),
+    Reference TEXT NOT NULL DEFAULT '',
+    RecordedById INTEGER REFERENCES users(Id) ON DELETE SET NULL,
+    CreatedAt TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX payments_invoiceid_idx ON payments (InvoiceId);
+
+-- Invoices marked paid by hand get a payment for their total so they stay paid
+INSERT INTO payments (InvoiceId, Amount, PaidOn, Method, Reference)
+SELECT i.InvoiceId, SUM(l.Total), COALESCE(i.UpdatedAt, i.InvoiceDate)::date, 'other', 'Marked paid before payments were recorded'
+FROM invoices i
+JOIN item_lists l ON l.InvoiceId = i.InvoiceId
+WHERE i.PaymentStatus = 0
+GROUP BY i.InvoiceId
+HAVING SUM(l.Total) > 0;
+
+-- PaymentStatus is now worked out from the payments: 0 paid, 1 pending, 2 overdue, 3 partially paid
+ALTER TABLE invoices ADD CONSTRAINT invoices_paymentstatus_check CHECK (PaymentStatus BETWEEN 0 AND 3);
@@ -0,0 +1,22 @@
+CREATE TABLE invoice_status_history (
+    HistoryId SERIAL PRIMARY KEY,
+    InvoiceId INTEGER NOT NULL,
+    FromPaymentStatus INTEGER,
+    ToPaymentStatus INTEGER NOT NULL,
+    ChangedById INTEGER,
+    Reason TEXT NOT NULL DEFAULT '',
+    ChangedAt TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (InvoiceId) REFERENCES invoices(InvoiceId) ON DELETE CASCADE,
+    FOREIGN KEY (ChangedById) REFERENCES users(Id) ON DELETE SET NULL
+);
+
+CREATE INDEX invoice_status_history_invoice_idx ON invoice_status_history (InvoiceId, ChangedAt);
+
+-- Issued invoices already past their due date are overdue, record the change so it shows in their history
+WITH overdue AS (
+    UPDATE invoices SET PaymentStatus = 2, UpdatedAt = CURRENT_TIMESTAMP
+    WHERE Status = 'issued' AND PaymentStatus = 1 AND DueDate < CURRENT_DATE
+    RETURNING InvoiceId
+)
+INSERT INTO invoice_status_history (InvoiceId, FromPaymentStatus, ToPaymentStatus, Reason)
+SELECT InvoiceId, 1, 2, 'Past due date' FROM overdue;
@@ -0,0 +1,14 @@
+CREATE TABLE invoice_emails (
+    EmailId SERIAL PRIMARY KEY,
+    InvoiceId INTEGER NOT NULL REFERENCES invoices(InvoiceId) ON DELETE CASCADE,
+    Recipient TEXT NOT NULL,
+    Subject TEXT NOT NULL,
+    MessageId TEXT NOT NULL DEFAULT '',
+    SentAt TIMESTAMP WITHOUT TIME ZONE,
+    Error TEXT NOT NULL DEFAULT '',
+    SentById INTEGER REFERENCES users(Id) ON DELETE SET NULL,
+    CreatedAt TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- SentAt is only set once the mail server accepts the message, failed attempts keep the error instead
+CREATE INDEX invoice_emails_invoiceid_idx ON invoice_emails (InvoiceId, CreatedAt);
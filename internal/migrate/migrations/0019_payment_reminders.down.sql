@@ -0,0 +1,3 @@
+ALTER TABLE invoice_emails DROP COLUMN IF EXISTS ReminderStepId;
+ALTER TABLE customers DROP COLUMN IF EXISTS NoReminders;
+DROP TABLE IF EXISTS reminder_steps;
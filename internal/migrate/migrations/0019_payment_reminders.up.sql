@@ -0,0 +1,22 @@
+-- Each step sends a reminder a number of days after the due date, negative numbers are before it
+CREATE TABLE reminder_steps (
+    StepId SERIAL PRIMARY KEY,
+    Name TEXT NOT NULL,
+    DaysAfterDue INTEGER NOT NULL UNIQUE,
+    Subject TEXT NOT NULL,
+    Template TEXT NOT NULL,
+    Active BOOLEAN NOT NULL DEFAULT TRUE,
+    CreatedAt TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UpdatedAt TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO reminder_steps (Name, DaysAfterDue, Subject, Template) VALUES
+    ('Coming up', -3, 'Invoice {{ .Invoice.InvoiceNumber }} is due on {{ .Invoice.DueDate.Format "02/01/2006" }}', 'reminderUpcoming'),
+    ('Due today', 0, 'Invoice {{ .Invoice.InvoiceNumber }} is due today', 'reminderUpcoming'),
+    ('Overdue', 7, 'Invoice {{ .Invoice.InvoiceNumber }} is overdue', 'reminderOverdue'),
+    ('Final notice', 14, 'Final notice: invoice {{ .Invoice.InvoiceNumber }} is {{ .DaysOverdue }} days overdue', 'reminderFinal');
+
+ALTER TABLE customers ADD COLUMN NoReminders BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Reminders are logged with the invoice's other emails
+ALTER TABLE invoice_emails ADD COLUMN ReminderStepId INTEGER REFERENCES reminder_steps(StepId) ON DELETE SET NULL;
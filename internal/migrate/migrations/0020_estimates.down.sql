@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS invoices_estimateid_idx;
+ALTER TABLE invoices DROP COLUMN IF EXISTS EstimateId;
+DROP TABLE IF EXISTS estimate_items;
+DROP TABLE IF EXISTS estimates;
@@ -0,0 +1,52 @@
+-- Estimates are priced like invoices but promise nothing until the customer accepts them.
+-- The customer and address are copied in the same way so an estimate reads the same later on.
+CREATE TABLE estimates (
+    EstimateId SERIAL PRIMARY KEY,
+    EstimateNumber TEXT NOT NULL UNIQUE,
+    EstimateDate TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ValidUntil DATE NOT NULL,
+    CustomerId INTEGER NOT NULL REFERENCES customers(Id) ON DELETE CASCADE,
+    CustomerName TEXT NOT NULL,
+    CompanyName TEXT NOT NULL DEFAULT '',
+    CustomerPhone TEXT NOT NULL DEFAULT '',
+    CustomerEmail TEXT NOT NULL DEFAULT '',
+    BillingAddressId INTEGER REFERENCES customer_addresses(AddressId) ON DELETE SET NULL,
+    AddressUnitNumber TEXT NOT NULL DEFAULT '',
+    AddressStreetNumber TEXT NOT NULL DEFAULT '',
+    AddressStreetName TEXT NOT NULL DEFAULT '',
+    AddressCity TEXT NOT NULL DEFAULT '',
+    AddressState TEXT NOT NULL DEFAULT '',
+    AddressPostcode TEXT NOT NULL DEFAULT '',
+    Status TEXT NOT NULL DEFAULT 'draft' CHECK (Status IN ('draft', 'sent', 'accepted', 'declined', 'expired')),
+    Currency TEXT NOT NULL DEFAULT 'AUD' CHECK (Currency ~ '^[A-Z]{3}$'),
+    TaxRounding TEXT NOT NULL DEFAULT 'line' CHECK (TaxRounding IN ('line', 'invoice')),
+    PricesIncludeTax BOOLEAN NOT NULL DEFAULT false,
+    CreatedById INTEGER REFERENCES users(Id) ON DELETE SET NULL,
+    CreatedAt TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UpdatedAt TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX estimates_customer_idx ON estimates (CustomerId);
+
+-- The same columns as item_lists, so lines copy straight across when an estimate becomes an invoice
+CREATE TABLE estimate_items (
+    ItemId SERIAL PRIMARY KEY,
+    EstimateId INTEGER NOT NULL REFERENCES estimates(EstimateId) ON DELETE CASCADE,
+    Item TEXT NOT NULL,
+    Quantity INTEGER NOT NULL,
+    ProductId INTEGER REFERENCES products(ProductId) ON DELETE SET NULL,
+    UnitPrice NUMERIC(14, 2) NOT NULL,
+    TaxCode TEXT REFERENCES tax_codes(Code),
+    TaxName TEXT NOT NULL DEFAULT '',
+    -- Hundredths of a percent, 1000 is 10%
+    TaxRate INTEGER NOT NULL DEFAULT 0 CHECK (TaxRate BETWEEN 0 AND 10000),
+    Subtotal NUMERIC(14, 2) NOT NULL,
+    Tax NUMERIC(14, 2) NOT NULL,
+    Total NUMERIC(14, 2) NOT NULL
+);
+
+CREATE INDEX estimate_items_estimateid_idx ON estimate_items (EstimateId);
+
+-- Invoices converted from an estimate point back at it. Voiding the invoice lets the estimate be converted again.
+ALTER TABLE invoices ADD COLUMN EstimateId INTEGER REFERENCES estimates(EstimateId) ON DELETE SET NULL;
+CREATE UNIQUE INDEX invoices_estimateid_idx ON invoices (EstimateId) WHERE EstimateId IS NOT NULL AND Status <> 'void';
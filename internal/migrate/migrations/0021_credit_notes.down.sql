@@ -0,0 +1,9 @@
+DELETE FROM payments WHERE CreditNoteId IS NOT NULL;
+
+ALTER TABLE payments
+    DROP CONSTRAINT IF EXISTS payments_amount_check,
+    DROP COLUMN IF EXISTS CreditNoteId,
+    ADD CONSTRAINT payments_amount_check CHECK (Amount > 0);
+
+DROP TABLE IF EXISTS credit_note_items;
+DROP TABLE IF EXISTS credit_notes;
@@ -0,0 +1,42 @@
+-- Credit notes reverse all or part of an issued invoice, which can't be changed once issued.
+-- The customer, currency and tax settings are the invoice's, so only the credit itself is stored.
+CREATE TABLE credit_notes (
+    CreditNoteId SERIAL PRIMARY KEY,
+    CreditNoteNumber TEXT NOT NULL UNIQUE,
+    InvoiceId INTEGER NOT NULL REFERENCES invoices(InvoiceId) ON DELETE RESTRICT,
+    CreditDate TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    Reason TEXT NOT NULL CHECK (Reason <> ''),
+    -- 'balance' takes the credit off what is owed, 'refund' pays it back to the customer
+    Settlement TEXT NOT NULL CHECK (Settlement IN ('balance', 'refund')),
+    CreatedById INTEGER REFERENCES users(Id) ON DELETE SET NULL,
+    CreatedAt TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX credit_notes_invoiceid_idx ON credit_notes (InvoiceId);
+
+-- Each line credits some or all of the quantity on one invoice line, at the invoice's price and tax rate
+CREATE TABLE credit_note_items (
+    ItemId SERIAL PRIMARY KEY,
+    CreditNoteId INTEGER NOT NULL REFERENCES credit_notes(CreditNoteId) ON DELETE CASCADE,
+    InvoiceItemId INTEGER NOT NULL REFERENCES item_lists(ItemId) ON DELETE RESTRICT,
+    Item TEXT NOT NULL,
+    Quantity INTEGER NOT NULL CHECK (Quantity > 0),
+    ProductId INTEGER REFERENCES products(ProductId) ON DELETE SET NULL,
+    UnitPrice NUMERIC(14, 2) NOT NULL,
+    TaxCode TEXT REFERENCES tax_codes(Code),
+    TaxName TEXT NOT NULL DEFAULT '',
+    -- Hundredths of a percent, 1000 is 10%
+    TaxRate INTEGER NOT NULL DEFAULT 0 CHECK (TaxRate BETWEEN 0 AND 10000),
+    Subtotal NUMERIC(14, 2) NOT NULL,
+    Tax NUMERIC(14, 2) NOT NULL,
+    Total NUMERIC(14, 2) NOT NULL
+);
+
+CREATE INDEX credit_note_items_creditnoteid_idx ON credit_note_items (CreditNoteId);
+CREATE INDEX credit_note_items_invoiceitemid_idx ON credit_note_items (InvoiceItemId);
+
+-- Refunds are recorded as negative payments belonging to the credit note that paid them out
+ALTER TABLE payments
+    DROP CONSTRAINT IF EXISTS payments_amount_check,
+    ADD COLUMN CreditNoteId INTEGER REFERENCES credit_notes(CreditNoteId) ON DELETE RESTRICT,
+    ADD CONSTRAINT payments_amount_check CHECK (Amount <> 0 AND (Amount < 0) = (CreditNoteId IS NOT NULL));
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS invoices_schedule_period_idx;
+ALTER TABLE invoices
+    DROP COLUMN IF EXISTS PeriodEnd,
+    DROP COLUMN IF EXISTS PeriodStart,
+    DROP COLUMN IF EXISTS ScheduleId;
+
+DROP TABLE IF EXISTS recurring_invoice_items;
+DROP TABLE IF EXISTS recurring_invoices;
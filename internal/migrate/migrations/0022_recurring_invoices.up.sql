@@ -0,0 +1,54 @@
+-- Recurring invoices raise the same invoice for a customer every period, e.g. a monthly support plan.
+-- NextRun is the start of the next period to invoice, catching up one period at a time after any downtime.
+CREATE TABLE recurring_invoices (
+    ScheduleId SERIAL PRIMARY KEY,
+    CustomerId INTEGER NOT NULL REFERENCES customers(Id) ON DELETE CASCADE,
+    -- NULL bills the customer's default billing address as it is when each invoice is raised
+    BillingAddressId INTEGER REFERENCES customer_addresses(AddressId) ON DELETE SET NULL,
+    Description TEXT NOT NULL DEFAULT '',
+    IntervalUnit TEXT NOT NULL CHECK (IntervalUnit IN ('week', 'month', 'year')),
+    IntervalCount INTEGER NOT NULL DEFAULT 1 CHECK (IntervalCount BETWEEN 1 AND 52),
+    StartDate DATE NOT NULL,
+    EndDate DATE CHECK (EndDate IS NULL OR EndDate >= StartDate),
+    NextRun DATE NOT NULL,
+    DueDays INTEGER NOT NULL DEFAULT 14 CHECK (DueDays BETWEEN 0 AND 365),
+    -- Issue and email each invoice straight away, otherwise it is left as a draft to check
+    AutoSend BOOLEAN NOT NULL DEFAULT false,
+    Active BOOLEAN NOT NULL DEFAULT true,
+    Currency TEXT NOT NULL DEFAULT 'AUD' CHECK (Currency ~ '^[A-Z]{3}$'),
+    TaxRounding TEXT NOT NULL DEFAULT 'line' CHECK (TaxRounding IN ('line', 'invoice')),
+    PricesIncludeTax BOOLEAN NOT NULL DEFAULT false,
+    CreatedById INTEGER REFERENCES users(Id) ON DELETE SET NULL,
+    CreatedAt TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UpdatedAt TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX recurring_invoices_nextrun_idx ON recurring_invoices (NextRun) WHERE Active;
+CREATE INDEX recurring_invoices_customer_idx ON recurring_invoices (CustomerId);
+
+-- The same columns as item_lists, copied onto every invoice the schedule raises
+CREATE TABLE recurring_invoice_items (
+    ItemId SERIAL PRIMARY KEY,
+    ScheduleId INTEGER NOT NULL REFERENCES recurring_invoices(ScheduleId) ON DELETE CASCADE,
+    Item TEXT NOT NULL,
+    Quantity INTEGER NOT NULL,
+    ProductId INTEGER REFERENCES products(ProductId) ON DELETE SET NULL,
+    UnitPrice NUMERIC(14, 2) NOT NULL,
+    TaxCode TEXT REFERENCES tax_codes(Code),
+    TaxName TEXT NOT NULL DEFAULT '',
+    -- Hundredths of a percent, 1000 is 10%
+    TaxRate INTEGER NOT NULL DEFAULT 0 CHECK (TaxRate BETWEEN 0 AND 10000),
+    Subtotal NUMERIC(14, 2) NOT NULL,
+    Tax NUMERIC(14, 2) NOT NULL,
+    Total NUMERIC(14, 2) NOT NULL
+);
+
+CREATE INDEX recurring_invoice_items_scheduleid_idx ON recurring_invoice_items (ScheduleId);
+
+-- Invoices raised by a schedule record the period they bill for. The index is what guarantees a period
+-- is only ever invoiced once, even if two runs overlap.
+ALTER TABLE invoices
+    ADD COLUMN ScheduleId INTEGER REFERENCES recurring_invoices(ScheduleId) ON DELETE SET NULL,
+    ADD COLUMN PeriodStart DATE,
+    ADD COLUMN PeriodEnd DATE;
+CREATE UNIQUE INDEX invoices_schedule_period_idx ON invoices (ScheduleId, PeriodStart) WHERE ScheduleId IS NOT NULL;
@@ -0,0 +1,8 @@
+ALTER TABLE invoices DROP CONSTRAINT IF EXISTS invoices_issued_check;
+
+-- Earlier versions expect every invoice to have a number
+UPDATE invoices SET InvoiceNumber = 'DRAFT' || InvoiceId WHERE InvoiceNumber IS NULL;
+
+ALTER TABLE invoices
+    DROP COLUMN IF EXISTS IssuedById,
+    DROP COLUMN IF EXISTS IssuedAt;
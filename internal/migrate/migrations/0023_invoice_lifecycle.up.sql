@@ -0,0 +1,10 @@
+-- Drafts are numbered when they are issued. Drafts saved so far keep the number they were given and are issued
+-- under it, dropping it would leave a gap in the series as the counter has already moved past it.
+ALTER TABLE invoices
+    ADD COLUMN IssuedAt TIMESTAMP WITHOUT TIME ZONE,
+    ADD COLUMN IssuedById INTEGER REFERENCES users(Id) ON DELETE SET NULL;
+
+UPDATE invoices SET IssuedAt = InvoiceDate, IssuedById = CreatedById WHERE Status <> 'draft';
+
+ALTER TABLE invoices ADD CONSTRAINT invoices_issued_check
+    CHECK ((Status = 'draft' OR InvoiceNumber IS NOT NULL) AND (Status = 'draft') = (IssuedAt IS NULL));
@@ -0,0 +1,16 @@
+-- Fails if series sharing a prefix have handed out the same number since
+ALTER TABLE credit_notes
+    DROP CONSTRAINT IF EXISTS credit_notes_creditnotenumber_key,
+    DROP COLUMN IF EXISTS NumberSeries,
+    ADD CONSTRAINT credit_notes_creditnotenumber_key UNIQUE (CreditNoteNumber);
+
+ALTER TABLE estimates
+    DROP CONSTRAINT IF EXISTS estimates_estimatenumber_key,
+    DROP COLUMN IF EXISTS NumberSeries,
+    ADD CONSTRAINT estimates_estimatenumber_key UNIQUE (EstimateNumber);
+
+ALTER TABLE invoices
+    DROP CONSTRAINT IF EXISTS invoices_numberseries_check,
+    DROP CONSTRAINT IF EXISTS invoices_invoicenumber_key,
+    DROP COLUMN IF EXISTS NumberSeries,
+    ADD CONSTRAINT invoices_invoicenumber_key UNIQUE (InvoiceNumber);
@@ -0,0 +1,21 @@
+-- Numbers only have to be unique within their numbering series, so series sharing a prefix don't clash.
+-- Counters are kept per series, numbers handed out so far are put in the default series of each kind
+-- as they are already unique across every series.
+ALTER TABLE invoices ADD COLUMN NumberSeries TEXT;
+UPDATE invoices SET NumberSeries = 'default' WHERE InvoiceNumber IS NOT NULL;
+ALTER TABLE invoices
+    DROP CONSTRAINT invoices_invoicenumber_key,
+    ADD CONSTRAINT invoices_invoicenumber_key UNIQUE (NumberSeries, InvoiceNumber),
+    ADD CONSTRAINT invoices_numberseries_check CHECK ((InvoiceNumber IS NULL) = (NumberSeries IS NULL));
+
+ALTER TABLE estimates ADD COLUMN NumberSeries TEXT NOT NULL DEFAULT 'estimate';
+ALTER TABLE estimates ALTER COLUMN NumberSeries DROP DEFAULT;
+ALTER TABLE estimates
+    DROP CONSTRAINT estimates_estimatenumber_key,
+    ADD CONSTRAINT estimates_estimatenumber_key UNIQUE (NumberSeries, EstimateNumber);
+
+ALTER TABLE credit_notes ADD COLUMN NumberSeries TEXT NOT NULL DEFAULT 'credit-note';
+ALTER TABLE credit_notes ALTER COLUMN NumberSeries DROP DEFAULT;
+ALTER TABLE credit_notes
+    DROP CONSTRAINT credit_notes_creditnotenumber_key,
+    ADD CONSTRAINT credit_notes_creditnotenumber_key UNIQUE (NumberSeries, CreditNoteNumber);
@@ -0,0 +1,172 @@
+package model
+
+import (
+	"sort"
+	"time"
+)
+
+// CreditNote reverses all or part of an issued invoice. Issued invoices can't be changed,
+// so anything charged by mistake or returned is credited instead.
+// The customer, currency and tax settings always come from the invoice being credited.
+type CreditNote struct {
+	CreditNoteId     string
+	CreditNoteNumber string
+	InvoiceId        string
+	InvoiceNumber    string
+	InvoiceDate      time.Time
+	CustomerId       string
+	CustomerName     string
+	CompanyName      string
+	CustomerPhone    string
+	CustomerEmail    string
+	CustomerAddress  Address
+	CreditDate       time.Time
+	Reason           string
+	Settlement       CreditSettlement
+	RefundMethod     PaymentMethod // How the refund was paid back, only set when the credit is refunded
+	ItemList         []ItemList    // Each line's InvoiceItemId is the invoice line it credits
+	Currency         Currency
+	TaxRounding      TaxRounding
+	PricesIncludeTax bool
+	TaxSummary       []TaxSummaryLine
+	Subtotal         Money
+	Tax              Money
+	Total            Money
+	CreatedById      int
+	CreatedAt        time.Time
+}
+
+// CreditSettlement is what happens to the credited amount
+type CreditSettlement string
+
+const (
+	CreditBalance CreditSettlement = "balance" // Taken off what the customer still owes on the invoice
+	CreditRefund  CreditSettlement = "refund"  // Paid back to the customer, recorded as a negative payment
+)
+
+var CreditSettlements = []CreditSettlement{CreditBalance, CreditRefund}
+
+func (s CreditSettlement) Valid() bool {
+	return s == CreditBalance || s == CreditRefund
+}
+
+// NewCreditNote starts a credit note against the invoice, the lines are added with Credit
+func NewCreditNote(invoice Invoice) CreditNote {
+	var c CreditNote
+	c.CopyInvoice(invoice)
+	return c
+}
+
+// CopyInvoice fills in the invoice details, customer and tax settings from the invoice being credited
+func (c *CreditNote) CopyInvoice(invoice Invoice) {
+	c.InvoiceId, c.InvoiceNumber, c.InvoiceDate = invoice.InvoiceId, invoice.InvoiceNumber, invoice.InvoiceDate
+	c.CustomerId, c.CustomerName, c.CompanyName = invoice.CustomerId, invoice.CustomerName, invoice.CompanyName
+	c.CustomerPhone, c.CustomerEmail, c.CustomerAddress = invoice.CustomerPhone, invoice.CustomerEmail, invoice.CustomerAddress
+	c.Currency, c.TaxRounding, c.PricesIncludeTax = invoice.Currency, invoice.TaxRounding, invoice.PricesIncludeTax
+}
+
+// Credit adds a line crediting quantity of the invoice line, at the price and tax rate it was invoiced at
+func (c *CreditNote) Credit(item ItemList, quantity int32) {
+	item.InvoiceItemId, item.ItemId, item.InvoiceId = item.ItemId, 0, ""
+	item.Quantity = quantity
+	c.ItemList = append(c.ItemList, item)
+}
+
+// CalculateTotals works out the lines, tax summary and totals the same way as the invoice
+func (c *CreditNote) CalculateTotals() {
+	invoice := Invoice{
+		ItemList:         c.ItemList,
+		Currency:         c.Currency,
+		TaxRounding:      c.TaxRounding,
+		PricesIncludeTax: c.PricesIncludeTax,
+	}
+	invoice.CalculateTotals()
+	c.ItemList, c.Currency = invoice.ItemList, invoice.Currency
+	c.TaxSummary, c.Subtotal, c.Tax, c.Total = invoice.TaxSummary, invoice.Subtotal, invoice.Tax, invoice.Total
+}
+
+// IncludesGST reports whether any credited line had GST on it, so the note reverses GST. Needs CalculateTotals first.
+func (c CreditNote) IncludesGST() bool {
+	return includesGST(c.TaxSummary)
+}
+
+// Uncredited gives the quantity of each invoice line, keyed by ItemId, that no credit note has credited yet
+func (i Invoice) Uncredited() map[int]int32 {
+	remaining := make(map[int]int32, len(i.ItemList))
+	for _, item := range i.ItemList {
+		remaining[item.ItemId] += item.Quantity
+	}
+	for _, note := range i.CreditNotes {
+		for _, item := range note.ItemList {
+			remaining[item.InvoiceItemId] -= item.Quantity
+		}
+	}
+	return remaining
+}
+
+// CanCredit reports whether a credit note can be raised, only issued invoices with something left uncredited can be
+func (i Invoice) CanCredit() bool {
+	if i.Status != IssuedInvoice {
+		return false
+	}
+	for _, quantity := range i.Uncredited() {
+		if quantity > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// FinancialRecord is one invoice or credit note in a customer's financial history
+type FinancialRecord struct {
+	Date   time.Time
+	Kind   string // Invoice or Credit Note
+	Number string
+	Link   string
+	Status string
+	Amount Money // Credit notes are negative
+	Due    Money // What is still owed, only set on invoices
+}
+
+// CustomerFinancialHistory lists the issued and voided invoices along with their credit notes, newest first.
+// Drafts haven't been sent to the customer so they are left out.
+func CustomerFinancialHistory(invoices []Invoice) []FinancialRecord {
+	var records []FinancialRecord
+	for _, invoice := range invoices {
+		if invoice.Status == DraftInvoice {
+			continue
+		}
+		status := invoice.PaymentStatus.String()
+		due := invoice.BalanceDue
+		if invoice.Status == VoidInvoice {
+			status, due = "Void", NewMoney(0, invoice.Currency)
+		}
+		records = append(records, FinancialRecord{
+			Date:   invoice.InvoiceDate,
+			Kind:   "Invoice",
+			Number: invoice.InvoiceNumber,
+			Link:   "/invoice/view/" + invoice.InvoiceId,
+			Status: status,
+			Amount: invoice.Total,
+			Due:    due,
+		})
+		for _, note := range invoice.CreditNotes {
+			status := "Credited to balance"
+			if note.Settlement == CreditRefund {
+				status = "Refunded"
+			}
+			records = append(records, FinancialRecord{
+				Date:   note.CreditDate,
+				Kind:   "Credit Note",
+				Number: note.CreditNoteNumber,
+				Link:   "/credit-note/view/" + note.CreditNoteId,
+				Status: status,
+				Amount: NewMoney(0, note.Currency).Sub(note.Total),
+			})
+		}
+	}
+	sort.SliceStable(records, func(a, b int) bool {
+		return records[a].Date.After(records[b].Date)
+	})
+	return records
+}
@@ -1,6 +1,9 @@
 package model
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 type Customer struct {
 	Id                 int
@@ -14,14 +17,22 @@ type Customer struct {
 	Industry           string
 	InitialServiceType string
 	CurrentServiceType string
-	Address            Address
+	NoReminders        bool // The customer has asked not to be sent payment reminders
+	Addresses          []CustomerAddress
 	Invoices           []Invoice
 	LeadId             int
 	Notes              []Note
 	ServiceHistory     []ServiceEntry
 	CreatedAt          time.Time
 	UpdatedAt          time.Time
+	DeletedAt          *time.Time // Set when the customer has been archived
 }
+
+// Archived reports whether the customer has been archived rather than purged
+func (c Customer) Archived() bool {
+	return c.DeletedAt != nil
+}
+
 type Address struct {
 	UnitNumber   string
 	StreetNumber string
@@ -31,6 +42,46 @@ type Address struct {
 	Postcode     string
 }
 
+// String formats the address on a single line, skipping any empty parts
+func (a Address) String() string {
+	street := strings.TrimSpace(a.StreetNumber + " " + a.StreetName)
+	if a.UnitNumber != "" {
+		street = a.UnitNumber + "/" + street
+	}
+
+	var parts []string
+	for _, part := range []string{street, a.City, strings.TrimSpace(a.State + " " + a.Postcode)} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+type AddressType string
+
+const (
+	BillingAddress AddressType = "billing"
+	ServiceAddress AddressType = "service"
+)
+
+var AddressTypes = []AddressType{BillingAddress, ServiceAddress}
+
+func (t AddressType) Valid() bool {
+	return t == BillingAddress || t == ServiceAddress
+}
+
+// CustomerAddress is one of a customer's saved billing or service (site) addresses
+type CustomerAddress struct {
+	AddressId  int
+	CustomerId int
+	Type       AddressType
+	IsDefault  bool
+	Address
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
 type ServiceEntry struct {
 	ServiceType string
 	StartDate   time.Time
@@ -0,0 +1,116 @@
+package model
+
+import (
+	"time"
+)
+
+// Estimate is a quote for work, priced with the same line items as an invoice.
+// Once the customer accepts it, it can be converted into an invoice.
+type Estimate struct {
+	EstimateId       string
+	EstimateNumber   string
+	EstimateDate     time.Time
+	ValidUntil       time.Time
+	CustomerId       string
+	CustomerName     string
+	CompanyName      string
+	CustomerPhone    string
+	CustomerEmail    string
+	Status           EstimateStatus
+	CustomerAddress  Address // Copied from the billing address when the estimate is created
+	BillingAddressId int
+	ItemList         []ItemList
+	Currency         Currency
+	TaxRounding      TaxRounding
+	PricesIncludeTax bool
+	TaxSummary       []TaxSummaryLine
+	Subtotal         Money
+	Tax              Money
+	Total            Money
+	InvoiceId        string // The invoice the estimate was converted into, empty until then
+	InvoiceNumber    string
+	CreatedById      int
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+// Invoice copies the customer, address and line items into a new invoice linked back to the estimate
+func (e Estimate) Invoice() Invoice {
+	return Invoice{
+		EstimateId:       e.EstimateId,
+		EstimateNumber:   e.EstimateNumber,
+		CustomerId:       e.CustomerId,
+		CustomerName:     e.CustomerName,
+		CompanyName:      e.CompanyName,
+		CustomerPhone:    e.CustomerPhone,
+		CustomerEmail:    e.CustomerEmail,
+		CustomerAddress:  e.CustomerAddress,
+		BillingAddressId: e.BillingAddressId,
+		ItemList:         append([]ItemList(nil), e.ItemList...),
+		Currency:         e.Currency,
+		TaxRounding:      e.TaxRounding,
+		PricesIncludeTax: e.PricesIncludeTax,
+	}
+}
+
+// CalculateTotals works out the lines, tax summary and totals the same way as an invoice
+func (e *Estimate) CalculateTotals() {
+	invoice := e.Invoice()
+	invoice.CalculateTotals()
+	e.ItemList, e.Currency = invoice.ItemList, invoice.Currency
+	e.TaxSummary, e.Subtotal, e.Tax, e.Total = invoice.TaxSummary, invoice.Subtotal, invoice.Tax, invoice.Total
+}
+
+// IncludesGST reports whether GST applies to any line, CalculateTotals has to be called first
+func (e Estimate) IncludesGST() bool {
+	return includesGST(e.TaxSummary)
+}
+
+// CanConvert reports whether an invoice can be raised from the estimate. Declined and expired estimates
+// can't be, and an estimate only ever has one invoice that hasn't been voided.
+func (e Estimate) CanConvert() bool {
+	return e.InvoiceId == "" && (e.Status == DraftEstimate || e.Status == SentEstimate || e.Status == AcceptedEstimate)
+}
+
+// EstimateStatus tracks an estimate from being written up to the customer's answer
+type EstimateStatus string
+
+const (
+	DraftEstimate    EstimateStatus = "draft"
+	SentEstimate     EstimateStatus = "sent"
+	AcceptedEstimate EstimateStatus = "accepted"
+	DeclinedEstimate EstimateStatus = "declined"
+	ExpiredEstimate  EstimateStatus = "expired" // Set automatically once the valid until date has passed
+)
+
+var EstimateStatuses = []EstimateStatus{DraftEstimate, SentEstimate, AcceptedEstimate, DeclinedEstimate, ExpiredEstimate}
+
+func (s EstimateStatus) Valid() bool {
+	for _, status := range EstimateStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// estimateTransitions lists the statuses each status can be moved to by hand, accepted and declined are final
+var estimateTransitions = map[EstimateStatus][]EstimateStatus{
+	DraftEstimate: {SentEstimate, AcceptedEstimate, DeclinedEstimate},
+	SentEstimate:  {AcceptedEstimate, DeclinedEstimate, ExpiredEstimate},
+}
+
+// CanMoveTo reports whether an estimate in this status can be moved to next
+func (s EstimateStatus) CanMoveTo(next EstimateStatus) bool {
+	for _, status := range estimateTransitions[s] {
+		if status == next {
+			return true
+		}
+	}
+	return false
+}
+
+// NextStatuses lists the statuses an estimate can be moved to from here
+func (s EstimateStatus) NextStatuses() []EstimateStatus {
+	return estimateTransitions[s]
+}
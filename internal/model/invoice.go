@@ -5,69 +5,247 @@ import (
 )
 
 type Invoice struct {
-	InvoiceId       string
-	InvoiceNumber   string
-	InvoiceDate     time.Time
-	DueDate         time.Time
-	CustomerId      string
-	CustomerName    string
-	CompanyName     string
-	CustomerPhone   string
-	CustomerEmail   string
-	PaymentStatus   PaymentStatus
-	CustomerAddress Address
-	ItemList        []ItemList
-	CreatedAt       time.Time
-	UpdatedAt       time.Time
+	InvoiceId        string
+	InvoiceNumber    string    // Empty until the invoice is issued
+	InvoiceDate      time.Time // The day it was issued, drafts hold the day they were last saved
+	DueDate          time.Time
+	CustomerId       string
+	CustomerName     string
+	CompanyName      string
+	CustomerPhone    string
+	CustomerEmail    string
+	PaymentStatus    PaymentStatus
+	Status           InvoiceStatus
+	VoidedAt         *time.Time
+	VoidReason       string
+	CustomerAddress  Address // Copied from the billing address when the invoice is saved and again when it is issued
+	BillingAddressId int
+	ItemList         []ItemList
+	Currency         Currency
+	TaxRounding      TaxRounding
+	PricesIncludeTax bool // Unit prices are tax inclusive, the tax is worked out of them rather than added on
+	TaxSummary       []TaxSummaryLine
+	Subtotal         Money
+	Tax              Money
+	Total            Money
+	Payments         []Payment
+	AmountPaid       Money // Net of any refunds
+	CreditNotes      []CreditNote
+	Credited         Money
+	BalanceDue       Money
+	History          []PaymentStatusChange
+	Emails           []InvoiceEmail
+	EstimateId       string // The estimate the invoice was converted from, if any
+	EstimateNumber   string
+	ScheduleId       string     // The recurring invoice schedule that raised the invoice, if any
+	PeriodStart      *time.Time // The period a recurring invoice bills for
+	PeriodEnd        *time.Time
+	IssuedAt         *time.Time
+	IssuedById       int
+	CreatedById      int
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
 }
 
 type ItemList struct {
-	InvoiceId string
-	Item      string
-	Quantity  int32
-	UnitPrice int32
-	Subtotal  int32
-	Tax       int32
-	Total     int32
+	ItemId        int
+	InvoiceId     string
+	InvoiceItemId int // The invoice line a credit note line credits, 0 on invoices and estimates
+	Item          string
+	Quantity      int32
+	ProductId     int
+	UnitPrice     Money
+	TaxCode       string
+	TaxName       string
+	TaxRate       TaxRate
+	Subtotal      Money
+	Tax           Money // Always rounded to the cent, even when the invoice rounds tax per invoice
+	Total         Money
 }
 
-type PaymentStatus int
+// CalculateTotals works out the line amounts from the quantity, unit price and tax rate.
+// With tax inclusive prices the line total is the price times the quantity and the subtotal has the tax taken out.
+func (item *ItemList) CalculateTotals(pricesIncludeTax bool) {
+	amount := item.UnitPrice.Times(int64(item.Quantity))
+	item.Tax = taxOn(amount, item.TaxRate, pricesIncludeTax)
+	if pricesIncludeTax {
+		item.Subtotal, item.Total = amount.Sub(item.Tax), amount
+	} else {
+		item.Subtotal, item.Total = amount, amount.Add(item.Tax)
+	}
+}
+
+// CalculateTotals works out every line, the tax summary and the invoice subtotal, tax and total,
+// rounding the tax per line or once per tax code depending on TaxRounding.
+// The balance due is what is left of the total after credit notes and payments, credit notes have to be worked out first.
+func (i *Invoice) CalculateTotals() {
+	if i.Currency == "" {
+		i.Currency = DefaultCurrency
+	}
+	zero := NewMoney(0, i.Currency)
+	i.Subtotal, i.Tax, i.Total = zero, zero, zero
+	i.TaxSummary = nil
 
+	for idx := range i.ItemList {
+		item := &i.ItemList[idx]
+		item.UnitPrice.Currency = i.Currency
+		item.CalculateTotals(i.PricesIncludeTax)
 
+		line := i.taxSummaryLine(item)
+		line.Net = line.Net.Add(item.Subtotal)
+		line.Tax = line.Tax.Add(item.Tax)
+		if i.PricesIncludeTax {
+			line.amount = line.amount.Add(item.Total)
+		} else {
+			line.amount = line.amount.Add(item.Subtotal)
+		}
+	}
+
+	for idx := range i.TaxSummary {
+		line := &i.TaxSummary[idx]
+		if i.TaxRounding == RoundTaxPerInvoice {
+			line.Tax = taxOn(line.amount, line.Rate, i.PricesIncludeTax)
+			if i.PricesIncludeTax {
+				line.Net = line.amount.Sub(line.Tax)
+			}
+		}
+		i.Subtotal = i.Subtotal.Add(line.Net)
+		i.Tax = i.Tax.Add(line.Tax)
+	}
+	i.Total = i.Subtotal.Add(i.Tax)
+
+	i.AmountPaid = zero
+	for idx := range i.Payments {
+		i.Payments[idx].Amount.Currency = i.Currency
+		i.AmountPaid = i.AmountPaid.Add(i.Payments[idx].Amount)
+	}
+	i.Credited = zero
+	for _, note := range i.CreditNotes {
+		i.Credited = i.Credited.Add(note.Total)
+	}
+	i.BalanceDue = i.Total.Sub(i.Credited).Sub(i.AmountPaid)
+}
+
+// DerivePaymentStatus works the payment status out from the payments, CalculateTotals has to be called first.
+// Invoices with nothing paid are overdue once today is past the due date.
+func (i Invoice) DerivePaymentStatus(today time.Time) PaymentStatus {
+	switch {
+	case i.BalanceDue.Cents <= 0:
+		return Paid
+	case i.AmountPaid.Cents > 0:
+		return PartiallyPaid
+	case !i.DueDate.IsZero() && i.DueDate.Before(time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, i.DueDate.Location())):
+		return Overdue
+	default:
+		return Pending
+	}
+}
+
+// CanTakePayment reports whether payments can be recorded, only issued invoices with something owing can be paid
+func (i Invoice) CanTakePayment() bool {
+	return i.Status == IssuedInvoice && i.BalanceDue.Cents > 0
+}
+
+// taxSummaryLine finds the summary line for the item's tax code, adding one when it is the first
+func (i *Invoice) taxSummaryLine(item *ItemList) *TaxSummaryLine {
+	for idx := range i.TaxSummary {
+		if i.TaxSummary[idx].Code == item.TaxCode && i.TaxSummary[idx].Rate == item.TaxRate {
+			return &i.TaxSummary[idx]
+		}
+	}
+	name := item.TaxName
+	if name == "" {
+		name = "Tax " + item.TaxRate.String()
+	}
+	zero := NewMoney(0, i.Currency)
+	i.TaxSummary = append(i.TaxSummary, TaxSummaryLine{Code: item.TaxCode, Name: name, Rate: item.TaxRate, Net: zero, Tax: zero, amount: zero})
+	return &i.TaxSummary[len(i.TaxSummary)-1]
+}
+
+// IsTaxInvoice reports whether GST applies to any line, those invoices have to be labelled a tax invoice and show our ABN
+func (i Invoice) IsTaxInvoice() bool {
+	return includesGST(i.TaxSummary)
+}
+
+// InvoiceStatus tracks whether an invoice has been sent. Drafts can be edited and deleted and have no number yet.
+// Issuing numbers the invoice and locks its lines, issued invoices are never deleted, they are voided instead.
+// Once issued the payment status moves on from pending to partially paid to paid as payments are recorded.
+type InvoiceStatus string
 
 const (
-	Paid    PaymentStatus = iota // 0
-	Pending                      // 1
-	Overdue                      // 2
+	DraftInvoice  InvoiceStatus = "draft"
+	IssuedInvoice InvoiceStatus = "issued"
+	VoidInvoice   InvoiceStatus = "void"
 )
 
-// This code will be used for the business logic, however i want to keep track roughly of my intention before I get to that point
-
-// import (
-//     "fmt"
-//     "model"  - Just import the model folder
-// )
-
-
-// func main() {
-//     fmt.Println(model.Paid)    // Output: 0
-//     fmt.Println(model.Pending) // Output: 1
-//     fmt.Println(model.Overdue) // Output: 2
-
-//     // Use the enum in a function
-//     printStatus(model.Pending)
-// }
-
-// // Function that uses the PaymentStatus type
-// func printStatus(status model.PaymentStatus) {
-//     switch status {
-//     case model.Paid:
-//         fmt.Println("Payment is Paid")
-//     case model.Pending:
-//         fmt.Println("Payment is Pending")
-//     case model.Overdue:
-//         fmt.Println("Payment is Overdue")
-//     default:
-//         fmt.Println("Unknown Payment Status")
-//     }
-// }
+var InvoiceStatuses = []InvoiceStatus{DraftInvoice, IssuedInvoice, VoidInvoice}
+
+func (s InvoiceStatus) Valid() bool {
+	return s == DraftInvoice || s == IssuedInvoice || s == VoidInvoice
+}
+
+// invoiceTransitions lists the statuses an invoice can move to from each status, void is final
+var invoiceTransitions = map[InvoiceStatus][]InvoiceStatus{
+	DraftInvoice:  {IssuedInvoice},
+	IssuedInvoice: {VoidInvoice},
+}
+
+// CanBecome reports whether an invoice with this status can be moved to next
+func (s InvoiceStatus) CanBecome(next InvoiceStatus) bool {
+	for _, allowed := range invoiceTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// InvoiceFilter narrows down the invoice list, zero values match everything
+type InvoiceFilter struct {
+	Query         string // Matches the invoice number, customer name, company or email
+	CustomerId    string
+	ScheduleId    string
+	Status        InvoiceStatus
+	PaymentStatus *PaymentStatus
+	From          time.Time // Invoice date on or after
+	To            time.Time // Invoice date on or before
+}
+
+type PaymentStatus int
+
+const (
+	Paid          PaymentStatus = iota // 0
+	Pending                            // 1
+	Overdue                            // 2
+	PartiallyPaid                      // 3
+)
+
+var PaymentStatuses = []PaymentStatus{Pending, PartiallyPaid, Paid, Overdue}
+
+// PaymentStatusChange records an invoice's payment status changing, either from a payment or from falling overdue
+type PaymentStatusChange struct {
+	HistoryId     int
+	InvoiceId     string
+	FromStatus    *PaymentStatus // nil when nothing was recorded before the change
+	ToStatus      PaymentStatus
+	ChangedById   int // 0 when the scheduler made the change
+	ChangedByName string
+	Reason        string
+	ChangedAt     time.Time
+}
+
+// String gives the label shown for the payment status
+func (s PaymentStatus) String() string {
+	switch s {
+	case Paid:
+		return "Paid"
+	case Pending:
+		return "Pending"
+	case Overdue:
+		return "Overdue"
+	case PartiallyPaid:
+		return "Partially Paid"
+	default:
+		return "Unknown"
+	}
+}
@@ -0,0 +1,85 @@
+package model
+
+import "testing"
+
+// gst is a line at GST 10%
+func gst(cents int64, quantity int32) ItemList {
+	return ItemList{Item: "Labour", Quantity: quantity, UnitPrice: NewMoney(cents, AUD), TaxCode: "GST", TaxName: "GST", TaxRate: 1000}
+}
+
+func TestInvoiceCalculateTotals(t *testing.T) {
+	tests := []struct {
+		name             string
+		rounding         TaxRounding
+		pricesIncludeTax bool
+		items            []ItemList
+		subtotal         int64
+		tax              int64
+		total            int64
+	}{
+		{"exclusive", RoundTaxPerLine, false, []ItemList{gst(12500, 2), gst(4995, 1)}, 29995, 3000, 32995},
+		{"inclusive", RoundTaxPerLine, true, []ItemList{gst(11000, 2), gst(5500, 1)}, 25000, 2500, 27500},
+		// Each line's half cent rounds up, the same 15 cents taxed once is 1.5 cents
+		{"exclusive per line", RoundTaxPerLine, false, []ItemList{gst(5, 1), gst(5, 1), gst(5, 1)}, 15, 3, 18},
+		{"exclusive per invoice", RoundTaxPerInvoice, false, []ItemList{gst(5, 1), gst(5, 1), gst(5, 1)}, 15, 2, 17},
+		// Inclusive totals stay what the customer was quoted, only the split between net and tax moves
+		{"inclusive per line", RoundTaxPerLine, true, []ItemList{gst(5, 1), gst(5, 1)}, 10, 0, 10},
+		{"inclusive per invoice", RoundTaxPerInvoice, true, []ItemList{gst(5, 1), gst(5, 1)}, 9, 1, 10},
+		{"no items", RoundTaxPerLine, false, nil, 0, 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			invoice := Invoice{TaxRounding: test.rounding, PricesIncludeTax: test.pricesIncludeTax, ItemList: test.items}
+			invoice.CalculateTotals()
+			if invoice.Subtotal.Cents != test.subtotal || invoice.Tax.Cents != test.tax || invoice.Total.Cents != test.total {
+				t.Errorf("subtotal, tax, total = %d, %d, %d, want %d, %d, %d",
+					invoice.Subtotal.Cents, invoice.Tax.Cents, invoice.Total.Cents, test.subtotal, test.tax, test.total)
+			}
+			if invoice.Currency != DefaultCurrency || invoice.Total.Currency != DefaultCurrency {
+				t.Errorf("currency = %q, total in %q, want %q", invoice.Currency, invoice.Total.Currency, DefaultCurrency)
+			}
+			if invoice.BalanceDue != invoice.Total {
+				t.Errorf("balance due = %v, want the total %v", invoice.BalanceDue, invoice.Total)
+			}
+		})
+	}
+}
+
+func TestInvoiceTaxSummary(t *testing.T) {
+	free := ItemList{Item: "Fresh food", Quantity: 3, UnitPrice: NewMoney(333, AUD), TaxCode: "FRE", TaxName: "GST free"}
+	invoice := Invoice{TaxRounding: RoundTaxPerLine, ItemList: []ItemList{gst(1000, 1), free, gst(2000, 1)}}
+	invoice.CalculateTotals()
+
+	want := []TaxSummaryLine{
+		{Code: "GST", Name: "GST", Rate: 1000, Net: NewMoney(3000, AUD), Tax: NewMoney(300, AUD)},
+		{Code: "FRE", Name: "GST free", Net: NewMoney(999, AUD), Tax: NewMoney(0, AUD)},
+	}
+	if len(invoice.TaxSummary) != len(want) {
+		t.Fatalf("tax summary has %d lines, want %d", len(invoice.TaxSummary), len(want))
+	}
+	for idx, line := range invoice.TaxSummary {
+		line.amount = Money{}
+		if line != want[idx] {
+			t.Errorf("tax summary line %d = %+v, want %+v", idx, line, want[idx])
+		}
+	}
+	if !invoice.IsTaxInvoice() {
+		t.Error("invoice with GST lines isn't a tax invoice")
+	}
+}
+
+func TestInvoiceBalanceDue(t *testing.T) {
+	invoice := Invoice{
+		TaxRounding: RoundTaxPerLine,
+		ItemList:    []ItemList{gst(10000, 1)},
+		Payments:    []Payment{{Amount: NewMoney(5000, "")}},
+		CreditNotes: []CreditNote{{Total: NewMoney(1000, AUD)}},
+	}
+	invoice.CalculateTotals()
+	if invoice.AmountPaid.Cents != 5000 || invoice.Credited.Cents != 1000 || invoice.BalanceDue.Cents != 5000 {
+		t.Errorf("paid, credited, balance due = %d, %d, %d, want 5000, 1000, 5000", invoice.AmountPaid.Cents, invoice.Credited.Cents, invoice.BalanceDue.Cents)
+	}
+	if invoice.Payments[0].Amount.Currency != AUD {
+		t.Errorf("payment currency = %q, want the invoice's %q", invoice.Payments[0].Amount.Currency, AUD)
+	}
+}
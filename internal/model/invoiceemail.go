@@ -0,0 +1,23 @@
+package model
+
+import "time"
+
+// InvoiceEmail is one attempt at emailing an invoice, kept whether or not the mail server took it
+type InvoiceEmail struct {
+	EmailId        int
+	InvoiceId      string
+	Recipient      string
+	Subject        string
+	MessageId      string     // The Message-ID header, for finding the email in the mail server's logs
+	SentAt         *time.Time // nil when sending failed
+	Error          string
+	SentById       int
+	SentByName     string
+	ReminderStepId int // Set for payment reminders, 0 when the invoice itself was sent
+	ReminderName   string
+	CreatedAt      time.Time
+}
+
+func (e InvoiceEmail) Delivered() bool {
+	return e.SentAt != nil
+}
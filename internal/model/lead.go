@@ -25,6 +25,61 @@ type Lead struct {
 	ServiceType string
 	Source      string
 	Notes       []Note
+	History     []LeadStatusChange
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
+	DeletedAt   *time.Time // Set when the lead has been archived
+}
+
+// Archived reports whether the lead has been archived rather than purged
+func (l Lead) Archived() bool {
+	return l.DeletedAt != nil
+}
+
+// LeadStatusChange records a lead moving from one status to another
+type LeadStatusChange struct {
+	HistoryId     int
+	LeadId        int
+	FromStatus    *Status // nil when the lead was first created
+	ToStatus      Status
+	ChangedById   int
+	ChangedByName string
+	ChangedAt     time.Time
+}
+
+// NewLeadStatus is the status every lead starts in
+const NewLeadStatus = "New Lead"
+
+// ClosedStatus is the StatusValue shared by every closed status, they differ by outcome
+const ClosedStatus = "Closed"
+
+// Outcomes a closed lead can have, stored in Status.ClosedStatusValue
+const (
+	OutcomeWon           = "Won"
+	OutcomeLost          = "Lost"
+	OutcomeStillFighting = "Still Fighting"
+)
+
+// WinRateReport counts the leads currently closed with each outcome
+type WinRateReport struct {
+	Won           int
+	Lost          int
+	StillFighting int
+}
+
+// WinRate is the percentage of decided leads (won or lost) that were won
+func (r WinRateReport) WinRate() float64 {
+	decided := r.Won + r.Lost
+	if decided == 0 {
+		return 0
+	}
+	return float64(r.Won) * 100 / float64(decided)
+}
+
+// Label gives the name shown for the status, including the outcome for closed statuses
+func (s Status) Label() string {
+	if s.IsClosed && s.ClosedStatusValue != "" {
+		return s.StatusValue + " - " + s.ClosedStatusValue
+	}
+	return s.StatusValue
 }
@@ -0,0 +1,159 @@
+package model
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Currency is an ISO 4217 currency code. Every currency we bill in has two decimal places.
+type Currency string
+
+const AUD Currency = "AUD"
+
+// DefaultCurrency is used for new invoices
+const DefaultCurrency = AUD
+
+// currencySymbols are printed in front of amounts, currencies without one are shown with their code instead
+var currencySymbols = map[Currency]string{
+	AUD:   "$",
+	"NZD": "NZ$",
+	"USD": "US$",
+}
+
+// Money is an exact amount held in cents (the currency's minor unit), so $12.50 is {1250, AUD}.
+// Amounts are never held as floats, they are parsed from and written to the database as decimal text.
+type Money struct {
+	Cents    int64
+	Currency Currency
+}
+
+// NewMoney builds an amount from cents
+func NewMoney(cents int64, currency Currency) Money {
+	return Money{Cents: cents, Currency: currency}
+}
+
+// ParseMoney reads an amount such as "12.5", "12.50", "$1,234.56" or "-3". More than two decimal places is an error rather than being rounded.
+func ParseMoney(s string, currency Currency) (Money, error) {
+	cents, err := parseFixed(strings.TrimPrefix(strings.ReplaceAll(strings.TrimSpace(s), ",", ""), currencySymbols[currency]), 2)
+	if err != nil {
+		return Money{}, fmt.Errorf("invalid amount %q", s)
+	}
+	return Money{Cents: cents, Currency: currency}, nil
+}
+
+// Add returns m + other, the result keeps m's currency
+func (m Money) Add(other Money) Money {
+	return Money{Cents: m.Cents + other.Cents, Currency: m.currency(other)}
+}
+
+// Sub returns m - other
+func (m Money) Sub(other Money) Money {
+	return Money{Cents: m.Cents - other.Cents, Currency: m.currency(other)}
+}
+
+// Times multiplies the amount by a whole quantity
+func (m Money) Times(quantity int64) Money {
+	return Money{Cents: m.Cents * quantity, Currency: m.Currency}
+}
+
+func (m Money) currency(other Money) Currency {
+	if m.Currency == "" {
+		return other.Currency
+	}
+	return m.Currency
+}
+
+func (m Money) IsZero() bool {
+	return m.Cents == 0
+}
+
+// Decimal is the plain amount with two decimal places, e.g. "1234.50", as stored in the database and used in form fields
+func (m Money) Decimal() string {
+	sign := ""
+	cents := m.Cents
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// String formats the amount for display, e.g. "$1,234.50" or "-$3.00". Currencies without a symbol are shown as "EUR 1,234.50".
+func (m Money) String() string {
+	sign := ""
+	cents := m.Cents
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	dollars := strconv.FormatInt(cents/100, 10)
+	for i := len(dollars) - 3; i > 0; i -= 3 {
+		dollars = dollars[:i] + "," + dollars[i:]
+	}
+	amount := fmt.Sprintf("%s.%02d", dollars, cents%100)
+
+	currency := m.Currency
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	if symbol, ok := currencySymbols[currency]; ok {
+		return sign + symbol + amount
+	}
+	return sign + string(currency) + " " + amount
+}
+
+// Scan reads a NUMERIC column into the cents, the currency is left for the caller to fill in from the invoice
+func (m *Money) Scan(src any) error {
+	var s string
+	switch v := src.(type) {
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	case int64:
+		m.Cents = v * 100
+		return nil
+	case nil:
+		m.Cents = 0
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+	cents, err := parseFixed(s, 2)
+	if err != nil {
+		return fmt.Errorf("cannot scan %q into Money: %v", s, err)
+	}
+	m.Cents = cents
+	return nil
+}
+
+// Value writes the amount as decimal text so NUMERIC columns get it exactly
+func (m Money) Value() (driver.Value, error) {
+	return m.Decimal(), nil
+}
+
+// parseFixed reads a decimal number with at most places decimal places as an integer scaled by 10^places
+func parseFixed(s string, places int) (int64, error) {
+	negative := strings.HasPrefix(s, "-")
+	whole, fraction, _ := strings.Cut(strings.TrimPrefix(s, "-"), ".")
+	// NUMERIC columns can come back with extra zeros, e.g. 12.5000
+	if len(fraction) > places {
+		fraction = strings.TrimRight(fraction, "0")
+	}
+	if whole+fraction == "" || len(fraction) > places || strings.ContainsAny(whole+fraction, "+-") {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	fraction += strings.Repeat("0", places-len(fraction))
+
+	value, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if negative {
+		value = -value
+	}
+	return value, nil
+}
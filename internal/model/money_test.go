@@ -0,0 +1,85 @@
+package model
+
+import "testing"
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in    string
+		cents int64
+	}{
+		{"12.5", 1250},
+		{"12.50", 1250},
+		{"12", 1200},
+		{".5", 50},
+		{"0.01", 1},
+		{" $1,234.56 ", 123456},
+		{"-3", -300}, // Allowed here, the forms turn negative prices and payments away
+		{"12.500", 1250},
+	}
+	for _, test := range tests {
+		money, err := ParseMoney(test.in, AUD)
+		if err != nil {
+			t.Errorf("ParseMoney(%q): %v", test.in, err)
+			continue
+		}
+		if money.Cents != test.cents || money.Currency != AUD {
+			t.Errorf("ParseMoney(%q) = %d %s, want %d AUD", test.in, money.Cents, money.Currency, test.cents)
+		}
+	}
+}
+
+func TestParseMoneyRejects(t *testing.T) {
+	for _, in := range []string{"12.345", "0.001", "1.005", "", "$", "-", "abc", "--3", "-+3", "+3", "1-2", "12.3.4", "1e3"} {
+		if money, err := ParseMoney(in, AUD); err == nil {
+			t.Errorf("ParseMoney(%q) = %d cents, want an error", in, money.Cents)
+		}
+	}
+}
+
+func TestParseFixed(t *testing.T) {
+	tests := []struct {
+		in     string
+		places int
+		value  int64
+		ok     bool
+	}{
+		{"10", 2, 1000, true},
+		{"12.5000", 2, 1250, true}, // NUMERIC columns come back padded
+		{"-0.5", 2, -50, true},
+		{"1.234", 3, 1234, true},
+		{"1.234", 2, 0, false},
+		{"1.2345", 3, 0, false},
+		{"-1.001", 2, 0, false},
+		{"", 2, 0, false},
+		{"-", 2, 0, false},
+	}
+	for _, test := range tests {
+		value, err := parseFixed(test.in, test.places)
+		if ok := err == nil; ok != test.ok || value != test.value {
+			t.Errorf("parseFixed(%q, %d) = %d, %v, want %d, ok %v", test.in, test.places, value, err, test.value, test.ok)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{NewMoney(0, AUD), "$0.00"},
+		{NewMoney(5, AUD), "$0.05"},
+		{NewMoney(123450, AUD), "$1,234.50"},
+		{NewMoney(-300, AUD), "-$3.00"},
+		{NewMoney(100000000, "NZD"), "NZ$1,000,000.00"},
+		{NewMoney(123450, "EUR"), "EUR 1,234.50"},
+		{NewMoney(-1, ""), "-$0.01"},
+	}
+	for _, test := range tests {
+		if got := test.money.String(); got != test.want {
+			t.Errorf("%d %s String() = %q, want %q", test.money.Cents, test.money.Currency, got, test.want)
+		}
+		if got, err := ParseMoney(test.money.Decimal(), test.money.Currency); err != nil || got.Cents != test.money.Cents {
+			t.Errorf("ParseMoney(%q) = %d, %v, want %d", test.money.Decimal(), got.Cents, err, test.money.Cents)
+		}
+	}
+}
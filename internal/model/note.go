@@ -14,13 +14,32 @@ const (
 	OtherNote               NoteCategory = "Other"
 )
 
+// NoteCategories lists every category in the order they are offered on the page
+var NoteCategories = []NoteCategory{
+	InteractionNote,
+	FeedbackNote,
+	InternalObservationNote,
+	FollowUpNote,
+	OtherNote,
+}
+
+// Valid reports whether c is one of the known note categories
+func (c NoteCategory) Valid() bool {
+	for _, category := range NoteCategories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
 type Note struct {
 	NoteId     int
 	CustomerId *int
 	LeadId     *int
 	Category   NoteCategory
-	AuthorId   int    // this will be implemented after the main func of crm is done and user auth is added
-	AuthorName string // this will be implemented after the main func of crm is done and user auth is added
+	AuthorId   int    // the user who wrote the note
+	AuthorName string // kept with the note so it still shows if the user is removed
 	Content    string
 	CreatedAt  time.Time
 	UpdatedAt  time.Time
@@ -0,0 +1,45 @@
+package model
+
+import "time"
+
+// Payment is money received against an invoice, an invoice can be paid off over several payments.
+// Refunds are negative payments made by a credit note.
+type Payment struct {
+	PaymentId        int
+	InvoiceId        string
+	Amount           Money
+	PaidOn           time.Time
+	Method           PaymentMethod
+	Reference        string
+	CreditNoteId     string // The credit note that refunded the amount, empty for money received
+	CreditNoteNumber string
+	RecordedById     int
+	RecordedByName   string
+	CreatedAt        time.Time
+}
+
+// IsRefund reports whether the payment paid money back to the customer
+func (p Payment) IsRefund() bool {
+	return p.CreditNoteId != ""
+}
+
+type PaymentMethod string
+
+const (
+	BankTransfer PaymentMethod = "bank transfer"
+	Card         PaymentMethod = "card"
+	Cash         PaymentMethod = "cash"
+	Cheque       PaymentMethod = "cheque"
+	OtherMethod  PaymentMethod = "other"
+)
+
+var PaymentMethods = []PaymentMethod{BankTransfer, Card, Cash, Cheque, OtherMethod}
+
+func (m PaymentMethod) Valid() bool {
+	for _, method := range PaymentMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
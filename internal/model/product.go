@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// Product is something we sell, picking it for an invoice line fills in the description, price and tax code
+type Product struct {
+	ProductId   int
+	Name        string
+	Description string
+	UnitPrice   Money // Excluding tax
+	TaxCode     string
+	Active      bool
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
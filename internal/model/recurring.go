@@ -0,0 +1,133 @@
+package model
+
+import (
+	"strconv"
+	"time"
+)
+
+// RecurringInvoice is a schedule that raises the same invoice for a customer every period, such as a
+// monthly support plan or yearly hosting. Each invoice copies the customer's details as they are when it is raised.
+type RecurringInvoice struct {
+	ScheduleId       string
+	CustomerId       string
+	CustomerName     string
+	CompanyName      string
+	BillingAddressId int // 0 bills the customer's default billing address
+	Description      string
+	Unit             RecurrenceUnit
+	Every            int        // How many units each period lasts
+	StartDate        time.Time  // Later periods start on the same day of the week, month or year
+	EndDate          *time.Time // Periods starting after this aren't invoiced, nil carries on until paused
+	NextRun          time.Time  // The start of the next period to invoice
+	DueDays          int        // Each invoice is due this many days after it is raised
+	AutoSend         bool       // Issue and email each invoice, otherwise it is left as a draft to check
+	Active           bool
+	ItemList         []ItemList
+	Currency         Currency
+	TaxRounding      TaxRounding
+	PricesIncludeTax bool
+	TaxSummary       []TaxSummaryLine
+	Subtotal         Money
+	Tax              Money
+	Total            Money
+	CreatedById      int
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+type RecurrenceUnit string
+
+const (
+	Weekly  RecurrenceUnit = "week"
+	Monthly RecurrenceUnit = "month"
+	Yearly  RecurrenceUnit = "year"
+)
+
+var RecurrenceUnits = []RecurrenceUnit{Weekly, Monthly, Yearly}
+
+func (u RecurrenceUnit) Valid() bool {
+	return u == Weekly || u == Monthly || u == Yearly
+}
+
+// PeriodStart gives the start of the nth period, counting from 0 at the start date.
+// Monthly and yearly periods starting on the 31st fall on the last day of shorter months.
+func (r RecurringInvoice) PeriodStart(n int) time.Time {
+	switch r.Unit {
+	case Weekly:
+		return r.StartDate.AddDate(0, 0, 7*r.Every*n)
+	case Yearly:
+		return addMonths(r.StartDate, 12*r.Every*n)
+	default:
+		return addMonths(r.StartDate, r.Every*n)
+	}
+}
+
+// addMonths moves the date on by whole months, keeping the day of the month where the month is long enough
+func addMonths(date time.Time, months int) time.Time {
+	first := time.Date(date.Year(), date.Month()+time.Month(months), 1, 0, 0, 0, 0, date.Location())
+	lastDay := first.AddDate(0, 1, -1).Day()
+	return time.Date(first.Year(), first.Month(), min(date.Day(), lastDay), 0, 0, 0, 0, date.Location())
+}
+
+// NextPeriod gives the start of the first period starting after date
+func (r RecurringInvoice) NextPeriod(date time.Time) time.Time {
+	for n := 0; ; n++ {
+		if start := r.PeriodStart(n); start.After(date) {
+			return start
+		}
+	}
+}
+
+// DuePeriods lists the start of every period from NextRun up to today that hasn't been invoiced,
+// more than one when invoices were missed while the app was down
+func (r RecurringInvoice) DuePeriods(today time.Time) []time.Time {
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, r.NextRun.Location())
+	var due []time.Time
+	for n := 0; ; n++ {
+		start := r.PeriodStart(n)
+		if start.After(today) || (r.EndDate != nil && start.After(*r.EndDate)) {
+			return due
+		}
+		if !start.Before(r.NextRun) {
+			due = append(due, start)
+		}
+	}
+}
+
+// Finished reports whether every period up to the end date has been invoiced
+func (r RecurringInvoice) Finished() bool {
+	return r.EndDate != nil && r.NextRun.After(*r.EndDate)
+}
+
+// Invoice builds the invoice for the period starting on start, with the schedule's lines and tax settings.
+// The customer's details and the status are filled in when it is raised.
+func (r RecurringInvoice) Invoice(start time.Time) Invoice {
+	end := r.NextPeriod(start).AddDate(0, 0, -1)
+	return Invoice{
+		ScheduleId:       r.ScheduleId,
+		PeriodStart:      &start,
+		PeriodEnd:        &end,
+		CustomerId:       r.CustomerId,
+		BillingAddressId: r.BillingAddressId,
+		ItemList:         append([]ItemList(nil), r.ItemList...),
+		Currency:         r.Currency,
+		TaxRounding:      r.TaxRounding,
+		PricesIncludeTax: r.PricesIncludeTax,
+	}
+}
+
+// CalculateTotals works out the lines, tax summary and totals of each invoice the same way as an invoice
+func (r *RecurringInvoice) CalculateTotals() {
+	invoice := Invoice{ItemList: r.ItemList, Currency: r.Currency, TaxRounding: r.TaxRounding, PricesIncludeTax: r.PricesIncludeTax}
+	invoice.CalculateTotals()
+	r.ItemList, r.Currency = invoice.ItemList, invoice.Currency
+	r.TaxSummary, r.Subtotal, r.Tax, r.Total = invoice.TaxSummary, invoice.Subtotal, invoice.Tax, invoice.Total
+}
+
+// Frequency describes how often the schedule invoices, e.g. every 3 months
+func (r RecurringInvoice) Frequency() string {
+	if r.Every == 1 {
+		return "every " + string(r.Unit)
+	}
+	return "every " + strconv.Itoa(r.Every) + " " + string(r.Unit) + "s"
+}
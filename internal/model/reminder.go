@@ -0,0 +1,41 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// ReminderStep is one email in the payment reminder sequence, sent DaysAfterDue days after an unpaid invoice's
+// due date. Steps before the due date have a negative DaysAfterDue.
+type ReminderStep struct {
+	StepId       int
+	Name         string
+	DaysAfterDue int
+	Subject      string // A text/template, executed with the same data as the email
+	Template     string // Which email template in src/templates/email is sent
+	Active       bool
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// When describes when the step is sent, e.g. "3 days before the due date"
+func (s ReminderStep) When() string {
+	switch {
+	case s.DaysAfterDue == 0:
+		return "on the due date"
+	case s.DaysAfterDue == -1:
+		return "1 day before the due date"
+	case s.DaysAfterDue < 0:
+		return fmt.Sprintf("%d days before the due date", -s.DaysAfterDue)
+	case s.DaysAfterDue == 1:
+		return "1 day after the due date"
+	default:
+		return fmt.Sprintf("%d days after the due date", s.DaysAfterDue)
+	}
+}
+
+// DueReminder is a reminder step that an invoice is ready for
+type DueReminder struct {
+	InvoiceId string
+	Step      ReminderStep
+}
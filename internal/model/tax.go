@@ -0,0 +1,116 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TaxRate is a percentage held in hundredths of a percent (basis points), so 10% is 1000 and 12.5% is 1250
+type TaxRate int64
+
+// ParseTaxRate reads a percentage such as "10" or "12.5"
+func ParseTaxRate(s string) (TaxRate, error) {
+	rate, err := parseFixed(strings.TrimSuffix(strings.TrimSpace(s), "%"), 2)
+	if err != nil || rate < 0 || rate > 10000 {
+		return 0, fmt.Errorf("invalid tax rate %q", s)
+	}
+	return TaxRate(rate), nil
+}
+
+// String gives the rate as a percentage without trailing zeros, e.g. "10%" or "12.5%"
+func (r TaxRate) String() string {
+	return r.Percent() + "%"
+}
+
+// Percent is the rate without the % sign, as used in form fields
+func (r TaxRate) Percent() string {
+	percent := fmt.Sprintf("%d.%02d", r/100, r%100)
+	return strings.TrimSuffix(strings.TrimRight(percent, "0"), ".")
+}
+
+// TaxRounding decides where tax is rounded to the cent
+type TaxRounding string
+
+const (
+	// RoundTaxPerLine rounds the tax on every line, the invoice tax is the sum of the rounded lines
+	RoundTaxPerLine TaxRounding = "line"
+	// RoundTaxPerInvoice works the tax out once on the total for each tax code, so the invoice tax can be a cent
+	// away from the sum of the rounded line taxes shown
+	RoundTaxPerInvoice TaxRounding = "invoice"
+)
+
+// DefaultTaxRounding is used for new invoices
+const DefaultTaxRounding = RoundTaxPerLine
+
+// TaxKind is how a supply is treated for GST
+type TaxKind string
+
+const (
+	Taxable    TaxKind = "taxable"
+	GSTFree    TaxKind = "gst-free"
+	InputTaxed TaxKind = "input-taxed"
+)
+
+var TaxKinds = []TaxKind{Taxable, GSTFree, InputTaxed}
+
+func (k TaxKind) Valid() bool {
+	return k == Taxable || k == GSTFree || k == InputTaxed
+}
+
+// TaxCode is a configurable tax treatment such as GST 10%, only taxable codes have a rate
+type TaxCode struct {
+	Code      string
+	Name      string
+	Kind      TaxKind
+	Rate      TaxRate
+	Active    bool // Inactive codes stay on old invoices but can't be picked for new lines
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// TaxOn works out the tax on amount at this code's rate, inclusive amounts already have the tax in them
+func (t TaxCode) TaxOn(amount Money, inclusive bool) Money {
+	return taxOn(amount, t.Rate, inclusive)
+}
+
+// TaxSummaryLine totals an invoice's lines for one tax code
+type TaxSummaryLine struct {
+	Code   string
+	Name   string
+	Rate   TaxRate
+	Net    Money // Excluding tax
+	Tax    Money
+	amount Money // What the tax is worked out on, the net amount or the tax inclusive amount
+}
+
+// includesGST reports whether any line of a tax summary has a rate, so GST applies to the document
+func includesGST(summary []TaxSummaryLine) bool {
+	for _, line := range summary {
+		if line.Rate > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// taxOn works out the tax on amount rounded to the cent, halves are rounded away from zero.
+// When the amount already includes tax the tax is the rate/(100%+rate) share of it, e.g. 1/11th for GST.
+func taxOn(amount Money, rate TaxRate, inclusive bool) Money {
+	numerator, denominator := amount.Cents*int64(rate), int64(10000)
+	if inclusive {
+		denominator += int64(rate)
+	}
+	cents := (abs(numerator) + denominator/2) / denominator
+	if numerator < 0 {
+		cents = -cents
+	}
+	return NewMoney(cents, amount.Currency)
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
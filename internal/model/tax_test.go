@@ -0,0 +1,72 @@
+package model
+
+import "testing"
+
+func TestTaxOn(t *testing.T) {
+	tests := []struct {
+		name      string
+		cents     int64
+		rate      TaxRate
+		inclusive bool
+		tax       int64
+	}{
+		{"below half rounds down", 4, 1000, false, 0},
+		{"half rounds up", 5, 1000, false, 1},
+		{"half rounds up not to even", 25, 1000, false, 3},
+		{"above half rounds up", 16, 1000, false, 2},
+		{"negative half rounds away from zero", -5, 1000, false, -1},
+		{"negative half rounds away from zero not to even", -25, 1000, false, -3},
+		{"negative below half rounds toward zero", -4, 1000, false, 0},
+		{"exclusive", 10000, 1000, false, 1000},
+		{"exclusive fractional rate", 10000, 1250, false, 1250},
+		{"inclusive takes out a eleventh", 11000, 1000, true, 1000},
+		{"inclusive rounds down", 100, 1000, true, 9},
+		{"inclusive rounds up", 1006, 1000, true, 91},
+		{"inclusive negative", -11000, 1000, true, -1000},
+		{"inclusive fractional rate", 11250, 1250, true, 1250},
+		{"zero rate", 12345, 0, false, 0},
+		{"zero rate inclusive", 12345, 0, true, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tax := taxOn(NewMoney(test.cents, AUD), test.rate, test.inclusive)
+			if tax.Cents != test.tax || tax.Currency != AUD {
+				t.Errorf("taxOn(%d, %s, inclusive %v) = %d %s, want %d AUD", test.cents, test.rate, test.inclusive, tax.Cents, tax.Currency, test.tax)
+			}
+		})
+	}
+}
+
+func TestParseTaxRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		rate TaxRate
+		ok   bool
+	}{
+		{"10", 1000, true},
+		{"12.5%", 1250, true},
+		{" 0 ", 0, true},
+		{"100", 10000, true},
+		{"0.01", 1, true},
+		{"-10", 0, false},
+		{"-0.01", 0, false},
+		{"100.01", 0, false},
+		{"10.125", 0, false},
+		{"", 0, false},
+		{"ten", 0, false},
+	}
+	for _, test := range tests {
+		rate, err := ParseTaxRate(test.in)
+		if ok := err == nil; ok != test.ok || rate != test.rate {
+			t.Errorf("ParseTaxRate(%q) = %d, %v, want %d, ok %v", test.in, rate, err, test.rate, test.ok)
+		}
+	}
+}
+
+func TestTaxRateString(t *testing.T) {
+	for rate, want := range map[TaxRate]string{0: "0%", 1000: "10%", 1250: "12.5%", 1: "0.01%", 10000: "100%"} {
+		if got := rate.String(); got != want {
+			t.Errorf("TaxRate(%d).String() = %q, want %q", rate, got, want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package model
+
+import "time"
+
+type User struct {
+	Id           int
+	Email        string
+	Name         string
+	PasswordHash string
+	Role         Role
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+type Role string
+
+const (
+	AdminRole      Role = "admin"
+	SalesRole      Role = "sales"
+	TechnicianRole Role = "technician"
+	BookkeeperRole Role = "bookkeeper"
+)
+
+// Roles lists every role in the order they are offered on the users page
+var Roles = []Role{AdminRole, SalesRole, TechnicianRole, BookkeeperRole}
+
+type Permission string
+
+const (
+	ManageCustomers  Permission = "manage customers"
+	ArchiveCustomers Permission = "archive customers"
+	PurgeCustomers   Permission = "purge customers"
+	ManageLeads      Permission = "manage leads"
+	PurgeLeads       Permission = "purge leads"
+	ManageNotes      Permission = "manage notes"
+	ViewInvoices     Permission = "view invoices"
+	IssueInvoices    Permission = "issue invoices"
+	ManageEstimates  Permission = "manage estimates"
+	ManageProducts   Permission = "manage products"
+	ManageTaxCodes   Permission = "manage tax codes"
+	ManageReminders  Permission = "manage reminders"
+	ManageUsers      Permission = "manage users"
+)
+
+// rolePermissions is the permission matrix, admins can do everything
+var rolePermissions = map[Role][]Permission{
+	SalesRole:      {ManageCustomers, ArchiveCustomers, ManageLeads, ManageNotes, ViewInvoices, ManageEstimates, ManageProducts},
+	TechnicianRole: {ManageNotes},
+	BookkeeperRole: {ManageCustomers, ManageNotes, ViewInvoices, IssueInvoices, ManageEstimates, ManageProducts, ManageTaxCodes, ManageReminders},
+}
+
+// Valid reports whether r is one of the known roles
+func (r Role) Valid() bool {
+	for _, role := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Can reports whether the role has been granted the permission
+func (r Role) Can(permission Permission) bool {
+	if r == AdminRole {
+		return true
+	}
+	for _, p := range rolePermissions[r] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
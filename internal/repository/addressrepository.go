@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/MrAjMann/crm/internal/model"
+)
+
+type AddressRepository struct {
+	db *sql.DB
+}
+
+func NewAddressRepository(db *sql.DB) *AddressRepository {
+	return &AddressRepository{db: db}
+}
+
+const addressColumns = "AddressId, CustomerId, Type, IsDefault, UnitNumber, StreetNumber, StreetName, City, State, Postcode, CreatedAt, UpdatedAt"
+
+func scanAddress(row interface{ Scan(...any) error }) (model.CustomerAddress, error) {
+	var a model.CustomerAddress
+	err := row.Scan(
+		&a.AddressId,
+		&a.CustomerId,
+		&a.Type,
+		&a.IsDefault,
+		&a.UnitNumber,
+		&a.StreetNumber,
+		&a.StreetName,
+		&a.City,
+		&a.State,
+		&a.Postcode,
+		&a.CreatedAt,
+		&a.UpdatedAt,
+	)
+	return a, err
+}
+
+// AddAddress saves a new address for a customer. The first address of each type becomes the default.
+func (repo *AddressRepository) AddAddress(address model.CustomerAddress) (model.CustomerAddress, error) {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return address, fmt.Errorf("error starting address transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if !address.IsDefault {
+		err = tx.QueryRow("SELECT NOT EXISTS (SELECT 1 FROM customer_addresses WHERE CustomerId = $1 AND Type = $2)",
+			address.CustomerId, address.Type).Scan(&address.IsDefault)
+		if err != nil {
+			return address, fmt.Errorf("error checking existing addresses: %v", err)
+		}
+	}
+	if err := clearDefaultAddress(tx, address); err != nil {
+		return address, err
+	}
+
+	row := tx.QueryRow(`INSERT INTO customer_addresses (CustomerId, Type, IsDefault, UnitNumber, StreetNumber, StreetName, City, State, Postcode)
+						VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+						RETURNING `+addressColumns,
+		address.CustomerId, address.Type, address.IsDefault,
+		address.UnitNumber, address.StreetNumber, address.StreetName, address.City, address.State, address.Postcode)
+	added, err := scanAddress(row)
+	if err != nil {
+		return added, fmt.Errorf("error inserting address: %v", err)
+	}
+	return added, tx.Commit()
+}
+
+func (repo *AddressRepository) GetAddressById(id string) (model.CustomerAddress, error) {
+	return scanAddress(repo.db.QueryRow("SELECT "+addressColumns+" FROM customer_addresses WHERE AddressId = $1", id))
+}
+
+// GetAddressesForCustomer lists billing addresses before service addresses, defaults first
+func (repo *AddressRepository) GetAddressesForCustomer(customerId int, addressType model.AddressType) ([]model.CustomerAddress, error) {
+	rows, err := repo.db.Query("SELECT "+addressColumns+` FROM customer_addresses
+						WHERE CustomerId = $1 AND ($2::text = '' OR Type = $2)
+						ORDER BY Type, IsDefault DESC, AddressId`, customerId, addressType)
+	if err != nil {
+		return nil, fmt.Errorf("error querying addresses for customer %d: %v", customerId, err)
+	}
+	defer rows.Close()
+
+	var addresses []model.CustomerAddress
+	for rows.Next() {
+		address, err := scanAddress(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning address: %v", err)
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses, rows.Err()
+}
+
+// UpdateAddress changes an address in place. Invoices already sent keep their own copy.
+func (repo *AddressRepository) UpdateAddress(address model.CustomerAddress) (model.CustomerAddress, error) {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return address, fmt.Errorf("error starting address transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow("SELECT CustomerId FROM customer_addresses WHERE AddressId = $1 FOR UPDATE", address.AddressId).Scan(&address.CustomerId)
+	if err != nil {
+		return address, err
+	}
+	if err := clearDefaultAddress(tx, address); err != nil {
+		return address, err
+	}
+
+	row := tx.QueryRow(`UPDATE customer_addresses
+						SET Type = $1, IsDefault = $2, UnitNumber = $3, StreetNumber = $4, StreetName = $5, City = $6, State = $7, Postcode = $8,
+							UpdatedAt = CURRENT_TIMESTAMP
+						WHERE AddressId = $9
+						RETURNING `+addressColumns,
+		address.Type, address.IsDefault,
+		address.UnitNumber, address.StreetNumber, address.StreetName, address.City, address.State, address.Postcode,
+		address.AddressId)
+	updated, err := scanAddress(row)
+	if err != nil {
+		return updated, fmt.Errorf("error updating address %d: %v", address.AddressId, err)
+	}
+	return updated, tx.Commit()
+}
+
+func (repo *AddressRepository) DeleteAddressById(id string) error {
+	result, err := repo.db.Exec("DELETE FROM customer_addresses WHERE AddressId = $1", id)
+	if err != nil {
+		return fmt.Errorf("error deleting address %s: %v", id, err)
+	}
+	if count, err := result.RowsAffected(); err == nil && count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// clearDefaultAddress unsets the customer's current default of the same type when address is becoming the default
+func clearDefaultAddress(tx *sql.Tx, address model.CustomerAddress) error {
+	if !address.IsDefault {
+		return nil
+	}
+	_, err := tx.Exec("UPDATE customer_addresses SET IsDefault = FALSE WHERE CustomerId = $1 AND Type = $2 AND IsDefault AND AddressId <> $3",
+		address.CustomerId, address.Type, address.AddressId)
+	if err != nil {
+		return fmt.Errorf("error clearing default address: %v", err)
+	}
+	return nil
+}
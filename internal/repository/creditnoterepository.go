@@ -0,0 +1,236 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/MrAjMann/crm/internal/model"
+	"github.com/lib/pq"
+)
+
+type CreditNoteRepository struct {
+	db        *sql.DB
+	numbering InvoiceNumbering
+}
+
+func NewCreditNoteRepository(db *sql.DB, numbering InvoiceNumbering) *CreditNoteRepository {
+	return &CreditNoteRepository{db: db, numbering: numbering}
+}
+
+var (
+	ErrInvoiceNotCreditable  = errors.New("credit notes can only be raised against issued invoices")
+	ErrNothingToCredit       = errors.New("choose at least one line to credit")
+	ErrOverCredit            = errors.New("a line can't be credited more than the quantity left uncredited on the invoice")
+	ErrCreditMoreThanBalance = errors.New("the credit is more than the balance due, refund it instead")
+	ErrRefundMoreThanPaid    = errors.New("the refund is more than has been paid on the invoice")
+)
+
+// AddCreditNote raises a credit note against an issued invoice and settles it, either taking it off the balance due
+// or refunding it with a negative payment. quantities maps invoice line ItemIds to how much of each to credit,
+// nil credits everything not already credited. The invoice is locked while this is checked, so two credit
+// notes can't both credit the same line.
+func (repo *CreditNoteRepository) AddCreditNote(note model.CreditNote, quantities map[int]int32) (model.CreditNote, error) {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return note, fmt.Errorf("error starting credit note transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	invoice, err := getInvoice(tx, note.InvoiceId, "FOR UPDATE")
+	if err != nil {
+		return note, err
+	}
+	if invoice.Status != model.IssuedInvoice {
+		return note, ErrInvoiceNotCreditable
+	}
+
+	remaining := invoice.Uncredited()
+	for itemId, quantity := range quantities {
+		if quantity < 0 || quantity > remaining[itemId] {
+			return note, ErrOverCredit
+		}
+	}
+
+	note.CopyInvoice(invoice)
+	note.ItemList = nil
+	for _, item := range invoice.ItemList {
+		quantity := remaining[item.ItemId]
+		if quantities != nil {
+			quantity = quantities[item.ItemId]
+		}
+		if quantity > 0 {
+			note.Credit(item, quantity)
+		}
+	}
+	if len(note.ItemList) == 0 {
+		return note, ErrNothingToCredit
+	}
+	note.CalculateTotals()
+
+	switch note.Settlement {
+	case model.CreditBalance:
+		if note.Total.Cents > invoice.BalanceDue.Cents {
+			return note, ErrCreditMoreThanBalance
+		}
+	case model.CreditRefund:
+		if note.Total.Cents > invoice.AmountPaid.Cents {
+			return note, ErrRefundMoreThanPaid
+		}
+	default:
+		return note, fmt.Errorf("unknown credit note settlement %q", note.Settlement)
+	}
+
+	// Numbered once the amount checks have passed, the counter row stays locked until commit and a rollback hands the number back
+	note.CreditNoteNumber, err = repo.numbering.nextNumber(tx, time.Now())
+	if err != nil {
+		return note, err
+	}
+
+	err = tx.QueryRow(`INSERT INTO credit_notes (CreditNoteNumber, NumberSeries, InvoiceId, Reason, Settlement, CreatedById)
+						VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0))
+						RETURNING CreditNoteId, CreditDate, CreatedAt`,
+		note.CreditNoteNumber, repo.numbering.Series, note.InvoiceId, note.Reason, note.Settlement, note.CreatedById,
+	).Scan(&note.CreditNoteId, &note.CreditDate, &note.CreatedAt)
+	if err != nil {
+		return note, fmt.Errorf("error inserting credit note for invoice %s: %v", note.InvoiceId, err)
+	}
+
+	for _, item := range note.ItemList {
+		_, err = tx.Exec(
+			`INSERT INTO credit_note_items (CreditNoteId, InvoiceItemId, Item, Quantity, ProductId, UnitPrice, TaxCode, TaxName, TaxRate, Subtotal, Tax, Total)
+			VALUES ($1, $2, $3, $4, NULLIF($5, 0), $6, NULLIF($7, ''), $8, $9, $10, $11, $12)`,
+			note.CreditNoteId, item.InvoiceItemId, item.Item, item.Quantity, item.ProductId, item.UnitPrice, item.TaxCode, item.TaxName, item.TaxRate, item.Subtotal, item.Tax, item.Total,
+		)
+		if err != nil {
+			return note, fmt.Errorf("error inserting credit note item %q: %v", item.Item, err)
+		}
+	}
+
+	if note.Settlement == model.CreditRefund {
+		refund := model.Payment{
+			InvoiceId:        note.InvoiceId,
+			Amount:           model.NewMoney(0, note.Currency).Sub(note.Total),
+			PaidOn:           note.CreditDate,
+			Method:           note.RefundMethod,
+			Reference:        note.CreditNoteNumber,
+			CreditNoteId:     note.CreditNoteId,
+			CreditNoteNumber: note.CreditNoteNumber,
+			RecordedById:     note.CreatedById,
+		}
+		err = tx.QueryRow(`INSERT INTO payments (InvoiceId, Amount, PaidOn, Method, Reference, CreditNoteId, RecordedById)
+							VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, 0))
+							RETURNING PaymentId, CreatedAt`,
+			refund.InvoiceId, refund.Amount, refund.PaidOn, refund.Method, refund.Reference, refund.CreditNoteId, refund.RecordedById,
+		).Scan(&refund.PaymentId, &refund.CreatedAt)
+		if err != nil {
+			return note, fmt.Errorf("error inserting refund for credit note %s: %v", note.CreditNoteNumber, err)
+		}
+		invoice.Payments = append(invoice.Payments, refund)
+	}
+
+	invoice.CreditNotes = append(invoice.CreditNotes, note)
+	if err := updatePaymentStatus(tx, &invoice, note.CreatedById, "Credit note "+note.CreditNoteNumber); err != nil {
+		return note, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return note, fmt.Errorf("error committing credit note: %v", err)
+	}
+	return note, nil
+}
+
+// GetCreditNoteById fetches a credit note with its lines, along with the invoice it credits
+func (repo *CreditNoteRepository) GetCreditNoteById(id string) (model.CreditNote, model.Invoice, error) {
+	var invoiceId string
+	if err := repo.db.QueryRow("SELECT InvoiceId FROM credit_notes WHERE CreditNoteId = $1", id).Scan(&invoiceId); err != nil {
+		return model.CreditNote{}, model.Invoice{}, err
+	}
+
+	invoice, err := getInvoice(repo.db, invoiceId, "")
+	if err != nil {
+		return model.CreditNote{}, invoice, err
+	}
+	for _, note := range invoice.CreditNotes {
+		if note.CreditNoteId == id {
+			return note, invoice, nil
+		}
+	}
+	return model.CreditNote{}, invoice, sql.ErrNoRows
+}
+
+// getCreditNotes loads the credit notes for the given invoices with their lines, oldest first and keyed by InvoiceId.
+// Only what is stored on the credit note is filled in, the rest comes from the invoice.
+func getCreditNotes(q queryer, invoiceIds []string) (map[string][]model.CreditNote, error) {
+	creditNotes := make(map[string][]model.CreditNote)
+	if len(invoiceIds) == 0 {
+		return creditNotes, nil
+	}
+
+	rows, err := q.Query(`SELECT c.CreditNoteId, c.CreditNoteNumber, c.InvoiceId, c.CreditDate, c.Reason, c.Settlement, COALESCE(p.Method, ''),
+							COALESCE(c.CreatedById, 0), c.CreatedAt
+						FROM credit_notes c
+						LEFT JOIN payments p ON p.CreditNoteId = c.CreditNoteId
+						WHERE c.InvoiceId = ANY($1)
+						ORDER BY c.CreditNoteId`, pq.Array(invoiceIds))
+	if err != nil {
+		return nil, fmt.Errorf("error querying credit notes: %v", err)
+	}
+	defer rows.Close()
+
+	var notes []model.CreditNote
+	var noteIds []string
+	for rows.Next() {
+		var c model.CreditNote
+		if err := rows.Scan(&c.CreditNoteId, &c.CreditNoteNumber, &c.InvoiceId, &c.CreditDate, &c.Reason, &c.Settlement, &c.RefundMethod,
+			&c.CreatedById, &c.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning credit note: %v", err)
+		}
+		notes = append(notes, c)
+		noteIds = append(noteIds, c.CreditNoteId)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating credit note rows: %v", err)
+	}
+	if len(notes) == 0 {
+		return creditNotes, nil
+	}
+
+	items, err := getCreditNoteItems(q, noteIds)
+	if err != nil {
+		return nil, err
+	}
+	for _, note := range notes {
+		note.ItemList = items[note.CreditNoteId]
+		creditNotes[note.InvoiceId] = append(creditNotes[note.InvoiceId], note)
+	}
+	return creditNotes, nil
+}
+
+// getCreditNoteItems loads the lines for the given credit notes, keyed by CreditNoteId
+func getCreditNoteItems(q queryer, creditNoteIds []string) (map[string][]model.ItemList, error) {
+	items := make(map[string][]model.ItemList)
+
+	rows, err := q.Query(`SELECT ItemId, CreditNoteId, InvoiceItemId, Item, Quantity, COALESCE(ProductId, 0), UnitPrice, COALESCE(TaxCode, ''), TaxName, TaxRate, Subtotal, Tax, Total
+						FROM credit_note_items
+						WHERE CreditNoteId = ANY($1)
+						ORDER BY ItemId`, pq.Array(creditNoteIds))
+	if err != nil {
+		return nil, fmt.Errorf("error querying credit note items: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item model.ItemList
+		var creditNoteId string
+		if err := rows.Scan(&item.ItemId, &creditNoteId, &item.InvoiceItemId, &item.Item, &item.Quantity, &item.ProductId, &item.UnitPrice, &item.TaxCode, &item.TaxName, &item.TaxRate, &item.Subtotal, &item.Tax, &item.Total); err != nil {
+			return nil, fmt.Errorf("error scanning credit note item: %v", err)
+		}
+		items[creditNoteId] = append(items[creditNoteId], item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating credit note item rows: %v", err)
+	}
+	return items, nil
+}
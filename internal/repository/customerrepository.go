@@ -2,6 +2,7 @@ package repository
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
@@ -17,9 +18,9 @@ func NewCustomerRepository(db *sql.DB) *CustomerRepository {
 	return &CustomerRepository{db: db}
 }
 
-// Example function to fetch all customers
+// GetAllCustomers fetches every customer that hasn't been archived
 func (repo *CustomerRepository) GetAllCustomers() ([]model.Customer, error) {
-	rows, err := repo.db.Query("SELECT Id, FirstName, LastName, Email ,Phone, CompanyName, Title, Website, Industry FROM customers")
+	rows, err := repo.db.Query("SELECT Id, FirstName, LastName, Email ,Phone, CompanyName, Title, Website, Industry FROM customers WHERE DeletedAt IS NULL")
 	if err != nil {
 		return nil, err
 	}
@@ -52,7 +53,7 @@ func (repo *CustomerRepository) GetCustomerById(id string) (model.Customer, erro
 	println(id)
 	var customer model.Customer
 
-	query := `SELECT Id, FirstName, LastName, Email, Phone, CompanyName, Title, Website, Industry 
+	query := `SELECT Id, FirstName, LastName, Email, Phone, CompanyName, Title, Website, Industry, NoReminders, COALESCE(LeadId, 0), CreatedAt, UpdatedAt, DeletedAt
 						FROM customers
 						WHERE Id = $1`
 
@@ -66,6 +67,11 @@ func (repo *CustomerRepository) GetCustomerById(id string) (model.Customer, erro
 		&customer.Title,
 		&customer.Website,
 		&customer.Industry,
+		&customer.NoReminders,
+		&customer.LeadId,
+		&customer.CreatedAt,
+		&customer.UpdatedAt,
+		&customer.DeletedAt,
 	)
 	if err != nil {
 		return customer, err
@@ -73,13 +79,36 @@ func (repo *CustomerRepository) GetCustomerById(id string) (model.Customer, erro
 	return customer, nil
 }
 
+// UpdateCustomer saves the customer's contact and company details and reminder opt-out, and bumps UpdatedAt.
+// It returns sql.ErrNoRows when there is no customer with that Id.
+func (repo *CustomerRepository) UpdateCustomer(customer model.Customer) (model.Customer, error) {
+	query := `UPDATE customers
+						SET FirstName = $1, LastName = $2, Email = $3, Phone = $4, CompanyName = $5, Title = $6, Website = $7, Industry = $8,
+							NoReminders = $10, UpdatedAt = CURRENT_TIMESTAMP
+						WHERE Id = $9
+						RETURNING COALESCE(LeadId, 0), CreatedAt, UpdatedAt`
+
+	err := repo.db.QueryRow(query,
+		customer.FirstName, customer.LastName, customer.Email, customer.Phone, customer.CompanyName,
+		customer.Title, customer.Website, customer.Industry, customer.Id, customer.NoReminders,
+	).Scan(&customer.LeadId, &customer.CreatedAt, &customer.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return customer, err
+	}
+	if err != nil {
+		return customer, fmt.Errorf("error updating customer %d: %v", customer.Id, err)
+	}
+	return customer, nil
+}
+
 func (repo *CustomerRepository) SearchCustomers(query string) ([]model.Customer, error) {
 	var customers []model.Customer
 	log.Println("Search Customer")
 	// Adjust the SQL query to better handle searches for both first and last names together
 	sqlQuery := `SELECT Id, FirstName, LastName, Email, Phone, CompanyName
                  FROM customers
-                 WHERE CONCAT(FirstName, ' ', LastName) ILIKE $1 OR FirstName ILIKE $1 OR LastName ILIKE $1 OR Email ILIKE $1 OR Phone ILIKE $1 OR CompanyName ILIKE $1`
+                 WHERE DeletedAt IS NULL
+                 AND (CONCAT(FirstName, ' ', LastName) ILIKE $1 OR FirstName ILIKE $1 OR LastName ILIKE $1 OR Email ILIKE $1 OR Phone ILIKE $1 OR CompanyName ILIKE $1)`
 	// This allows for a more flexible search that considers both individual and full names.
 
 	searchQuery := "%" + strings.TrimSpace(query) + "%"
@@ -105,13 +134,46 @@ func (repo *CustomerRepository) SearchCustomers(query string) ([]model.Customer,
 	return customers, nil
 }
 
-func (repo *CustomerRepository) DeleteCustomerById(id string) (model.Customer, error) {
-	println(id)
+// GetArchivedCustomers fetches archived customers, most recently archived first
+func (repo *CustomerRepository) GetArchivedCustomers() ([]model.Customer, error) {
+	rows, err := repo.db.Query(`SELECT Id, FirstName, LastName, Email, Phone, CompanyName, Title, Website, Industry, DeletedAt
+						FROM customers
+						WHERE DeletedAt IS NOT NULL
+						ORDER BY DeletedAt DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying archived customers: %v", err)
+	}
+	defer rows.Close()
+
+	var customers []model.Customer
+	for rows.Next() {
+		var c model.Customer
+		if err := rows.Scan(&c.Id, &c.FirstName, &c.LastName, &c.Email, &c.Phone, &c.CompanyName, &c.Title, &c.Website, &c.Industry, &c.DeletedAt); err != nil {
+			return nil, fmt.Errorf("error scanning archived customer: %v", err)
+		}
+		customers = append(customers, c)
+	}
+	return customers, rows.Err()
+}
+
+// ArchiveCustomerById hides the customer from the customer list and search, keeping everything attached to them.
+// It returns sql.ErrNoRows when there is no active customer with that Id.
+func (repo *CustomerRepository) ArchiveCustomerById(id string) (model.Customer, error) {
+	return repo.setDeletedAt(id, "CURRENT_TIMESTAMP", "DeletedAt IS NULL")
+}
+
+// RestoreCustomerById brings an archived customer back.
+// It returns sql.ErrNoRows when there is no archived customer with that Id.
+func (repo *CustomerRepository) RestoreCustomerById(id string) (model.Customer, error) {
+	return repo.setDeletedAt(id, "NULL", "DeletedAt IS NOT NULL")
+}
+
+func (repo *CustomerRepository) setDeletedAt(id string, deletedAt string, condition string) (model.Customer, error) {
 	var customer model.Customer
 
-	query := `SELECT Id, FirstName, LastName, Email, Phone, CompanyName
-						FROM customers
-						WHERE Id = $1`
+	query := `UPDATE customers SET DeletedAt = ` + deletedAt + `, UpdatedAt = CURRENT_TIMESTAMP
+						WHERE Id = $1 AND ` + condition + `
+						RETURNING Id, FirstName, LastName, Email, Phone, CompanyName, Website, DeletedAt`
 
 	err := repo.db.QueryRow(query, id).Scan(
 		&customer.Id,
@@ -120,18 +182,72 @@ func (repo *CustomerRepository) DeleteCustomerById(id string) (model.Customer, e
 		&customer.Email,
 		&customer.Phone,
 		&customer.CompanyName,
+		&customer.Website,
+		&customer.DeletedAt,
+	)
+	return customer, err
+}
+
+var (
+	ErrCustomerNotArchived         = errors.New("customers have to be archived before they can be purged")
+	ErrCustomerHasFinancialRecords = errors.New("customers with invoices, estimates or recurring invoices can't be purged")
+)
+
+// PurgeCustomerById permanently deletes an archived customer along with their notes and addresses.
+// Customers with any financial records are refused, those records have to be kept.
+func (repo *CustomerRepository) PurgeCustomerById(id string) (model.Customer, error) {
+	var customer model.Customer
+
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return customer, fmt.Errorf("error starting purge transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Locking the customer stops new invoices being raised against them while we check
+	err = tx.QueryRow("SELECT Id, FirstName, LastName, Email, Phone, CompanyName, DeletedAt FROM customers WHERE Id = $1 FOR UPDATE", id).Scan(
+		&customer.Id,
+		&customer.FirstName,
+		&customer.LastName,
+		&customer.Email,
+		&customer.Phone,
+		&customer.CompanyName,
+		&customer.DeletedAt,
 	)
 	if err != nil {
 		return customer, err
 	}
-	// If the customer exists, proceed with deletion.
-	deleteQuery := `DELETE FROM customers WHERE Id = $1`
-	_, err = repo.db.Exec(deleteQuery, id)
+	if !customer.Archived() {
+		return customer, ErrCustomerNotArchived
+	}
+
+	hasRecords, err := hasFinancialRecords(tx, customer.Id)
 	if err != nil {
-		return model.Customer{}, err // Return an error if the delete operation fails
+		return customer, err
+	}
+	if hasRecords {
+		return customer, ErrCustomerHasFinancialRecords
 	}
 
-	// Return the details of the deleted customer for confirmation/logging.
-	return customer, nil
+	// Notes would otherwise be left behind belonging to nobody
+	if _, err := tx.Exec("DELETE FROM notes WHERE CustomerId = $1", customer.Id); err != nil {
+		return customer, fmt.Errorf("error purging notes for customer %d: %v", customer.Id, err)
+	}
+	if _, err := tx.Exec("DELETE FROM customers WHERE Id = $1", customer.Id); err != nil {
+		return customer, fmt.Errorf("error purging customer %d: %v", customer.Id, err)
+	}
+	return customer, tx.Commit()
+}
 
+// hasFinancialRecords reports whether anything that must be kept for the books belongs to the customer.
+// Estimates and recurring invoice schedules would be deleted along with the customer, so they count too.
+func hasFinancialRecords(tx *sql.Tx, customerId int) (bool, error) {
+	var exists bool
+	err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM invoices WHERE CustomerId = $1)
+							OR EXISTS (SELECT 1 FROM estimates WHERE CustomerId = $1)
+							OR EXISTS (SELECT 1 FROM recurring_invoices WHERE CustomerId = $1)`, customerId).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("error checking financial records for customer %d: %v", customerId, err)
+	}
+	return exists, nil
 }
@@ -0,0 +1,278 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/MrAjMann/crm/internal/model"
+	"github.com/lib/pq"
+)
+
+type EstimateRepository struct {
+	db        *sql.DB
+	numbering InvoiceNumbering
+	invoices  *InvoiceRepository // Converted estimates are numbered and saved as invoices
+}
+
+func NewEstimateRepository(db *sql.DB, numbering InvoiceNumbering, invoices *InvoiceRepository) *EstimateRepository {
+	return &EstimateRepository{db: db, numbering: numbering, invoices: invoices}
+}
+
+var (
+	ErrEstimateConverted      = errors.New("the estimate has already been converted into an invoice")
+	ErrEstimateNotConvertible = errors.New("declined and expired estimates can't be converted into an invoice")
+	ErrEstimateStatusChange   = errors.New("the estimate can't be moved to that status")
+)
+
+// The invoice join only ever finds one row, an estimate has at most one invoice that hasn't been voided
+const estimateQuery = `SELECT e.EstimateId, e.EstimateNumber, e.EstimateDate, e.ValidUntil, e.CustomerId, e.CustomerName, e.CompanyName, e.CustomerPhone, e.CustomerEmail,
+							e.Status, e.Currency, e.TaxRounding, e.PricesIncludeTax, COALESCE(e.BillingAddressId, 0), e.AddressUnitNumber, e.AddressStreetNumber,
+							e.AddressStreetName, e.AddressCity, e.AddressState, e.AddressPostcode, COALESCE(i.InvoiceId::text, ''), COALESCE(i.InvoiceNumber, ''),
+							COALESCE(e.CreatedById, 0), e.CreatedAt, e.UpdatedAt
+						FROM estimates e
+						LEFT JOIN invoices i ON i.EstimateId = e.EstimateId AND i.Status <> 'void'`
+
+func scanEstimate(row interface{ Scan(...any) error }) (model.Estimate, error) {
+	var e model.Estimate
+	err := row.Scan(&e.EstimateId, &e.EstimateNumber, &e.EstimateDate, &e.ValidUntil, &e.CustomerId, &e.CustomerName, &e.CompanyName, &e.CustomerPhone, &e.CustomerEmail,
+		&e.Status, &e.Currency, &e.TaxRounding, &e.PricesIncludeTax, &e.BillingAddressId, &e.CustomerAddress.UnitNumber, &e.CustomerAddress.StreetNumber,
+		&e.CustomerAddress.StreetName, &e.CustomerAddress.City, &e.CustomerAddress.State, &e.CustomerAddress.Postcode, &e.InvoiceId, &e.InvoiceNumber,
+		&e.CreatedById, &e.CreatedAt, &e.UpdatedAt)
+	return e, err
+}
+
+// GetAllEstimates lists every estimate with its line items and totals, oldest first
+func (repo *EstimateRepository) GetAllEstimates() ([]model.Estimate, error) {
+	rows, err := repo.db.Query(estimateQuery + " ORDER BY e.EstimateId")
+	if err != nil {
+		return nil, fmt.Errorf("error querying estimates: %v", err)
+	}
+	defer rows.Close()
+
+	var estimates []model.Estimate
+	for rows.Next() {
+		estimate, err := scanEstimate(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning estimate: %v", err)
+		}
+		estimates = append(estimates, estimate)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating estimate rows: %v", err)
+	}
+
+	if err := loadEstimateItems(repo.db, estimates); err != nil {
+		return nil, err
+	}
+	return estimates, nil
+}
+
+// GetEstimateById fetches a single estimate along with its line items
+func (repo *EstimateRepository) GetEstimateById(id string) (model.Estimate, error) {
+	return getEstimate(repo.db, id, "")
+}
+
+// getEstimate fetches an estimate with its line items, lock is appended to the query to lock the row inside a transaction
+func getEstimate(q queryer, id string, lock string) (model.Estimate, error) {
+	estimate, err := scanEstimate(q.QueryRow(estimateQuery+" WHERE e.EstimateId = $1 "+lock, id))
+	if err != nil {
+		return estimate, err
+	}
+
+	estimates := []model.Estimate{estimate}
+	err = loadEstimateItems(q, estimates)
+	return estimates[0], err
+}
+
+// loadEstimateItems fills in the line items and works out the totals
+func loadEstimateItems(q queryer, estimates []model.Estimate) error {
+	if len(estimates) == 0 {
+		return nil
+	}
+	estimateIds := make([]string, 0, len(estimates))
+	for _, estimate := range estimates {
+		estimateIds = append(estimateIds, estimate.EstimateId)
+	}
+
+	rows, err := q.Query(`SELECT ItemId, EstimateId, Item, Quantity, COALESCE(ProductId, 0), UnitPrice, COALESCE(TaxCode, ''), TaxName, TaxRate, Subtotal, Tax, Total
+						FROM estimate_items
+						WHERE EstimateId = ANY($1)
+						ORDER BY ItemId`, pq.Array(estimateIds))
+	if err != nil {
+		return fmt.Errorf("error querying estimate items: %v", err)
+	}
+	defer rows.Close()
+
+	items := make(map[string][]model.ItemList)
+	for rows.Next() {
+		var item model.ItemList
+		var estimateId string
+		if err := rows.Scan(&item.ItemId, &estimateId, &item.Item, &item.Quantity, &item.ProductId, &item.UnitPrice, &item.TaxCode, &item.TaxName, &item.TaxRate, &item.Subtotal, &item.Tax, &item.Total); err != nil {
+			return fmt.Errorf("error scanning estimate item: %v", err)
+		}
+		items[estimateId] = append(items[estimateId], item)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating estimate item rows: %v", err)
+	}
+
+	for idx := range estimates {
+		estimates[idx].ItemList = items[estimates[idx].EstimateId]
+		estimates[idx].CalculateTotals()
+	}
+	return nil
+}
+
+// AddNewEstimate inserts the estimate and its line items in a single transaction, copying the customer details
+// and billing address the same way as an invoice
+func (repo *EstimateRepository) AddNewEstimate(estimate model.Estimate) (string, error) {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("error starting estimate transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	customer, err := getBillTo(tx, estimate.CustomerId, estimate.BillingAddressId)
+	if err != nil {
+		return "", err
+	}
+
+	estimateDate := time.Now()
+	estimateNumber, err := repo.numbering.nextNumber(tx, estimateDate)
+	if err != nil {
+		return "", err
+	}
+
+	var estimateId string
+	err = tx.QueryRow(
+		`INSERT INTO estimates (EstimateNumber, EstimateDate, ValidUntil, CustomerId, CustomerName, CompanyName, CustomerPhone, CustomerEmail, Status, CreatedById,
+			BillingAddressId, AddressUnitNumber, AddressStreetNumber, AddressStreetName, AddressCity, AddressState, AddressPostcode, Currency, TaxRounding, PricesIncludeTax, NumberSeries)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, 0), NULLIF($11, 0), $12, $13, $14, $15, $16, $17, $18, $19, $20, $21) RETURNING EstimateId`,
+		estimateNumber,                // $1
+		estimateDate,                  // $2
+		estimate.ValidUntil,           // $3
+		estimate.CustomerId,           // $4
+		customer.Name,                 // $5
+		customer.Company,              // $6
+		customer.Phone,                // $7
+		customer.Email,                // $8
+		estimate.Status,               // $9
+		estimate.CreatedById,          // $10
+		customer.AddressId,            // $11
+		customer.Address.UnitNumber,   // $12
+		customer.Address.StreetNumber, // $13
+		customer.Address.StreetName,   // $14
+		customer.Address.City,         // $15
+		customer.Address.State,        // $16
+		customer.Address.Postcode,     // $17
+		estimate.Currency,             // $18
+		estimate.TaxRounding,          // $19
+		estimate.PricesIncludeTax,     // $20
+		repo.numbering.Series,         // $21
+	).Scan(&estimateId)
+	if err != nil {
+		return "", fmt.Errorf("error returning EstimateId: %v", err)
+	}
+
+	for _, item := range estimate.ItemList {
+		_, err = tx.Exec(
+			`INSERT INTO estimate_items (EstimateId, Item, Quantity, ProductId, UnitPrice, TaxCode, TaxName, TaxRate, Subtotal, Tax, Total)
+			VALUES ($1, $2, $3, NULLIF($4, 0), $5, NULLIF($6, ''), $7, $8, $9, $10, $11)`,
+			estimateId, item.Item, item.Quantity, item.ProductId, item.UnitPrice, item.TaxCode, item.TaxName, item.TaxRate, item.Subtotal, item.Tax, item.Total,
+		)
+		if err != nil {
+			return "", fmt.Errorf("error inserting estimate item %q: %v", item.Item, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("error committing estimate: %v", err)
+	}
+	return estimateId, nil
+}
+
+// UpdateEstimateStatus moves an estimate to another status, refusing with ErrEstimateStatusChange when
+// the estimate's current status can't move there
+func (repo *EstimateRepository) UpdateEstimateStatus(id string, status model.EstimateStatus) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting estimate status transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var current model.EstimateStatus
+	err = tx.QueryRow("SELECT Status FROM estimates WHERE EstimateId = $1 FOR UPDATE", id).Scan(&current)
+	if err != nil {
+		return err
+	}
+	if !current.CanMoveTo(status) {
+		return ErrEstimateStatusChange
+	}
+
+	_, err = tx.Exec("UPDATE estimates SET Status = $1, UpdatedAt = CURRENT_TIMESTAMP WHERE EstimateId = $2", status, id)
+	if err != nil {
+		return fmt.Errorf("error updating status of estimate %s: %v", id, err)
+	}
+	return tx.Commit()
+}
+
+// ConvertEstimate raises an issued invoice from the estimate, copying the customer, address and line items,
+// and marks the estimate accepted. It returns the new invoice's id.
+func (repo *EstimateRepository) ConvertEstimate(id string, dueDate time.Time, createdById int) (string, error) {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("error starting estimate conversion transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	estimate, err := getEstimate(tx, id, "FOR UPDATE OF e")
+	if err != nil {
+		return "", err
+	}
+	if estimate.InvoiceId != "" {
+		return "", ErrEstimateConverted
+	}
+	if !estimate.CanConvert() {
+		return "", ErrEstimateNotConvertible
+	}
+
+	invoice := estimate.Invoice()
+	invoice.DueDate = dueDate
+	invoice.Status = model.IssuedInvoice
+	invoice.PaymentStatus = model.Pending
+	invoice.CreatedById = createdById
+	invoice.CalculateTotals()
+
+	invoiceId, err := repo.invoices.insertInvoice(tx, invoice)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec("UPDATE estimates SET Status = $1, UpdatedAt = CURRENT_TIMESTAMP WHERE EstimateId = $2", model.AcceptedEstimate, id)
+	if err != nil {
+		return "", fmt.Errorf("error accepting estimate %s: %v", id, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("error committing estimate conversion: %v", err)
+	}
+	return invoiceId, nil
+}
+
+// ExpireEstimates marks draft and sent estimates expired once their valid until date has passed.
+// It returns how many were expired.
+func (repo *EstimateRepository) ExpireEstimates() (int, error) {
+	result, err := repo.db.Exec(`UPDATE estimates SET Status = $1, UpdatedAt = CURRENT_TIMESTAMP
+						WHERE Status IN ($2, $3) AND ValidUntil < CURRENT_DATE`,
+		model.ExpiredEstimate, model.DraftEstimate, model.SentEstimate)
+	if err != nil {
+		return 0, fmt.Errorf("error expiring estimates: %v", err)
+	}
+	expired, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error counting expired estimates: %v", err)
+	}
+	return int(expired), nil
+}
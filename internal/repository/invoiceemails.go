@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"fmt"
+
+	"github.com/MrAjMann/crm/internal/model"
+)
+
+// LogInvoiceEmail records an attempt at emailing an invoice. Emails without an error are marked sent now.
+func (repo *InvoiceRepository) LogInvoiceEmail(email model.InvoiceEmail) (model.InvoiceEmail, error) {
+	err := repo.db.QueryRow(`INSERT INTO invoice_emails (InvoiceId, Recipient, Subject, MessageId, SentAt, Error, SentById, ReminderStepId)
+						VALUES ($1, $2, $3, $4, CASE WHEN $5 = '' THEN CURRENT_TIMESTAMP END, $5, NULLIF($6, 0), NULLIF($7, 0))
+						RETURNING EmailId, SentAt, CreatedAt`,
+		email.InvoiceId, email.Recipient, email.Subject, email.MessageId, email.Error, email.SentById, email.ReminderStepId,
+	).Scan(&email.EmailId, &email.SentAt, &email.CreatedAt)
+	if err != nil {
+		return email, fmt.Errorf("error logging email for invoice %s: %v", email.InvoiceId, err)
+	}
+	return email, nil
+}
+
+// GetInvoiceEmails lists every attempt at emailing an invoice or a payment reminder for it, newest first
+func (repo *InvoiceRepository) GetInvoiceEmails(invoiceId string) ([]model.InvoiceEmail, error) {
+	rows, err := repo.db.Query(`SELECT e.EmailId, e.InvoiceId, e.Recipient, e.Subject, e.MessageId, e.SentAt, e.Error,
+						COALESCE(e.SentById, 0), COALESCE(u.Name, ''), COALESCE(e.ReminderStepId, 0), COALESCE(s.Name, ''), e.CreatedAt
+						FROM invoice_emails e
+						LEFT JOIN users u ON u.Id = e.SentById
+						LEFT JOIN reminder_steps s ON s.StepId = e.ReminderStepId
+						WHERE e.InvoiceId = $1
+						ORDER BY e.CreatedAt DESC, e.EmailId DESC`, invoiceId)
+	if err != nil {
+		return nil, fmt.Errorf("error querying invoice emails: %v", err)
+	}
+	defer rows.Close()
+
+	var emails []model.InvoiceEmail
+	for rows.Next() {
+		var e model.InvoiceEmail
+		if err := rows.Scan(&e.EmailId, &e.InvoiceId, &e.Recipient, &e.Subject, &e.MessageId, &e.SentAt, &e.Error,
+			&e.SentById, &e.SentByName, &e.ReminderStepId, &e.ReminderName, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning invoice email: %v", err)
+		}
+		emails = append(emails, e)
+	}
+	return emails, rows.Err()
+}
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// InvoiceNumbering describes how invoice numbers are built, e.g. INV0042 or INV2026-00042 (a prefix of "INV-" gives INV-2026-00042)
+type InvoiceNumbering struct {
+	Series      string // Each series counts on its own, so several businesses can share the database
+	Prefix      string
+	IncludeYear bool // Puts the year after the prefix and starts counting from 1 again each year
+	Width       int  // Numbers are zero padded to this many digits, longer numbers are never cut off
+}
+
+// DefaultInvoiceNumbering carries on the INV0001 numbers used before numbering was configurable
+var DefaultInvoiceNumbering = InvoiceNumbering{Series: "default", Prefix: "INV", Width: 4}
+
+// DefaultEstimateNumbering gives estimates their own EST0001 numbers, counted separately from invoices
+var DefaultEstimateNumbering = InvoiceNumbering{Series: "estimate", Prefix: "EST", Width: 4}
+
+// DefaultCreditNoteNumbering gives credit notes their own CN0001 numbers
+var DefaultCreditNoteNumbering = InvoiceNumbering{Series: "credit-note", Prefix: "CN", Width: 4}
+
+// InvoiceNumberingFromEnv reads INVOICE_SERIES, INVOICE_PREFIX, INVOICE_NUMBER_YEAR and INVOICE_NUMBER_WIDTH,
+// falling back to DefaultInvoiceNumbering
+func InvoiceNumberingFromEnv() (InvoiceNumbering, error) {
+	return numberingFromEnv("INVOICE", DefaultInvoiceNumbering)
+}
+
+// EstimateNumberingFromEnv reads the same settings for estimates from ESTIMATE_SERIES, ESTIMATE_PREFIX,
+// ESTIMATE_NUMBER_YEAR and ESTIMATE_NUMBER_WIDTH, falling back to DefaultEstimateNumbering
+func EstimateNumberingFromEnv() (InvoiceNumbering, error) {
+	return numberingFromEnv("ESTIMATE", DefaultEstimateNumbering)
+}
+
+// CreditNoteNumberingFromEnv reads CREDIT_NOTE_SERIES, CREDIT_NOTE_PREFIX, CREDIT_NOTE_NUMBER_YEAR and
+// CREDIT_NOTE_NUMBER_WIDTH, falling back to DefaultCreditNoteNumbering
+func CreditNoteNumberingFromEnv() (InvoiceNumbering, error) {
+	return numberingFromEnv("CREDIT_NOTE", DefaultCreditNoteNumbering)
+}
+
+func numberingFromEnv(prefix string, numbering InvoiceNumbering) (InvoiceNumbering, error) {
+	if series := os.Getenv(prefix + "_SERIES"); series != "" {
+		numbering.Series = series
+	}
+	if p, ok := os.LookupEnv(prefix + "_PREFIX"); ok {
+		numbering.Prefix = p
+	}
+	if year := os.Getenv(prefix + "_NUMBER_YEAR"); year != "" {
+		includeYear, err := strconv.ParseBool(year)
+		if err != nil {
+			return numbering, fmt.Errorf("invalid %s_NUMBER_YEAR %q: %v", prefix, year, err)
+		}
+		numbering.IncludeYear = includeYear
+	}
+	if width := os.Getenv(prefix + "_NUMBER_WIDTH"); width != "" {
+		w, err := strconv.Atoi(width)
+		if err != nil || w < 1 || w > 12 {
+			return numbering, fmt.Errorf("invalid %s_NUMBER_WIDTH %q, it must be between 1 and 12", prefix, width)
+		}
+		numbering.Width = w
+	}
+	return numbering, nil
+}
+
+// Format builds the invoice number for the nth invoice of the year
+func (n InvoiceNumbering) Format(year int, number int64) string {
+	if n.IncludeYear {
+		return fmt.Sprintf("%s%d-%0*d", n.Prefix, year, n.Width, number)
+	}
+	return fmt.Sprintf("%s%0*d", n.Prefix, n.Width, number)
+}
+
+// nextNumber takes the next number in the series for an invoice, estimate or credit note dated date.
+// The counter row stays locked until tx finishes, so concurrent invoices wait their turn and
+// a rolled back invoice gives its number back.
+func (n InvoiceNumbering) nextNumber(tx *sql.Tx, date time.Time) (string, error) {
+	year := 0
+	if n.IncludeYear {
+		year = date.Year()
+	}
+
+	var number int64
+	err := tx.QueryRow(`INSERT INTO invoice_number_counters (Series, Year, LastNumber) VALUES ($1, $2, 1)
+						ON CONFLICT (Series, Year) DO UPDATE
+						SET LastNumber = invoice_number_counters.LastNumber + 1, UpdatedAt = CURRENT_TIMESTAMP
+						RETURNING LastNumber`, n.Series, year).Scan(&number)
+	if err != nil {
+		return "", fmt.Errorf("error allocating number in series %s: %v", n.Series, err)
+	}
+	return n.Format(date.Year(), number), nil
+}
@@ -2,94 +2,646 @@ package repository
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
-	"strconv"
+	"strings"
 	"time"
 
 	"github.com/MrAjMann/crm/internal/model"
+	"github.com/lib/pq"
 )
 
 type InvoiceRepository struct {
-	db *sql.DB
+	db        *sql.DB
+	numbering InvoiceNumbering
 }
 
-func NewInvoiceRepository(db *sql.DB) *InvoiceRepository {
-	return &InvoiceRepository{db: db}
+func NewInvoiceRepository(db *sql.DB, numbering InvoiceNumbering) *InvoiceRepository {
+	return &InvoiceRepository{db: db, numbering: numbering}
 }
 
 func (repo *InvoiceRepository) GetAllInvoices() ([]model.Invoice, error) {
-	rows, err := repo.db.Query("SELECT InvoiceId, InvoiceNumber, InvoiceDate, DueDate, CustomerId, CustomerName, CompanyName, CustomerPhone, CustomerEmail, PaymentStatus FROM invoices")
+	return repo.SearchInvoices(model.InvoiceFilter{})
+}
+
+// SearchInvoices lists the invoices matching every part of the filter, oldest first
+func (repo *InvoiceRepository) SearchInvoices(filter model.InvoiceFilter) ([]model.Invoice, error) {
+	paymentStatus := -1
+	if filter.PaymentStatus != nil {
+		paymentStatus = int(*filter.PaymentStatus)
+	}
+	var from, to sql.NullTime
+	if !filter.From.IsZero() {
+		from = sql.NullTime{Time: filter.From, Valid: true}
+	}
+	if !filter.To.IsZero() {
+		to = sql.NullTime{Time: filter.To, Valid: true}
+	}
 
+	rows, err := repo.db.Query(`SELECT InvoiceId, COALESCE(InvoiceNumber, ''), InvoiceDate, DueDate, CustomerId, CustomerName, CompanyName, CustomerPhone, CustomerEmail, PaymentStatus,
+							Status, VoidedAt, COALESCE(VoidReason, ''), Currency, TaxRounding, PricesIncludeTax, PeriodStart, PeriodEnd
+						FROM invoices
+						WHERE ($1 = '' OR InvoiceNumber ILIKE '%' || $1 || '%' OR CustomerName ILIKE '%' || $1 || '%'
+							OR CompanyName ILIKE '%' || $1 || '%' OR CustomerEmail ILIKE '%' || $1 || '%')
+						AND ($2 = '' OR Status = $2)
+						AND ($3 < 0 OR (CASE WHEN PaymentStatus = 1 AND DueDate < CURRENT_DATE THEN 2 ELSE PaymentStatus END) = $3)
+						AND ($4::date IS NULL OR InvoiceDate >= $4::date)
+						AND ($5::date IS NULL OR InvoiceDate < $5::date + 1)
+						AND ($6 = '' OR CustomerId = NULLIF($6, '')::integer)
+						AND ($7 = '' OR ScheduleId = NULLIF($7, '')::integer)
+						ORDER BY InvoiceId`,
+		strings.TrimSpace(filter.Query), string(filter.Status), paymentStatus, from, to, filter.CustomerId, filter.ScheduleId)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("error querying invoices: %v", err)
 	}
 	defer rows.Close()
 
 	var invoices []model.Invoice
 	for rows.Next() {
 		var i model.Invoice
-		if err := rows.Scan(&i.InvoiceId, &i.InvoiceNumber, &i.InvoiceDate, &i.DueDate, &i.CustomerId, &i.CustomerName, &i.CompanyName, &i.CustomerPhone, &i.CustomerEmail, &i.PaymentStatus); err != nil {
-			return nil, err
+		if err := rows.Scan(&i.InvoiceId, &i.InvoiceNumber, &i.InvoiceDate, &i.DueDate, &i.CustomerId, &i.CustomerName, &i.CompanyName, &i.CustomerPhone, &i.CustomerEmail, &i.PaymentStatus,
+			&i.Status, &i.VoidedAt, &i.VoidReason, &i.Currency, &i.TaxRounding, &i.PricesIncludeTax, &i.PeriodStart, &i.PeriodEnd); err != nil {
+			return nil, fmt.Errorf("error scanning invoice: %v", err)
 		}
 		invoices = append(invoices, i)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating invoice rows: %v", err)
+	}
+
+	if err := loadInvoiceDetails(repo.db, invoices); err != nil {
+		return nil, err
+	}
 	return invoices, nil
+}
 
+// GetInvoiceById fetches a single invoice along with its line items, payments, payment status history and emails
+func (repo *InvoiceRepository) GetInvoiceById(id string) (model.Invoice, error) {
+	invoice, err := getInvoice(repo.db, id, "")
+	if err != nil {
+		return invoice, err
+	}
+	if invoice.History, err = repo.GetPaymentStatusHistory(id); err != nil {
+		return invoice, err
+	}
+	invoice.Emails, err = repo.GetInvoiceEmails(id)
+	return invoice, err
 }
 
+// getInvoice fetches an invoice with anything else it needs, lock is appended to the query to lock the row inside a transaction
+func getInvoice(q queryer, id string, lock string) (model.Invoice, error) {
+	var invoice model.Invoice
+
+	query := `SELECT InvoiceId, COALESCE(InvoiceNumber, ''), InvoiceDate, DueDate, CustomerId, CustomerName, CompanyName, CustomerPhone, CustomerEmail, PaymentStatus,
+							Status, VoidedAt, COALESCE(VoidReason, ''), Currency, TaxRounding, PricesIncludeTax, COALESCE(BillingAddressId, 0), AddressUnitNumber, AddressStreetNumber, AddressStreetName, AddressCity, AddressState, AddressPostcode,
+							COALESCE(EstimateId::text, ''), COALESCE((SELECT e.EstimateNumber FROM estimates e WHERE e.EstimateId = invoices.EstimateId), ''),
+							COALESCE(ScheduleId::text, ''), PeriodStart, PeriodEnd, IssuedAt, COALESCE(IssuedById, 0)
+						FROM invoices
+						WHERE InvoiceId = $1 ` + lock
+
+	err := q.QueryRow(query, id).Scan(
+		&invoice.InvoiceId,
+		&invoice.InvoiceNumber,
+		&invoice.InvoiceDate,
+		&invoice.DueDate,
+		&invoice.CustomerId,
+		&invoice.CustomerName,
+		&invoice.CompanyName,
+		&invoice.CustomerPhone,
+		&invoice.CustomerEmail,
+		&invoice.PaymentStatus,
+		&invoice.Status,
+		&invoice.VoidedAt,
+		&invoice.VoidReason,
+		&invoice.Currency,
+		&invoice.TaxRounding,
+		&invoice.PricesIncludeTax,
+		&invoice.BillingAddressId,
+		&invoice.CustomerAddress.UnitNumber,
+		&invoice.CustomerAddress.StreetNumber,
+		&invoice.CustomerAddress.StreetName,
+		&invoice.CustomerAddress.City,
+		&invoice.CustomerAddress.State,
+		&invoice.CustomerAddress.Postcode,
+		&invoice.EstimateId,
+		&invoice.EstimateNumber,
+		&invoice.ScheduleId,
+		&invoice.PeriodStart,
+		&invoice.PeriodEnd,
+		&invoice.IssuedAt,
+		&invoice.IssuedById,
+	)
+	if err != nil {
+		return invoice, err
+	}
+
+	invoices := []model.Invoice{invoice}
+	err = loadInvoiceDetails(q, invoices)
+	return invoices[0], err
+}
+
+// queryer is a *sql.DB or *sql.Tx
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// loadInvoiceDetails fills in the line items, payments and credit notes, works out the totals and derives the payment status
+func loadInvoiceDetails(q queryer, invoices []model.Invoice) error {
+	invoiceIds := make([]string, 0, len(invoices))
+	for _, invoice := range invoices {
+		invoiceIds = append(invoiceIds, invoice.InvoiceId)
+	}
+
+	items, err := getItemLists(q, invoiceIds)
+	if err != nil {
+		return err
+	}
+	payments, err := getPayments(q, invoiceIds)
+	if err != nil {
+		return err
+	}
+	creditNotes, err := getCreditNotes(q, invoiceIds)
+	if err != nil {
+		return err
+	}
+
+	today := time.Now()
+	for idx := range invoices {
+		invoices[idx].ItemList = items[invoices[idx].InvoiceId]
+		invoices[idx].Payments = payments[invoices[idx].InvoiceId]
+		invoices[idx].CreditNotes = nil
+		for _, note := range creditNotes[invoices[idx].InvoiceId] {
+			note.CopyInvoice(invoices[idx])
+			note.CalculateTotals()
+			invoices[idx].CreditNotes = append(invoices[idx].CreditNotes, note)
+		}
+		invoices[idx].CalculateTotals()
+		invoices[idx].PaymentStatus = invoices[idx].DerivePaymentStatus(today)
+	}
+	return nil
+}
+
+// getItemLists loads the line items for the given invoices, keyed by InvoiceId
+func getItemLists(q queryer, invoiceIds []string) (map[string][]model.ItemList, error) {
+	items := make(map[string][]model.ItemList)
+	if len(invoiceIds) == 0 {
+		return items, nil
+	}
+
+	rows, err := q.Query(`SELECT ItemId, InvoiceId, Item, Quantity, COALESCE(ProductId, 0), UnitPrice, COALESCE(TaxCode, ''), TaxName, TaxRate, Subtotal, Tax, Total
+						FROM item_lists
+						WHERE InvoiceId = ANY($1)
+						ORDER BY ItemId`, pq.Array(invoiceIds))
+	if err != nil {
+		return nil, fmt.Errorf("error querying invoice items: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item model.ItemList
+		if err := rows.Scan(&item.ItemId, &item.InvoiceId, &item.Item, &item.Quantity, &item.ProductId, &item.UnitPrice, &item.TaxCode, &item.TaxName, &item.TaxRate, &item.Subtotal, &item.Tax, &item.Total); err != nil {
+			return nil, fmt.Errorf("error scanning invoice item: %v", err)
+		}
+		items[item.InvoiceId] = append(items[item.InvoiceId], item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating invoice item rows: %v", err)
+	}
+	return items, nil
+}
+
+// AddNewInvoice inserts the invoice and all of its line items in a single transaction, drafts are left unnumbered until they are issued.
+// The customer details and billing address are copied from the customer record so the invoice keeps them even if the customer changes later.
+// BillingAddressId picks one of the customer's addresses, when it is 0 the customer's default billing address is used.
 func (repo *InvoiceRepository) AddNewInvoice(invoice model.Invoice) (string, error) {
-	var invoiceId string
-	var lastInvoiceNumber string
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("error starting invoice transaction: %v", err)
+	}
+	defer tx.Rollback()
 
-	err := repo.db.QueryRow("SELECT InvoiceNumber FROM invoices ORDER by InvoiceNumber DESC LIMIT 1").Scan(&lastInvoiceNumber)
-	if err != nil && err != sql.ErrNoRows {
-		return "", fmt.Errorf("error fetching last invoice number: %v", err)
+	customer, err := getBillTo(tx, invoice.CustomerId, invoice.BillingAddressId)
+	if err != nil {
+		return "", err
 	}
+	invoice.CustomerName, invoice.CompanyName, invoice.CustomerPhone, invoice.CustomerEmail = customer.Name, customer.Company, customer.Phone, customer.Email
+	invoice.BillingAddressId, invoice.CustomerAddress = customer.AddressId, customer.Address
 
-	newInvoiceNumber, err := GenerateInvoiceNumber(lastInvoiceNumber)
+	invoiceId, err := repo.insertInvoice(tx, invoice)
 	if err != nil {
 		return "", err
 	}
 
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("error committing invoice: %v", err)
+	}
+	return invoiceId, nil
+}
+
+// billTo is the customer's details and billing address as copied onto an invoice or estimate
+type billTo struct {
+	Name, Company, Phone, Email string
+	AddressId                   int
+	Address                     model.Address
+}
+
+// getBillTo reads the customer's details and the address to bill to. When addressId is 0 the customer's
+// default billing address is used, and a customer without any addresses is billed without one.
+func getBillTo(tx *sql.Tx, customerId string, addressId int) (billTo, error) {
+	var b billTo
+	err := tx.QueryRow("SELECT CONCAT(FirstName, ' ', LastName), COALESCE(CompanyName, ''), COALESCE(Phone, ''), COALESCE(Email, '') FROM customers WHERE Id = $1 AND DeletedAt IS NULL", customerId).Scan(
+		&b.Name,
+		&b.Company,
+		&b.Phone,
+		&b.Email,
+	)
+	if err != nil {
+		return b, fmt.Errorf("error fetching customer %s: %v", customerId, err)
+	}
+
+	// Billing addresses first with the default on top, falling back to a service address
+	err = tx.QueryRow(`SELECT AddressId, UnitNumber, StreetNumber, StreetName, City, State, Postcode
+						FROM customer_addresses
+						WHERE CustomerId = $1 AND ($2 = 0 OR AddressId = $2)
+						ORDER BY Type = 'billing' DESC, IsDefault DESC, AddressId
+						LIMIT 1`, customerId, addressId).Scan(
+		&b.AddressId,
+		&b.Address.UnitNumber,
+		&b.Address.StreetNumber,
+		&b.Address.StreetName,
+		&b.Address.City,
+		&b.Address.State,
+		&b.Address.Postcode,
+	)
+	if err == sql.ErrNoRows && addressId != 0 {
+		return b, fmt.Errorf("address %d does not belong to customer %s", addressId, customerId)
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return b, fmt.Errorf("error fetching billing address: %v", err)
+	}
+	return b, nil
+}
+
+// insertInvoice saves the invoice with its line items, numbering it unless it is a draft.
+// Anything but a draft is issued straight away, so its due date can't have passed.
+// The customer details and address have to be filled in already.
+func (repo *InvoiceRepository) insertInvoice(tx *sql.Tx, invoice model.Invoice) (string, error) {
 	invoiceDate := time.Now()
 
-	
-
-	// The query must include actual parameters from the 'invoice' object
-	err = repo.db.QueryRow(
-		"INSERT INTO invoices ( InvoiceNumber, InvoiceDate, DueDate, CustomerId, CustomerName, CompanyName, CustomerPhone, CustomerEmail, PaymentStatus) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING InvoiceId",
-		newInvoiceNumber,      // $1
-		invoiceDate,           // $2
-		invoice.DueDate,       // $3
-		invoice.CustomerId,    // $4 Assume there's a CustomerId field in your model
-		invoice.CustomerName,  // $5
-		invoice.CompanyName,   // $6
-		invoice.CustomerPhone, // $7
-		invoice.CustomerEmail, // $8
-		invoice.PaymentStatus, // $9
+	var newInvoiceNumber, numberSeries sql.NullString
+	var issuedAt sql.NullTime
+	var issuedById int
+	if invoice.Status != model.DraftInvoice {
+		if dueDatePassed(invoice.DueDate, invoiceDate) {
+			return "", ErrDueDatePassed
+		}
+
+		// Taken as late as possible, other invoices wait on the counter until this transaction finishes
+		number, err := repo.numbering.nextNumber(tx, invoiceDate)
+		if err != nil {
+			return "", err
+		}
+		newInvoiceNumber = sql.NullString{String: number, Valid: true}
+		numberSeries = sql.NullString{String: repo.numbering.Series, Valid: true}
+		issuedAt = sql.NullTime{Time: invoiceDate, Valid: true}
+		issuedById = invoice.CreatedById
+	}
+
+	var invoiceId string
+	err := tx.QueryRow(
+		`INSERT INTO invoices ( InvoiceNumber, InvoiceDate, DueDate, CustomerId, CustomerName, CompanyName, CustomerPhone, CustomerEmail, PaymentStatus, CreatedById,
+			BillingAddressId, AddressUnitNumber, AddressStreetNumber, AddressStreetName, AddressCity, AddressState, AddressPostcode, Status, Currency, TaxRounding, PricesIncludeTax, EstimateId,
+			ScheduleId, PeriodStart, PeriodEnd, IssuedAt, IssuedById, NumberSeries)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, 0), NULLIF($11, 0), $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, NULLIF($22, '')::integer,
+			NULLIF($23, '')::integer, $24, $25, $26, NULLIF($27, 0), $28) RETURNING InvoiceId`,
+		newInvoiceNumber,                     // $1
+		invoiceDate,                          // $2
+		invoice.DueDate,                      // $3
+		invoice.CustomerId,                   // $4
+		invoice.CustomerName,                 // $5
+		invoice.CompanyName,                  // $6
+		invoice.CustomerPhone,                // $7
+		invoice.CustomerEmail,                // $8
+		invoice.PaymentStatus,                // $9
+		invoice.CreatedById,                  // $10
+		invoice.BillingAddressId,             // $11
+		invoice.CustomerAddress.UnitNumber,   // $12
+		invoice.CustomerAddress.StreetNumber, // $13
+		invoice.CustomerAddress.StreetName,   // $14
+		invoice.CustomerAddress.City,         // $15
+		invoice.CustomerAddress.State,        // $16
+		invoice.CustomerAddress.Postcode,     // $17
+		invoice.Status,                       // $18
+		invoice.Currency,                     // $19
+		invoice.TaxRounding,                  // $20
+		invoice.PricesIncludeTax,             // $21
+		invoice.EstimateId,                   // $22
+		invoice.ScheduleId,                   // $23
+		invoice.PeriodStart,                  // $24
+		invoice.PeriodEnd,                    // $25
+		issuedAt,                             // $26
+		issuedById,                           // $27
+		numberSeries,                         // $28
 	).Scan(&invoiceId)
 
+	if isUniqueViolationOf(err, "invoices_estimateid_idx") {
+		return "", ErrEstimateConverted
+	}
+	if isUniqueViolationOf(err, "invoices_schedule_period_idx") {
+		return "", ErrPeriodInvoiced
+	}
 	if err != nil {
 		return "", fmt.Errorf("error returning InvoiceId: %v", err)
 	}
+
+	if err := insertItemLists(tx, invoiceId, invoice.ItemList); err != nil {
+		return "", err
+	}
 	return invoiceId, nil
 }
 
-func GenerateInvoiceNumber(lastInvoiceNumber string) (string, error) {
-	if lastInvoiceNumber == "" {
-		return "INV0001", nil
+// insertItemLists saves the line items of an invoice
+func insertItemLists(tx *sql.Tx, invoiceId string, items []model.ItemList) error {
+	for _, item := range items {
+		_, err := tx.Exec(
+			`INSERT INTO item_lists (InvoiceId, Item, Quantity, ProductId, UnitPrice, TaxCode, TaxName, TaxRate, Subtotal, Tax, Total)
+			VALUES ($1, $2, $3, NULLIF($4, 0), $5, NULLIF($6, ''), $7, $8, $9, $10, $11)`,
+			invoiceId, item.Item, item.Quantity, item.ProductId, item.UnitPrice, item.TaxCode, item.TaxName, item.TaxRate, item.Subtotal, item.Tax, item.Total,
+		)
+		if err != nil {
+			return fmt.Errorf("error inserting invoice item %q: %v", item.Item, err)
+		}
+	}
+	return nil
+}
+
+var (
+	ErrInvoiceNotIssued = errors.New("only issued invoices can be voided")
+	ErrInvoiceNotDraft  = errors.New("only draft invoices can be deleted, void an issued invoice instead")
+	ErrDraftNumbered    = errors.New("this draft was numbered before drafts were numbered on issue, issue and void it instead so its number isn't lost")
+	ErrInvoiceCredited  = errors.New("invoices with credit notes can't be voided, credit the rest of the invoice instead")
+	ErrInvoicePaid      = errors.New("invoices with payments can't be voided, raise a credit note refunding the payments instead")
+	ErrInvoiceIssued    = errors.New("the invoice has already been issued")
+	ErrInvoiceLocked    = errors.New("issued invoices can't be changed, raise a credit note instead")
+	ErrDueDatePassed    = errors.New("the due date has passed, change it before issuing the invoice")
+)
+
+// dueDatePassed reports whether an invoice issued on issueDate would already be overdue
+func dueDatePassed(dueDate time.Time, issueDate time.Time) bool {
+	return dueDate.Before(time.Date(issueDate.Year(), issueDate.Month(), issueDate.Day(), 0, 0, 0, 0, dueDate.Location()))
+}
+
+// lockInvoiceStatus reads the invoice's status and locks it until the transaction finishes
+func lockInvoiceStatus(tx *sql.Tx, id string) (model.InvoiceStatus, error) {
+	var status model.InvoiceStatus
+	err := tx.QueryRow("SELECT Status FROM invoices WHERE InvoiceId = $1 FOR UPDATE", id).Scan(&status)
+	return status, err
+}
+
+// UpdateDraftInvoice replaces a draft's customer, due date, tax settings and line items. The customer details and
+// billing address are copied again the same way as AddNewInvoice. When invoice.Status is issued the draft is issued
+// in the same transaction, by invoice.IssuedById.
+func (repo *InvoiceRepository) UpdateDraftInvoice(invoice model.Invoice) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting invoice transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	status, err := lockInvoiceStatus(tx, invoice.InvoiceId)
+	if err != nil {
+		return err
+	}
+	if status != model.DraftInvoice {
+		return ErrInvoiceLocked
+	}
+
+	customer, err := getBillTo(tx, invoice.CustomerId, invoice.BillingAddressId)
+	if err != nil {
+		return err
 	}
 
-	numberPart := lastInvoiceNumber[3:]
-	number, err := strconv.Atoi(numberPart)
+	_, err = tx.Exec(`UPDATE invoices
+						SET InvoiceDate = CURRENT_TIMESTAMP, DueDate = $2, CustomerId = $3, CustomerName = $4, CompanyName = $5, CustomerPhone = $6, CustomerEmail = $7,
+							BillingAddressId = NULLIF($8, 0), AddressUnitNumber = $9, AddressStreetNumber = $10, AddressStreetName = $11, AddressCity = $12, AddressState = $13, AddressPostcode = $14,
+							Currency = $15, TaxRounding = $16, PricesIncludeTax = $17, UpdatedAt = CURRENT_TIMESTAMP
+						WHERE InvoiceId = $1`,
+		invoice.InvoiceId, invoice.DueDate, invoice.CustomerId, customer.Name, customer.Company, customer.Phone, customer.Email,
+		customer.AddressId, customer.Address.UnitNumber, customer.Address.StreetNumber, customer.Address.StreetName, customer.Address.City, customer.Address.State, customer.Address.Postcode,
+		invoice.Currency, invoice.TaxRounding, invoice.PricesIncludeTax)
 	if err != nil {
-		return "", fmt.Errorf("error converting invoice number to integer: %v", err)
+		return fmt.Errorf("error updating invoice %s: %v", invoice.InvoiceId, err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM item_lists WHERE InvoiceId = $1", invoice.InvoiceId); err != nil {
+		return fmt.Errorf("error clearing invoice items for invoice %s: %v", invoice.InvoiceId, err)
+	}
+	if err := insertItemLists(tx, invoice.InvoiceId, invoice.ItemList); err != nil {
+		return err
 	}
 
-	if number >= 9999 {
-		return "", fmt.Errorf("maximum invoice number reached")
+	if invoice.Status == model.IssuedInvoice {
+		if err := repo.issueInvoice(tx, invoice.InvoiceId, invoice.IssuedById); err != nil {
+			return err
+		}
 	}
+	return tx.Commit()
+}
 
-	newInvoiceNumber := fmt.Sprintf("INV%04d", number+1)
-	return newInvoiceNumber, nil
+// IssueInvoice moves a draft to issued, giving it the next invoice number and today's date.
+// The draft's lines are kept as they are and can't be changed afterwards.
+func (repo *InvoiceRepository) IssueInvoice(id string, issuedById int) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting issue transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	status, err := lockInvoiceStatus(tx, id)
+	if err != nil {
+		return err
+	}
+	if !status.CanBecome(model.IssuedInvoice) {
+		return ErrInvoiceIssued
+	}
+
+	if err := repo.issueInvoice(tx, id, issuedById); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// issueInvoice numbers and dates a draft that is locked by the transaction, copying the customer's details
+// and billing address onto it as they are now so the issued invoice keeps them even if the customer changes later
+func (repo *InvoiceRepository) issueInvoice(tx *sql.Tx, id string, issuedById int) error {
+	invoice, err := getInvoice(tx, id, "")
+	if err != nil {
+		return err
+	}
+
+	issueDate := time.Now()
+	if dueDatePassed(invoice.DueDate, issueDate) {
+		return ErrDueDatePassed
+	}
+
+	customer, err := getBillTo(tx, invoice.CustomerId, invoice.BillingAddressId)
+	if err != nil {
+		return err
+	}
+
+	// Drafts saved before numbering moved to issuing already have a number, numbering them again would leave a gap.
+	// Otherwise numbered last for the same reason as in insertInvoice.
+	invoiceNumber := invoice.InvoiceNumber
+	if invoiceNumber == "" {
+		if invoiceNumber, err = repo.numbering.nextNumber(tx, issueDate); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE invoices
+						SET Status = $2, InvoiceNumber = $3, NumberSeries = COALESCE(NumberSeries, $17), InvoiceDate = $4, IssuedAt = $4, IssuedById = NULLIF($5, 0),
+							CustomerName = $6, CompanyName = $7, CustomerPhone = $8, CustomerEmail = $9,
+							BillingAddressId = NULLIF($10, 0), AddressUnitNumber = $11, AddressStreetNumber = $12, AddressStreetName = $13, AddressCity = $14, AddressState = $15, AddressPostcode = $16,
+							UpdatedAt = CURRENT_TIMESTAMP
+						WHERE InvoiceId = $1`,
+		id, model.IssuedInvoice, invoiceNumber, issueDate, issuedById,
+		customer.Name, customer.Company, customer.Phone, customer.Email,
+		customer.AddressId, customer.Address.UnitNumber, customer.Address.StreetNumber, customer.Address.StreetName, customer.Address.City, customer.Address.State, customer.Address.Postcode,
+		repo.numbering.Series)
+	if err != nil {
+		return fmt.Errorf("error issuing invoice %s: %v", id, err)
+	}
+	return nil
+}
+
+// VoidInvoice marks an issued invoice void. The invoice keeps its number and stays on record.
+// Once anything has been paid or credited the invoice has to be credited in full instead, refunding any payments.
+func (repo *InvoiceRepository) VoidInvoice(id string, reason string, voidedById int) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting void transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	status, err := lockInvoiceStatus(tx, id)
+	if err != nil {
+		return err
+	}
+	if !status.CanBecome(model.VoidInvoice) {
+		return ErrInvoiceNotIssued
+	}
+	var credited bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM credit_notes WHERE InvoiceId = $1)", id).Scan(&credited)
+	if err != nil {
+		return fmt.Errorf("error checking credit notes for invoice %s: %v", id, err)
+	}
+	if credited {
+		return ErrInvoiceCredited
+	}
+	var paid bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM payments WHERE InvoiceId = $1)", id).Scan(&paid)
+	if err != nil {
+		return fmt.Errorf("error checking payments for invoice %s: %v", id, err)
+	}
+	if paid {
+		return ErrInvoicePaid
+	}
+
+	_, err = tx.Exec(`UPDATE invoices
+						SET Status = $1, VoidedAt = CURRENT_TIMESTAMP, VoidReason = $2, VoidedById = NULLIF($3, 0), UpdatedAt = CURRENT_TIMESTAMP
+						WHERE InvoiceId = $4`, model.VoidInvoice, reason, voidedById, id)
+	if err != nil {
+		return fmt.Errorf("error voiding invoice %s: %v", id, err)
+	}
+	return tx.Commit()
+}
+
+// DeleteDraftInvoice deletes a draft invoice and its line items, anything already issued is refused and so are
+// drafts that already have a number
+func (repo *InvoiceRepository) DeleteDraftInvoice(id string) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting delete transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	status, err := lockInvoiceStatus(tx, id)
+	if err != nil {
+		return err
+	}
+	if status != model.DraftInvoice {
+		return ErrInvoiceNotDraft
+	}
+	var numbered bool
+	if err := tx.QueryRow("SELECT InvoiceNumber IS NOT NULL FROM invoices WHERE InvoiceId = $1", id).Scan(&numbered); err != nil {
+		return fmt.Errorf("error reading invoice %s: %v", id, err)
+	}
+	if numbered {
+		return ErrDraftNumbered
+	}
+
+	if _, err := tx.Exec("DELETE FROM invoices WHERE InvoiceId = $1", id); err != nil {
+		return fmt.Errorf("error deleting invoice %s: %v", id, err)
+	}
+	return tx.Commit()
+}
+
+// MarkOverdueInvoices moves issued invoices that are unpaid past their due date to overdue and records the change
+// in each invoice's status history. Partially paid invoices keep their status. It returns how many were marked.
+func (repo *InvoiceRepository) MarkOverdueInvoices() (int, error) {
+	result, err := repo.db.Exec(`WITH overdue AS (
+							UPDATE invoices SET PaymentStatus = $1, UpdatedAt = CURRENT_TIMESTAMP
+							WHERE Status = $3 AND PaymentStatus = $2 AND DueDate < CURRENT_DATE
+							RETURNING InvoiceId
+						)
+						INSERT INTO invoice_status_history (InvoiceId, FromPaymentStatus, ToPaymentStatus, Reason)
+						SELECT InvoiceId, $2, $1, 'Past due date' FROM overdue`,
+		model.Overdue, model.Pending, model.IssuedInvoice)
+	if err != nil {
+		return 0, fmt.Errorf("error marking overdue invoices: %v", err)
+	}
+	marked, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error counting overdue invoices: %v", err)
+	}
+	return int(marked), nil
+}
+
+// GetPaymentStatusHistory lists every payment status change for an invoice, newest first
+func (repo *InvoiceRepository) GetPaymentStatusHistory(invoiceId string) ([]model.PaymentStatusChange, error) {
+	rows, err := repo.db.Query(`SELECT h.HistoryId, h.InvoiceId, h.FromPaymentStatus, h.ToPaymentStatus,
+						COALESCE(h.ChangedById, 0), COALESCE(u.Name, ''), h.Reason, h.ChangedAt
+						FROM invoice_status_history h
+						LEFT JOIN users u ON u.Id = h.ChangedById
+						WHERE h.InvoiceId = $1
+						ORDER BY h.ChangedAt DESC, h.HistoryId DESC`, invoiceId)
+	if err != nil {
+		return nil, fmt.Errorf("error querying invoice status history: %v", err)
+	}
+	defer rows.Close()
+
+	var history []model.PaymentStatusChange
+	for rows.Next() {
+		var change model.PaymentStatusChange
+		var from sql.NullInt64
+		if err := rows.Scan(
+			&change.HistoryId,
+			&change.InvoiceId,
+			&from,
+			&change.ToStatus,
+			&change.ChangedById,
+			&change.ChangedByName,
+			&change.Reason,
+			&change.ChangedAt,
+		); err != nil {
+			return nil, fmt.Errorf("error scanning invoice status history: %v", err)
+		}
+		if from.Valid {
+			status := model.PaymentStatus(from.Int64)
+			change.FromStatus = &status
+		}
+		history = append(history, change)
+	}
+	return history, rows.Err()
 }
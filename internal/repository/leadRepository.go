@@ -2,6 +2,9 @@ package repository
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/MrAjMann/crm/internal/model"
 )
@@ -14,54 +17,185 @@ func NewLeadRepository(db *sql.DB) *LeadRepository {
 	return &LeadRepository{db: db}
 }
 
-// Example function to fetch all leads
-func (repo *LeadRepository) GetAllLeads() ([]model.Lead, error) {
-	rows, err := repo.db.Query("SELECT Id, FirstName,Lastname, Email, CompanyName, Phone, Title, Website, Industry, Source  FROM leads")
+// leadListColumns are the columns shown in the leads table, read by scanLeadRow
+const leadListColumns = `l.Id, COALESCE(l.FirstName, ''), COALESCE(l.LastName, ''), COALESCE(l.Email, ''), COALESCE(l.CompanyName, ''), COALESCE(l.Phone, ''),
+						COALESCE(l.Title, ''), COALESCE(l.Website, ''), COALESCE(l.Industry, ''), COALESCE(l.Source, ''), l.DeletedAt,
+						s.StatusId, s.StatusValue, s.IsClosed, COALESCE(s.ClosedStatusValue, '')`
+
+func scanLeadRow(row interface{ Scan(...any) error }) (model.Lead, error) {
+	var lead model.Lead
+	err := row.Scan(
+		&lead.LeadId,
+		&lead.FirstName,
+		&lead.LastName,
+		&lead.Email,
+		&lead.CompanyName,
+		&lead.Phone,
+		&lead.Title,
+		&lead.Website,
+		&lead.Industry,
+		&lead.Source,
+		&lead.DeletedAt,
+		&lead.Status.StatusId,
+		&lead.Status.StatusValue,
+		&lead.Status.IsClosed,
+		&lead.Status.ClosedStatusValue,
+	)
+	return lead, err
+}
+
+func (repo *LeadRepository) queryLeads(query string, args ...any) ([]model.Lead, error) {
+	rows, err := repo.db.Query(query, args...)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("error querying leads: %v", err)
 	}
 	defer rows.Close()
 
 	var leads []model.Lead
 	for rows.Next() {
-		var lead model.Lead
-		if err := rows.Scan(
-			&lead.LeadId,
-			&lead.FirstName,
-			&lead.LastName,
-			&lead.Email,
-			&lead.CompanyName,
-			&lead.Phone,
-			&lead.Website,
-			&lead.Title,
-			&lead.Industry,
-			&lead.Source); err != nil {
-			return nil, err
+		lead, err := scanLeadRow(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning lead: %v", err)
 		}
 		leads = append(leads, lead)
 	}
-
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating lead rows: %v", err)
+	}
 	return leads, nil
 }
 
-// Addlead inserts a new lead into the database
+// GetAllLeads fetches every lead that hasn't been archived
+func (repo *LeadRepository) GetAllLeads() ([]model.Lead, error) {
+	return repo.queryLeads(`SELECT ` + leadListColumns + `
+						FROM leads l
+						JOIN status s ON s.StatusId = l.StatusId
+						WHERE l.DeletedAt IS NULL
+						ORDER BY l.Id`)
+}
+
+// SearchLeads matches active leads on name, company, email, phone, source or status
+func (repo *LeadRepository) SearchLeads(query string) ([]model.Lead, error) {
+	searchQuery := "%" + strings.TrimSpace(query) + "%"
+	return repo.queryLeads(`SELECT `+leadListColumns+`
+						FROM leads l
+						JOIN status s ON s.StatusId = l.StatusId
+						WHERE l.DeletedAt IS NULL
+						AND (CONCAT(l.FirstName, ' ', l.LastName) ILIKE $1 OR l.CompanyName ILIKE $1 OR l.Email ILIKE $1 OR l.Phone ILIKE $1
+							OR l.Source ILIKE $1 OR CONCAT(s.StatusValue, ' - ', s.ClosedStatusValue) ILIKE $1)
+						ORDER BY l.Id`, searchQuery)
+}
+
+// GetArchivedLeads fetches archived leads, most recently archived first
+func (repo *LeadRepository) GetArchivedLeads() ([]model.Lead, error) {
+	return repo.queryLeads(`SELECT ` + leadListColumns + `
+						FROM leads l
+						JOIN status s ON s.StatusId = l.StatusId
+						WHERE l.DeletedAt IS NOT NULL
+						ORDER BY l.DeletedAt DESC`)
+}
+
+// ArchiveLeadById hides the lead from the leads list and search, keeping its notes and history.
+// It returns sql.ErrNoRows when there is no active lead with that Id.
+func (repo *LeadRepository) ArchiveLeadById(id string) (model.Lead, error) {
+	return repo.setDeletedAt(id, "CURRENT_TIMESTAMP", "DeletedAt IS NULL")
+}
+
+// RestoreLeadById brings an archived lead back.
+// It returns sql.ErrNoRows when there is no archived lead with that Id.
+func (repo *LeadRepository) RestoreLeadById(id string) (model.Lead, error) {
+	return repo.setDeletedAt(id, "NULL", "DeletedAt IS NOT NULL")
+}
+
+func (repo *LeadRepository) setDeletedAt(id string, deletedAt string, condition string) (model.Lead, error) {
+	row := repo.db.QueryRow(`WITH l AS (
+							UPDATE leads SET DeletedAt = `+deletedAt+`, UpdatedAt = CURRENT_TIMESTAMP
+							WHERE Id = $1 AND `+condition+`
+							RETURNING *
+						)
+						SELECT `+leadListColumns+`
+						FROM l
+						JOIN status s ON s.StatusId = l.StatusId`, id)
+	return scanLeadRow(row)
+}
+
+var ErrLeadNotArchived = errors.New("leads have to be archived before they can be purged")
+
+// PurgeLeadById permanently deletes an archived lead with its notes and status history.
+// A customer converted from the lead keeps existing, it just loses the link back.
+func (repo *LeadRepository) PurgeLeadById(id string) (model.Lead, error) {
+	var lead model.Lead
+
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return lead, fmt.Errorf("error starting purge transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow("SELECT Id, COALESCE(FirstName, ''), COALESCE(LastName, ''), DeletedAt FROM leads WHERE Id = $1 FOR UPDATE", id).Scan(
+		&lead.LeadId,
+		&lead.FirstName,
+		&lead.LastName,
+		&lead.DeletedAt,
+	)
+	if err != nil {
+		return lead, err
+	}
+	if !lead.Archived() {
+		return lead, ErrLeadNotArchived
+	}
+
+	// Notes would otherwise be left behind belonging to nobody, the history goes with the lead
+	if _, err := tx.Exec("DELETE FROM notes WHERE LeadId = $1", lead.LeadId); err != nil {
+		return lead, fmt.Errorf("error purging notes for lead %d: %v", lead.LeadId, err)
+	}
+	if _, err := tx.Exec("DELETE FROM leads WHERE Id = $1", lead.LeadId); err != nil {
+		return lead, fmt.Errorf("error purging lead %d: %v", lead.LeadId, err)
+	}
+	return lead, tx.Commit()
+}
+
+// Addlead inserts a new lead into the database in the New Lead status
 func (repo *LeadRepository) AddLead(lead model.Lead) (string, error) {
 	var leadId string
-	err := repo.db.QueryRow("INSERT INTO leads (FirstName, LastName, Email, CompanyName, Phone, Title, Website, Industry, Source ) VALUES ($1, $2, $3, $4, $5,$6,$7, $8, $9) RETURNING Id",
-		lead.FirstName, lead.LastName, lead.Email, lead.CompanyName, lead.Phone, lead.Title, lead.Website, lead.Industry, lead.Source).Scan(&leadId)
+	var statusId int
+
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("error starting lead transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow("SELECT StatusId FROM status WHERE StatusValue = $1", model.NewLeadStatus).Scan(&statusId)
+	if err != nil {
+		return "", fmt.Errorf("error fetching %q status: %v", model.NewLeadStatus, err)
+	}
+
+	err = tx.QueryRow("INSERT INTO leads (FirstName, LastName, Email, CompanyName, Phone, Title, Website, Industry, Source, StatusId) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING Id",
+		lead.FirstName, lead.LastName, lead.Email, lead.CompanyName, lead.Phone, lead.Title, lead.Website, lead.Industry, lead.Source, statusId).Scan(&leadId)
 	if err != nil {
 		return "", err
 	}
+
+	_, err = tx.Exec("INSERT INTO lead_status_history (LeadId, FromStatusId, ToStatusId) VALUES ($1, NULL, $2)", leadId, statusId)
+	if err != nil {
+		return "", fmt.Errorf("error recording lead status history: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("error committing lead: %v", err)
+	}
 	return leadId, nil
 }
 
 func (repo *LeadRepository) GetLeadById(id string) (model.Lead, error) {
-	println(id)
 	var lead model.Lead
 
-	query := `SELECT Id, FirstName, LastName, Email, Phone, CompanyName, Website, Title, Industry, Source 
-						FROM leads
-						WHERE Id = $1`
+	query := `SELECT l.Id, l.FirstName, l.LastName, l.Email, l.Phone, l.CompanyName, l.Website, l.Title, l.Industry, l.Source, l.DeletedAt,
+						s.StatusId, s.StatusValue, s.IsClosed, COALESCE(s.ClosedStatusValue, '')
+						FROM leads l
+						JOIN status s ON s.StatusId = l.StatusId
+						WHERE l.Id = $1`
 
 	err := repo.db.QueryRow(query, id).Scan(
 		&lead.LeadId,
@@ -74,9 +208,222 @@ func (repo *LeadRepository) GetLeadById(id string) (model.Lead, error) {
 		&lead.Title,
 		&lead.Industry,
 		&lead.Source,
+		&lead.DeletedAt,
+		&lead.Status.StatusId,
+		&lead.Status.StatusValue,
+		&lead.Status.IsClosed,
+		&lead.Status.ClosedStatusValue,
 	)
 	if err != nil {
 		return lead, err
 	}
+
+	lead.History, err = repo.GetLeadStatusHistory(id)
+	if err != nil {
+		return lead, err
+	}
 	return lead, nil
 }
+
+// GetAllStatuses lists the pipeline statuses in the order a lead moves through them
+func (repo *LeadRepository) GetAllStatuses() ([]model.Status, error) {
+	rows, err := repo.db.Query("SELECT StatusId, StatusValue, IsClosed, COALESCE(ClosedStatusValue, '') FROM status ORDER BY StatusId")
+	if err != nil {
+		return nil, fmt.Errorf("error querying statuses: %v", err)
+	}
+	defer rows.Close()
+
+	var statuses []model.Status
+	for rows.Next() {
+		var status model.Status
+		if err := rows.Scan(&status.StatusId, &status.StatusValue, &status.IsClosed, &status.ClosedStatusValue); err != nil {
+			return nil, fmt.Errorf("error scanning status: %v", err)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, rows.Err()
+}
+
+// UpdateLeadStatus moves a lead to a new status and records the transition.
+// Moving a lead to the status it is already in is a no-op.
+func (repo *LeadRepository) UpdateLeadStatus(leadId string, statusId int, changedById int, changedByName string) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting lead status transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := changeLeadStatus(tx, leadId, statusId, changedById, changedByName); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// changeLeadStatus does the work of UpdateLeadStatus inside an existing transaction
+func changeLeadStatus(tx *sql.Tx, leadId string, statusId int, changedById int, changedByName string) error {
+	var currentStatusId int
+	err := tx.QueryRow("SELECT StatusId FROM leads WHERE Id = $1 FOR UPDATE", leadId).Scan(&currentStatusId)
+	if err != nil {
+		return fmt.Errorf("error fetching lead %s: %w", leadId, err)
+	}
+	if currentStatusId == statusId {
+		return nil
+	}
+
+	_, err = tx.Exec("UPDATE leads SET StatusId = $1, UpdatedAt = CURRENT_TIMESTAMP WHERE Id = $2", statusId, leadId)
+	if err != nil {
+		return fmt.Errorf("error updating lead status: %v", err)
+	}
+
+	_, err = tx.Exec("INSERT INTO lead_status_history (LeadId, FromStatusId, ToStatusId, ChangedById, ChangedByName) VALUES ($1, $2, $3, NULLIF($4, 0), NULLIF($5, ''))",
+		leadId, currentStatusId, statusId, changedById, changedByName)
+	if err != nil {
+		return fmt.Errorf("error recording lead status history: %v", err)
+	}
+	return nil
+}
+
+// GetLeadStatusHistory lists every status change for a lead, newest first
+func (repo *LeadRepository) GetLeadStatusHistory(leadId string) ([]model.LeadStatusChange, error) {
+	rows, err := repo.db.Query(`SELECT h.HistoryId, h.LeadId,
+						f.StatusId, f.StatusValue, f.IsClosed, f.ClosedStatusValue,
+						t.StatusId, t.StatusValue, t.IsClosed, COALESCE(t.ClosedStatusValue, ''),
+						COALESCE(h.ChangedById, 0), COALESCE(h.ChangedByName, ''), h.ChangedAt
+						FROM lead_status_history h
+						LEFT JOIN status f ON f.StatusId = h.FromStatusId
+						JOIN status t ON t.StatusId = h.ToStatusId
+						WHERE h.LeadId = $1
+						ORDER BY h.ChangedAt DESC, h.HistoryId DESC`, leadId)
+	if err != nil {
+		return nil, fmt.Errorf("error querying lead status history: %v", err)
+	}
+	defer rows.Close()
+
+	var history []model.LeadStatusChange
+	for rows.Next() {
+		var change model.LeadStatusChange
+		var fromId sql.NullInt64
+		var fromValue, fromClosedValue sql.NullString
+		var fromIsClosed sql.NullBool
+		if err := rows.Scan(
+			&change.HistoryId,
+			&change.LeadId,
+			&fromId, &fromValue, &fromIsClosed, &fromClosedValue,
+			&change.ToStatus.StatusId, &change.ToStatus.StatusValue, &change.ToStatus.IsClosed, &change.ToStatus.ClosedStatusValue,
+			&change.ChangedById,
+			&change.ChangedByName,
+			&change.ChangedAt,
+		); err != nil {
+			return nil, fmt.Errorf("error scanning lead status history: %v", err)
+		}
+		if fromId.Valid {
+			change.FromStatus = &model.Status{
+				StatusId:          int(fromId.Int64),
+				StatusValue:       fromValue.String,
+				IsClosed:          fromIsClosed.Bool,
+				ClosedStatusValue: fromClosedValue.String,
+			}
+		}
+		history = append(history, change)
+	}
+	return history, rows.Err()
+}
+
+// GetWinRateReport counts closed leads by their outcome
+func (repo *LeadRepository) GetWinRateReport() (model.WinRateReport, error) {
+	var report model.WinRateReport
+
+	rows, err := repo.db.Query(`SELECT s.ClosedStatusValue, COUNT(*)
+						FROM leads l
+						JOIN status s ON s.StatusId = l.StatusId
+						WHERE s.IsClosed AND l.DeletedAt IS NULL
+						GROUP BY s.ClosedStatusValue`)
+	if err != nil {
+		return report, fmt.Errorf("error querying win rate: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var outcome string
+		var count int
+		if err := rows.Scan(&outcome, &count); err != nil {
+			return report, fmt.Errorf("error scanning win rate: %v", err)
+		}
+		switch outcome {
+		case model.OutcomeWon:
+			report.Won = count
+		case model.OutcomeLost:
+			report.Lost = count
+		case model.OutcomeStillFighting:
+			report.StillFighting = count
+		}
+	}
+	return report, rows.Err()
+}
+
+// ConvertLeadToCustomer creates a customer from the lead, moves the lead's notes across
+// and closes the lead as won. Converting the same lead again returns the existing customer.
+func (repo *LeadRepository) ConvertLeadToCustomer(leadId string, changedById int, changedByName string) (string, error) {
+	var customerId string
+
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("error starting lead conversion transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the lead so two clicks at once cannot both create a customer
+	var lead model.Lead
+	err = tx.QueryRow(`SELECT Id, COALESCE(FirstName, ''), COALESCE(LastName, ''), COALESCE(Email, ''), COALESCE(CompanyName, ''), COALESCE(Phone, ''),
+						COALESCE(Title, ''), COALESCE(Website, ''), COALESCE(Industry, ''), COALESCE(Source, '')
+						FROM leads
+						WHERE Id = $1
+						FOR UPDATE`, leadId).Scan(
+		&lead.LeadId,
+		&lead.FirstName,
+		&lead.LastName,
+		&lead.Email,
+		&lead.CompanyName,
+		&lead.Phone,
+		&lead.Title,
+		&lead.Website,
+		&lead.Industry,
+		&lead.Source,
+	)
+	if err != nil {
+		return "", fmt.Errorf("error fetching lead %s: %w", leadId, err)
+	}
+
+	err = tx.QueryRow("SELECT Id FROM customers WHERE LeadId = $1", leadId).Scan(&customerId)
+	if err == nil {
+		return customerId, tx.Commit()
+	}
+	if err != sql.ErrNoRows {
+		return "", fmt.Errorf("error checking for converted customer: %v", err)
+	}
+
+	err = tx.QueryRow("INSERT INTO customers (FirstName, LastName, Email, Phone, CompanyName, Title, Website, Industry, Source, LeadId) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING Id",
+		lead.FirstName, lead.LastName, lead.Email, lead.Phone, lead.CompanyName, lead.Title, lead.Website, lead.Industry, lead.Source, lead.LeadId).Scan(&customerId)
+	if err != nil {
+		return "", fmt.Errorf("error inserting customer for lead %s: %v", leadId, err)
+	}
+
+	_, err = tx.Exec("UPDATE notes SET CustomerId = $1, LeadId = NULL, UpdatedAt = CURRENT_TIMESTAMP WHERE LeadId = $2", customerId, leadId)
+	if err != nil {
+		return "", fmt.Errorf("error moving notes to customer: %v", err)
+	}
+
+	var wonStatusId int
+	err = tx.QueryRow("SELECT StatusId FROM status WHERE StatusValue = $1 AND ClosedStatusValue = $2", model.ClosedStatus, model.OutcomeWon).Scan(&wonStatusId)
+	if err != nil {
+		return "", fmt.Errorf("error fetching won status: %v", err)
+	}
+	if err := changeLeadStatus(tx, leadId, wonStatusId, changedById, changedByName); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("error committing lead conversion: %v", err)
+	}
+	return customerId, nil
+}
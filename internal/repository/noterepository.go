@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/MrAjMann/crm/internal/model"
+)
+
+type NoteRepository struct {
+	db *sql.DB
+}
+
+func NewNoteRepository(db *sql.DB) *NoteRepository {
+	return &NoteRepository{db: db}
+}
+
+const noteColumns = "NoteId, CustomerId, LeadId, Category, COALESCE(AuthorId, 0), COALESCE(AuthorName, ''), COALESCE(Content, ''), CreatedAt, UpdatedAt"
+
+func scanNote(row interface{ Scan(...any) error }) (model.Note, error) {
+	var note model.Note
+	var customerId, leadId sql.NullInt64
+	err := row.Scan(
+		&note.NoteId,
+		&customerId,
+		&leadId,
+		&note.Category,
+		&note.AuthorId,
+		&note.AuthorName,
+		&note.Content,
+		&note.CreatedAt,
+		&note.UpdatedAt,
+	)
+	if customerId.Valid {
+		id := int(customerId.Int64)
+		note.CustomerId = &id
+	}
+	if leadId.Valid {
+		id := int(leadId.Int64)
+		note.LeadId = &id
+	}
+	return note, err
+}
+
+// AddNote inserts a note for a customer or a lead and returns it as stored
+func (repo *NoteRepository) AddNote(note model.Note) (model.Note, error) {
+	row := repo.db.QueryRow("INSERT INTO notes (CustomerId, LeadId, Category, AuthorId, AuthorName, Content) VALUES ($1, $2, $3, NULLIF($4, 0), NULLIF($5, ''), $6) RETURNING "+noteColumns,
+		note.CustomerId, note.LeadId, note.Category, note.AuthorId, note.AuthorName, note.Content)
+	added, err := scanNote(row)
+	if err != nil {
+		return added, fmt.Errorf("error inserting note: %v", err)
+	}
+	return added, nil
+}
+
+func (repo *NoteRepository) GetNoteById(id string) (model.Note, error) {
+	return scanNote(repo.db.QueryRow("SELECT "+noteColumns+" FROM notes WHERE NoteId = $1", id))
+}
+
+// UpdateNote changes the category and content of a note
+func (repo *NoteRepository) UpdateNote(note model.Note) (model.Note, error) {
+	row := repo.db.QueryRow("UPDATE notes SET Category = $1, Content = $2, UpdatedAt = CURRENT_TIMESTAMP WHERE NoteId = $3 RETURNING "+noteColumns,
+		note.Category, note.Content, note.NoteId)
+	return scanNote(row)
+}
+
+func (repo *NoteRepository) DeleteNoteById(id string) error {
+	result, err := repo.db.Exec("DELETE FROM notes WHERE NoteId = $1", id)
+	if err != nil {
+		return fmt.Errorf("error deleting note %s: %v", id, err)
+	}
+	if count, err := result.RowsAffected(); err == nil && count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetNotesForCustomer lists a customer's notes newest first, an empty category returns every category
+func (repo *NoteRepository) GetNotesForCustomer(customerId int, category model.NoteCategory) ([]model.Note, error) {
+	return repo.getNotes("CustomerId", customerId, category)
+}
+
+// GetNotesForLead lists a lead's notes newest first, an empty category returns every category
+func (repo *NoteRepository) GetNotesForLead(leadId int, category model.NoteCategory) ([]model.Note, error) {
+	return repo.getNotes("LeadId", leadId, category)
+}
+
+// getNotes is shared by the customer and lead listings, ownerColumn is never user input
+func (repo *NoteRepository) getNotes(ownerColumn string, ownerId int, category model.NoteCategory) ([]model.Note, error) {
+	query := "SELECT " + noteColumns + " FROM notes WHERE " + ownerColumn + " = $1 AND ($2 = '' OR Category = $2) ORDER BY CreatedAt DESC, NoteId DESC"
+	rows, err := repo.db.Query(query, ownerId, string(category))
+	if err != nil {
+		return nil, fmt.Errorf("error querying notes: %v", err)
+	}
+	defer rows.Close()
+
+	var notes []model.Note
+	for rows.Next() {
+		note, err := scanNote(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning note: %v", err)
+		}
+		notes = append(notes, note)
+	}
+	return notes, rows.Err()
+}
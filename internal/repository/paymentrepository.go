@@ -0,0 +1,170 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/MrAjMann/crm/internal/model"
+	"github.com/lib/pq"
+)
+
+type PaymentRepository struct {
+	db *sql.DB
+}
+
+func NewPaymentRepository(db *sql.DB) *PaymentRepository {
+	return &PaymentRepository{db: db}
+}
+
+var (
+	ErrInvoiceNotPayable = errors.New("payments can only be recorded against issued invoices")
+	ErrOverpayment       = errors.New("the payment is more than the balance due")
+	ErrRefundNotDeleted  = errors.New("refunds belong to their credit note and can't be deleted")
+	ErrPaymentRefunded   = errors.New("the payment has been refunded, deleting it would leave the refund more than was paid")
+	ErrPaymentNotDeleted = errors.New("payments can only be deleted from issued invoices")
+)
+
+// RecordPayment adds a full or partial payment to an issued invoice and updates its payment status.
+// The invoice is locked while the balance is checked, so two payments can't both take the last of it.
+func (repo *PaymentRepository) RecordPayment(payment model.Payment) (model.Invoice, error) {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return model.Invoice{}, fmt.Errorf("error starting payment transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	invoice, err := getInvoice(tx, payment.InvoiceId, "FOR UPDATE")
+	if err != nil {
+		return invoice, err
+	}
+	if invoice.Status != model.IssuedInvoice {
+		return invoice, ErrInvoiceNotPayable
+	}
+	if payment.Amount.Cents > invoice.BalanceDue.Cents {
+		return invoice, ErrOverpayment
+	}
+
+	err = tx.QueryRow(`INSERT INTO payments (InvoiceId, Amount, PaidOn, Method, Reference, RecordedById)
+						VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0))
+						RETURNING PaymentId, CreatedAt`,
+		payment.InvoiceId, payment.Amount, payment.PaidOn, payment.Method, payment.Reference, payment.RecordedById,
+	).Scan(&payment.PaymentId, &payment.CreatedAt)
+	if err != nil {
+		return invoice, fmt.Errorf("error inserting payment for invoice %s: %v", payment.InvoiceId, err)
+	}
+
+	invoice.Payments = append(invoice.Payments, payment)
+	if err := updatePaymentStatus(tx, &invoice, payment.RecordedById, "Payment recorded"); err != nil {
+		return invoice, err
+	}
+	return invoice, tx.Commit()
+}
+
+// DeletePayment removes a payment recorded by mistake, putting the amount back on the invoice's balance.
+// Refunds can't be deleted, they stay with the credit note that made them, and payments on a void invoice stay on record.
+// It returns the invoice the payment was against.
+func (repo *PaymentRepository) DeletePayment(paymentId int, deletedById int) (model.Invoice, error) {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return model.Invoice{}, fmt.Errorf("error starting payment transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var invoiceId string
+	var refund bool
+	if err := tx.QueryRow("SELECT InvoiceId, CreditNoteId IS NOT NULL FROM payments WHERE PaymentId = $1", paymentId).Scan(&invoiceId, &refund); err != nil {
+		return model.Invoice{}, err
+	}
+	if refund {
+		return model.Invoice{}, ErrRefundNotDeleted
+	}
+	invoice, err := getInvoice(tx, invoiceId, "FOR UPDATE")
+	if err != nil {
+		return invoice, err
+	}
+	if invoice.Status != model.IssuedInvoice {
+		return invoice, ErrPaymentNotDeleted
+	}
+
+	if _, err := tx.Exec("DELETE FROM payments WHERE PaymentId = $1", paymentId); err != nil {
+		return invoice, fmt.Errorf("error deleting payment %d: %v", paymentId, err)
+	}
+	remaining := invoice.Payments[:0]
+	for _, payment := range invoice.Payments {
+		if payment.PaymentId != paymentId {
+			remaining = append(remaining, payment)
+		}
+	}
+	invoice.Payments = remaining
+	invoice.CalculateTotals()
+	if invoice.AmountPaid.Cents < 0 {
+		return invoice, ErrPaymentRefunded
+	}
+
+	if err := updatePaymentStatus(tx, &invoice, deletedById, "Payment deleted"); err != nil {
+		return invoice, err
+	}
+	return invoice, tx.Commit()
+}
+
+// updatePaymentStatus works out the invoice's totals and payment status again and saves the status,
+// recording the change in the invoice's status history when there is one. The invoice must be locked.
+func updatePaymentStatus(tx *sql.Tx, invoice *model.Invoice, changedById int, reason string) error {
+	var stored model.PaymentStatus
+	if err := tx.QueryRow("SELECT PaymentStatus FROM invoices WHERE InvoiceId = $1", invoice.InvoiceId).Scan(&stored); err != nil {
+		return fmt.Errorf("error reading payment status for invoice %s: %v", invoice.InvoiceId, err)
+	}
+
+	invoice.CalculateTotals()
+	invoice.PaymentStatus = invoice.DerivePaymentStatus(time.Now())
+	if invoice.PaymentStatus == stored {
+		return nil
+	}
+
+	_, err := tx.Exec("UPDATE invoices SET PaymentStatus = $2, UpdatedAt = CURRENT_TIMESTAMP WHERE InvoiceId = $1",
+		invoice.InvoiceId, invoice.PaymentStatus)
+	if err != nil {
+		return fmt.Errorf("error updating payment status for invoice %s: %v", invoice.InvoiceId, err)
+	}
+	_, err = tx.Exec(`INSERT INTO invoice_status_history (InvoiceId, FromPaymentStatus, ToPaymentStatus, ChangedById, Reason)
+						VALUES ($1, $2, $3, NULLIF($4, 0), $5)`,
+		invoice.InvoiceId, stored, invoice.PaymentStatus, changedById, reason)
+	if err != nil {
+		return fmt.Errorf("error recording payment status change for invoice %s: %v", invoice.InvoiceId, err)
+	}
+	return nil
+}
+
+// getPayments loads the payments for the given invoices in the order they were paid, keyed by InvoiceId
+func getPayments(q queryer, invoiceIds []string) (map[string][]model.Payment, error) {
+	payments := make(map[string][]model.Payment)
+	if len(invoiceIds) == 0 {
+		return payments, nil
+	}
+
+	rows, err := q.Query(`SELECT p.PaymentId, p.InvoiceId, p.Amount, p.PaidOn, p.Method, p.Reference, COALESCE(p.CreditNoteId::text, ''), COALESCE(c.CreditNoteNumber, ''),
+							COALESCE(p.RecordedById, 0), COALESCE(u.Name, ''), p.CreatedAt
+						FROM payments p
+						LEFT JOIN credit_notes c ON c.CreditNoteId = p.CreditNoteId
+						LEFT JOIN users u ON u.Id = p.RecordedById
+						WHERE p.InvoiceId = ANY($1)
+						ORDER BY p.PaidOn, p.PaymentId`, pq.Array(invoiceIds))
+	if err != nil {
+		return nil, fmt.Errorf("error querying payments: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p model.Payment
+		if err := rows.Scan(&p.PaymentId, &p.InvoiceId, &p.Amount, &p.PaidOn, &p.Method, &p.Reference, &p.CreditNoteId, &p.CreditNoteNumber, &p.RecordedById, &p.RecordedByName, &p.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning payment: %v", err)
+		}
+		payments[p.InvoiceId] = append(payments[p.InvoiceId], p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating payment rows: %v", err)
+	}
+	return payments, nil
+}
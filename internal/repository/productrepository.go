@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/MrAjMann/crm/internal/model"
+)
+
+type ProductRepository struct {
+	db *sql.DB
+}
+
+func NewProductRepository(db *sql.DB) *ProductRepository {
+	return &ProductRepository{db: db}
+}
+
+var ErrProductExists = errors.New("there is already a product with that name")
+
+const productColumns = "ProductId, Name, Description, UnitPrice, TaxCode, Active, CreatedAt, UpdatedAt"
+
+func scanProduct(row interface{ Scan(...any) error }) (model.Product, error) {
+	var p model.Product
+	err := row.Scan(&p.ProductId, &p.Name, &p.Description, &p.UnitPrice, &p.TaxCode, &p.Active, &p.CreatedAt, &p.UpdatedAt)
+	p.UnitPrice.Currency = model.DefaultCurrency
+	return p, err
+}
+
+// GetAllProducts lists products by name, inactive products are left out unless includeInactive is set
+func (repo *ProductRepository) GetAllProducts(includeInactive bool) ([]model.Product, error) {
+	rows, err := repo.db.Query("SELECT "+productColumns+" FROM products WHERE Active OR $1 ORDER BY Active DESC, Name", includeInactive)
+	if err != nil {
+		return nil, fmt.Errorf("error querying products: %v", err)
+	}
+	defer rows.Close()
+
+	var products []model.Product
+	for rows.Next() {
+		product, err := scanProduct(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning product: %v", err)
+		}
+		products = append(products, product)
+	}
+	return products, rows.Err()
+}
+
+func (repo *ProductRepository) GetProductById(id int) (model.Product, error) {
+	return scanProduct(repo.db.QueryRow("SELECT "+productColumns+" FROM products WHERE ProductId = $1", id))
+}
+
+// AddProduct saves a new product, returning ErrProductExists when the name is taken
+func (repo *ProductRepository) AddProduct(product model.Product) (model.Product, error) {
+	added, err := scanProduct(repo.db.QueryRow(`INSERT INTO products (Name, Description, UnitPrice, TaxCode, Active) VALUES ($1, $2, $3, $4, $5)
+						RETURNING `+productColumns,
+		product.Name, product.Description, product.UnitPrice, product.TaxCode, product.Active))
+	if isUniqueViolation(err) {
+		return added, ErrProductExists
+	}
+	if err != nil {
+		return added, fmt.Errorf("error inserting product %q: %v", product.Name, err)
+	}
+	return added, nil
+}
+
+// UpdateProduct saves the product's details. Invoice lines already raised keep their own copy of the price and tax.
+func (repo *ProductRepository) UpdateProduct(product model.Product) (model.Product, error) {
+	updated, err := scanProduct(repo.db.QueryRow(`UPDATE products SET Name = $2, Description = $3, UnitPrice = $4, TaxCode = $5, Active = $6, UpdatedAt = CURRENT_TIMESTAMP
+						WHERE ProductId = $1
+						RETURNING `+productColumns,
+		product.ProductId, product.Name, product.Description, product.UnitPrice, product.TaxCode, product.Active))
+	if isUniqueViolation(err) {
+		return updated, ErrProductExists
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return updated, fmt.Errorf("error updating product %d: %v", product.ProductId, err)
+	}
+	return updated, err
+}
@@ -0,0 +1,295 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/MrAjMann/crm/internal/model"
+	"github.com/lib/pq"
+)
+
+type RecurringInvoiceRepository struct {
+	db       *sql.DB
+	invoices *InvoiceRepository // Raised invoices are numbered and saved as invoices
+}
+
+func NewRecurringInvoiceRepository(db *sql.DB, invoices *InvoiceRepository) *RecurringInvoiceRepository {
+	return &RecurringInvoiceRepository{db: db, invoices: invoices}
+}
+
+var ErrPeriodInvoiced = errors.New("the period has already been invoiced")
+
+// GeneratedInvoice is an invoice raised by a schedule, AutoSend says whether it was issued to be emailed
+type GeneratedInvoice struct {
+	InvoiceId  string
+	ScheduleId string
+	AutoSend   bool
+}
+
+const recurringQuery = `SELECT r.ScheduleId, r.CustomerId, CONCAT(c.FirstName, ' ', c.LastName), COALESCE(c.CompanyName, ''), COALESCE(r.BillingAddressId, 0),
+							r.Description, r.IntervalUnit, r.IntervalCount, r.StartDate, r.EndDate, r.NextRun, r.DueDays, r.AutoSend, r.Active,
+							r.Currency, r.TaxRounding, r.PricesIncludeTax, COALESCE(r.CreatedById, 0), r.CreatedAt, r.UpdatedAt
+						FROM recurring_invoices r
+						JOIN customers c ON c.Id = r.CustomerId`
+
+func scanRecurringInvoice(row interface{ Scan(...any) error }) (model.RecurringInvoice, error) {
+	var r model.RecurringInvoice
+	err := row.Scan(&r.ScheduleId, &r.CustomerId, &r.CustomerName, &r.CompanyName, &r.BillingAddressId,
+		&r.Description, &r.Unit, &r.Every, &r.StartDate, &r.EndDate, &r.NextRun, &r.DueDays, &r.AutoSend, &r.Active,
+		&r.Currency, &r.TaxRounding, &r.PricesIncludeTax, &r.CreatedById, &r.CreatedAt, &r.UpdatedAt)
+	return r, err
+}
+
+// GetAllRecurringInvoices lists every schedule with its lines and totals, next due first
+func (repo *RecurringInvoiceRepository) GetAllRecurringInvoices() ([]model.RecurringInvoice, error) {
+	rows, err := repo.db.Query(recurringQuery + " ORDER BY r.Active DESC, r.NextRun, r.ScheduleId")
+	if err != nil {
+		return nil, fmt.Errorf("error querying recurring invoices: %v", err)
+	}
+	defer rows.Close()
+
+	var schedules []model.RecurringInvoice
+	for rows.Next() {
+		schedule, err := scanRecurringInvoice(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning recurring invoice: %v", err)
+		}
+		schedules = append(schedules, schedule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating recurring invoice rows: %v", err)
+	}
+
+	if err := loadRecurringItems(repo.db, schedules); err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// GetRecurringInvoiceById fetches a single schedule with its lines and totals
+func (repo *RecurringInvoiceRepository) GetRecurringInvoiceById(id string) (model.RecurringInvoice, error) {
+	return getRecurringInvoice(repo.db, id, "")
+}
+
+// getRecurringInvoice fetches a schedule with its lines, lock is appended to the query to lock the row inside a transaction
+func getRecurringInvoice(q queryer, id string, lock string) (model.RecurringInvoice, error) {
+	schedule, err := scanRecurringInvoice(q.QueryRow(recurringQuery+" WHERE r.ScheduleId = $1 "+lock, id))
+	if err != nil {
+		return schedule, err
+	}
+	schedules := []model.RecurringInvoice{schedule}
+	err = loadRecurringItems(q, schedules)
+	return schedules[0], err
+}
+
+// loadRecurringItems fills in the lines for the given schedules and works out their totals
+func loadRecurringItems(q queryer, schedules []model.RecurringInvoice) error {
+	if len(schedules) == 0 {
+		return nil
+	}
+	scheduleIds := make([]string, 0, len(schedules))
+	for _, schedule := range schedules {
+		scheduleIds = append(scheduleIds, schedule.ScheduleId)
+	}
+
+	rows, err := q.Query(`SELECT ScheduleId, Item, Quantity, COALESCE(ProductId, 0), UnitPrice, COALESCE(TaxCode, ''), TaxName, TaxRate
+						FROM recurring_invoice_items
+						WHERE ScheduleId = ANY($1)
+						ORDER BY ItemId`, pq.Array(scheduleIds))
+	if err != nil {
+		return fmt.Errorf("error querying recurring invoice items: %v", err)
+	}
+	defer rows.Close()
+
+	items := make(map[string][]model.ItemList)
+	for rows.Next() {
+		var scheduleId string
+		var item model.ItemList
+		if err := rows.Scan(&scheduleId, &item.Item, &item.Quantity, &item.ProductId, &item.UnitPrice, &item.TaxCode, &item.TaxName, &item.TaxRate); err != nil {
+			return fmt.Errorf("error scanning recurring invoice item: %v", err)
+		}
+		items[scheduleId] = append(items[scheduleId], item)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating recurring invoice item rows: %v", err)
+	}
+
+	for idx := range schedules {
+		schedules[idx].ItemList = items[schedules[idx].ScheduleId]
+		schedules[idx].CalculateTotals()
+	}
+	return nil
+}
+
+// AddRecurringInvoice saves a new schedule and its lines, the first invoice is raised on the start date
+func (repo *RecurringInvoiceRepository) AddRecurringInvoice(schedule model.RecurringInvoice) (string, error) {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("error starting recurring invoice transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var scheduleId string
+	err = tx.QueryRow(`INSERT INTO recurring_invoices (CustomerId, BillingAddressId, Description, IntervalUnit, IntervalCount, StartDate, EndDate, NextRun,
+							DueDays, AutoSend, Currency, TaxRounding, PricesIncludeTax, CreatedById)
+						VALUES ($1, NULLIF($2, 0), $3, $4, $5, $6, $7, $6, $8, $9, $10, $11, $12, NULLIF($13, 0))
+						RETURNING ScheduleId`,
+		schedule.CustomerId, schedule.BillingAddressId, schedule.Description, schedule.Unit, schedule.Every, schedule.StartDate, schedule.EndDate,
+		schedule.DueDays, schedule.AutoSend, schedule.Currency, schedule.TaxRounding, schedule.PricesIncludeTax, schedule.CreatedById,
+	).Scan(&scheduleId)
+	if err != nil {
+		return "", fmt.Errorf("error inserting recurring invoice: %v", err)
+	}
+
+	for _, item := range schedule.ItemList {
+		_, err = tx.Exec(
+			`INSERT INTO recurring_invoice_items (ScheduleId, Item, Quantity, ProductId, UnitPrice, TaxCode, TaxName, TaxRate, Subtotal, Tax, Total)
+			VALUES ($1, $2, $3, NULLIF($4, 0), $5, NULLIF($6, ''), $7, $8, $9, $10, $11)`,
+			scheduleId, item.Item, item.Quantity, item.ProductId, item.UnitPrice, item.TaxCode, item.TaxName, item.TaxRate, item.Subtotal, item.Tax, item.Total,
+		)
+		if err != nil {
+			return "", fmt.Errorf("error inserting recurring invoice item %q: %v", item.Item, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("error committing recurring invoice: %v", err)
+	}
+	return scheduleId, nil
+}
+
+// SetRecurringInvoiceActive pauses or resumes a schedule. Resuming doesn't raise the periods missed while
+// it was paused, it carries on from the next period starting today or later.
+func (repo *RecurringInvoiceRepository) SetRecurringInvoiceActive(id string, active bool) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting recurring invoice transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	schedule, err := getRecurringInvoice(tx, id, "FOR UPDATE OF r")
+	if err != nil {
+		return err
+	}
+	if now := time.Now(); active && !schedule.Active {
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, schedule.NextRun.Location())
+		if schedule.NextRun.Before(today) {
+			schedule.NextRun = schedule.NextPeriod(today.AddDate(0, 0, -1))
+		}
+	}
+
+	_, err = tx.Exec("UPDATE recurring_invoices SET Active = $2, NextRun = $3, UpdatedAt = CURRENT_TIMESTAMP WHERE ScheduleId = $1",
+		id, active, schedule.NextRun)
+	if err != nil {
+		return fmt.Errorf("error updating recurring invoice %s: %v", id, err)
+	}
+	return tx.Commit()
+}
+
+// RaiseDueInvoices raises an invoice for every period that has come due on an active schedule, catching up on any
+// periods missed while the app was down. Each schedule is raised in its own transaction with the schedule locked,
+// and a period is never invoiced twice. Schedules that fail are logged and tried again on the next run.
+func (repo *RecurringInvoiceRepository) RaiseDueInvoices(today time.Time) ([]GeneratedInvoice, error) {
+	rows, err := repo.db.Query(`SELECT r.ScheduleId
+						FROM recurring_invoices r
+						JOIN customers c ON c.Id = r.CustomerId
+						WHERE r.Active AND r.NextRun <= $1::date AND c.DeletedAt IS NULL
+						ORDER BY r.NextRun, r.ScheduleId`, today)
+	if err != nil {
+		return nil, fmt.Errorf("error querying due recurring invoices: %v", err)
+	}
+	var scheduleIds []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning due recurring invoice: %v", err)
+		}
+		scheduleIds = append(scheduleIds, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating due recurring invoices: %v", err)
+	}
+
+	var generated []GeneratedInvoice
+	for _, id := range scheduleIds {
+		raised, err := repo.raiseSchedule(id, today)
+		if err != nil {
+			log.Printf("Error raising recurring invoice %s: %v\n", id, err)
+			continue
+		}
+		generated = append(generated, raised...)
+	}
+	return generated, nil
+}
+
+// raiseSchedule raises the due periods of one schedule and moves its next run on past them
+func (repo *RecurringInvoiceRepository) raiseSchedule(id string, today time.Time) ([]GeneratedInvoice, error) {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting recurring invoice transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Checked again under the lock, another run may have raised the schedule in the meantime
+	schedule, err := getRecurringInvoice(tx, id, "FOR UPDATE OF r")
+	if err != nil {
+		return nil, err
+	}
+	if !schedule.Active {
+		return nil, nil
+	}
+
+	customer, err := getBillTo(tx, schedule.CustomerId, schedule.BillingAddressId)
+	if err != nil {
+		return nil, err
+	}
+
+	var generated []GeneratedInvoice
+	for _, period := range schedule.DuePeriods(today) {
+		schedule.NextRun = schedule.NextPeriod(period)
+
+		// The unique index refuses a second invoice for the period, checking first skips it rather than failing the schedule
+		var invoiced bool
+		err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM invoices WHERE ScheduleId = $1 AND PeriodStart = $2)", id, period).Scan(&invoiced)
+		if err != nil {
+			return nil, fmt.Errorf("error checking period %s of recurring invoice %s: %v", period.Format("2006-01-02"), id, err)
+		}
+		if invoiced {
+			continue
+		}
+
+		invoice := schedule.Invoice(period)
+		invoice.CustomerName, invoice.CompanyName, invoice.CustomerPhone, invoice.CustomerEmail = customer.Name, customer.Company, customer.Phone, customer.Email
+		invoice.BillingAddressId, invoice.CustomerAddress = customer.AddressId, customer.Address
+		invoice.DueDate = today.AddDate(0, 0, schedule.DueDays)
+		invoice.Status = model.DraftInvoice
+		if schedule.AutoSend {
+			invoice.Status = model.IssuedInvoice
+		}
+		invoice.PaymentStatus = model.Pending
+		invoice.CreatedById = schedule.CreatedById
+		invoice.CalculateTotals()
+
+		invoiceId, err := repo.invoices.insertInvoice(tx, invoice)
+		if err != nil {
+			return nil, err
+		}
+		generated = append(generated, GeneratedInvoice{InvoiceId: invoiceId, ScheduleId: id, AutoSend: schedule.AutoSend})
+	}
+
+	_, err = tx.Exec("UPDATE recurring_invoices SET NextRun = $2, Active = $3, UpdatedAt = CURRENT_TIMESTAMP WHERE ScheduleId = $1",
+		id, schedule.NextRun, !schedule.Finished())
+	if err != nil {
+		return nil, fmt.Errorf("error moving recurring invoice %s on: %v", id, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing recurring invoices: %v", err)
+	}
+	return generated, nil
+}
@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/MrAjMann/crm/internal/model"
+)
+
+type ReminderRepository struct {
+	db *sql.DB
+}
+
+func NewReminderRepository(db *sql.DB) *ReminderRepository {
+	return &ReminderRepository{db: db}
+}
+
+var ErrReminderStepExists = errors.New("there is already a reminder sent that many days from the due date")
+
+const reminderStepColumns = "StepId, Name, DaysAfterDue, Subject, Template, Active, CreatedAt, UpdatedAt"
+
+func scanReminderStep(row interface{ Scan(...any) error }) (model.ReminderStep, error) {
+	var s model.ReminderStep
+	err := row.Scan(&s.StepId, &s.Name, &s.DaysAfterDue, &s.Subject, &s.Template, &s.Active, &s.CreatedAt, &s.UpdatedAt)
+	return s, err
+}
+
+// GetAllReminderSteps lists the reminder sequence in the order the reminders go out, including inactive steps
+func (repo *ReminderRepository) GetAllReminderSteps() ([]model.ReminderStep, error) {
+	rows, err := repo.db.Query("SELECT " + reminderStepColumns + " FROM reminder_steps ORDER BY DaysAfterDue")
+	if err != nil {
+		return nil, fmt.Errorf("error querying reminder steps: %v", err)
+	}
+	defer rows.Close()
+
+	var steps []model.ReminderStep
+	for rows.Next() {
+		step, err := scanReminderStep(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning reminder step: %v", err)
+		}
+		steps = append(steps, step)
+	}
+	return steps, rows.Err()
+}
+
+func (repo *ReminderRepository) GetReminderStep(stepId int) (model.ReminderStep, error) {
+	return scanReminderStep(repo.db.QueryRow("SELECT "+reminderStepColumns+" FROM reminder_steps WHERE StepId = $1", stepId))
+}
+
+// AddReminderStep saves a new step, returning ErrReminderStepExists when another step is sent on the same day
+func (repo *ReminderRepository) AddReminderStep(step model.ReminderStep) (model.ReminderStep, error) {
+	added, err := scanReminderStep(repo.db.QueryRow(`INSERT INTO reminder_steps (Name, DaysAfterDue, Subject, Template, Active)
+						VALUES ($1, $2, $3, $4, $5)
+						RETURNING `+reminderStepColumns,
+		step.Name, step.DaysAfterDue, step.Subject, step.Template, step.Active))
+	if isUniqueViolation(err) {
+		return added, ErrReminderStepExists
+	}
+	if err != nil {
+		return added, fmt.Errorf("error inserting reminder step %s: %v", step.Name, err)
+	}
+	return added, nil
+}
+
+// UpdateReminderStep changes a step's timing, wording and whether it is sent
+func (repo *ReminderRepository) UpdateReminderStep(step model.ReminderStep) (model.ReminderStep, error) {
+	updated, err := scanReminderStep(repo.db.QueryRow(`UPDATE reminder_steps
+						SET Name = $2, DaysAfterDue = $3, Subject = $4, Template = $5, Active = $6, UpdatedAt = CURRENT_TIMESTAMP
+						WHERE StepId = $1
+						RETURNING `+reminderStepColumns,
+		step.StepId, step.Name, step.DaysAfterDue, step.Subject, step.Template, step.Active))
+	if isUniqueViolation(err) {
+		return updated, ErrReminderStepExists
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return updated, fmt.Errorf("error updating reminder step %d: %v", step.StepId, err)
+	}
+	return updated, err
+}
+
+// GetDueReminders finds the unpaid issued invoices that are ready for a reminder, with the step to send.
+// Only the latest step an invoice has reached is sent, so an invoice that is already well overdue gets one
+// reminder rather than every step it missed. Steps falling before the invoice was raised are skipped, as are
+// customers who have opted out and steps that already went out or failed in the last day.
+func (repo *ReminderRepository) GetDueReminders() ([]model.DueReminder, error) {
+	rows, err := repo.db.Query(`SELECT i.InvoiceId, s.StepId, s.Name, s.DaysAfterDue, s.Subject, s.Template, s.Active, s.CreatedAt, s.UpdatedAt
+						FROM invoices i
+						JOIN customers c ON c.Id = i.CustomerId
+						CROSS JOIN LATERAL (
+							SELECT `+reminderStepColumns+`
+							FROM reminder_steps
+							WHERE Active
+								AND i.DueDate + DaysAfterDue <= CURRENT_DATE
+								AND i.DueDate + DaysAfterDue >= i.InvoiceDate::date
+							ORDER BY DaysAfterDue DESC
+							LIMIT 1
+						) s
+						WHERE i.Status = $1 AND i.PaymentStatus <> $2 AND i.CustomerEmail <> '' AND NOT c.NoReminders
+							AND NOT EXISTS (
+								SELECT 1 FROM invoice_emails e
+								JOIN reminder_steps sent ON sent.StepId = e.ReminderStepId
+								WHERE e.InvoiceId = i.InvoiceId AND e.SentAt IS NOT NULL AND sent.DaysAfterDue >= s.DaysAfterDue
+							)
+							AND NOT EXISTS (
+								SELECT 1 FROM invoice_emails e
+								WHERE e.InvoiceId = i.InvoiceId AND e.ReminderStepId = s.StepId AND e.CreatedAt > CURRENT_TIMESTAMP - INTERVAL '1 day'
+							)
+						ORDER BY i.DueDate, i.InvoiceId`, model.IssuedInvoice, model.Paid)
+	if err != nil {
+		return nil, fmt.Errorf("error querying due reminders: %v", err)
+	}
+	defer rows.Close()
+
+	var due []model.DueReminder
+	for rows.Next() {
+		var reminder model.DueReminder
+		if err := rows.Scan(&reminder.InvoiceId, &reminder.Step.StepId, &reminder.Step.Name, &reminder.Step.DaysAfterDue,
+			&reminder.Step.Subject, &reminder.Step.Template, &reminder.Step.Active, &reminder.Step.CreatedAt, &reminder.Step.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning due reminder: %v", err)
+		}
+		due = append(due, reminder)
+	}
+	return due, rows.Err()
+}
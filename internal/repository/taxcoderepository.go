@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/MrAjMann/crm/internal/model"
+	"github.com/lib/pq"
+)
+
+type TaxCodeRepository struct {
+	db *sql.DB
+}
+
+func NewTaxCodeRepository(db *sql.DB) *TaxCodeRepository {
+	return &TaxCodeRepository{db: db}
+}
+
+var ErrTaxCodeExists = errors.New("there is already a tax code with that code")
+
+const taxCodeColumns = "Code, Name, Kind, Rate, Active, CreatedAt, UpdatedAt"
+
+func scanTaxCode(row interface{ Scan(...any) error }) (model.TaxCode, error) {
+	var t model.TaxCode
+	err := row.Scan(&t.Code, &t.Name, &t.Kind, &t.Rate, &t.Active, &t.CreatedAt, &t.UpdatedAt)
+	return t, err
+}
+
+// GetAllTaxCodes lists the tax codes, active codes first. Inactive codes are left out unless includeInactive is set.
+func (repo *TaxCodeRepository) GetAllTaxCodes(includeInactive bool) ([]model.TaxCode, error) {
+	rows, err := repo.db.Query("SELECT "+taxCodeColumns+" FROM tax_codes WHERE Active OR $1 ORDER BY Active DESC, Code", includeInactive)
+	if err != nil {
+		return nil, fmt.Errorf("error querying tax codes: %v", err)
+	}
+	defer rows.Close()
+
+	var taxCodes []model.TaxCode
+	for rows.Next() {
+		taxCode, err := scanTaxCode(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning tax code: %v", err)
+		}
+		taxCodes = append(taxCodes, taxCode)
+	}
+	return taxCodes, rows.Err()
+}
+
+func (repo *TaxCodeRepository) GetTaxCode(code string) (model.TaxCode, error) {
+	return scanTaxCode(repo.db.QueryRow("SELECT "+taxCodeColumns+" FROM tax_codes WHERE Code = $1", code))
+}
+
+// AddTaxCode saves a new tax code, returning ErrTaxCodeExists when the code is taken
+func (repo *TaxCodeRepository) AddTaxCode(taxCode model.TaxCode) (model.TaxCode, error) {
+	added, err := scanTaxCode(repo.db.QueryRow(`INSERT INTO tax_codes (Code, Name, Kind, Rate, Active) VALUES ($1, $2, $3, $4, $5)
+						RETURNING `+taxCodeColumns,
+		taxCode.Code, taxCode.Name, taxCode.Kind, taxCode.Rate, taxCode.Active))
+	if isUniqueViolation(err) {
+		return added, ErrTaxCodeExists
+	}
+	if err != nil {
+		return added, fmt.Errorf("error inserting tax code %s: %v", taxCode.Code, err)
+	}
+	return added, nil
+}
+
+// UpdateTaxCode changes a tax code's name, kind, rate and whether it can be used.
+// Invoices already raised keep the rate and name they were created with.
+func (repo *TaxCodeRepository) UpdateTaxCode(taxCode model.TaxCode) (model.TaxCode, error) {
+	updated, err := scanTaxCode(repo.db.QueryRow(`UPDATE tax_codes SET Name = $2, Kind = $3, Rate = $4, Active = $5, UpdatedAt = CURRENT_TIMESTAMP
+						WHERE Code = $1
+						RETURNING `+taxCodeColumns,
+		taxCode.Code, taxCode.Name, taxCode.Kind, taxCode.Rate, taxCode.Active))
+	if err != nil && err != sql.ErrNoRows {
+		return updated, fmt.Errorf("error updating tax code %s: %v", taxCode.Code, err)
+	}
+	return updated, err
+}
+
+// isUniqueViolation reports whether err is postgres refusing a duplicate key
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// isUniqueViolationOf reports whether err is a unique violation of the named constraint or index
+func isUniqueViolationOf(err error, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == constraint
+}
@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/MrAjMann/crm/internal/model"
+)
+
+type UserRepository struct {
+	db *sql.DB
+}
+
+func NewUserRepository(db *sql.DB) *UserRepository {
+	return &UserRepository{db: db}
+}
+
+// AddUser inserts a new user, PasswordHash must already be hashed. Users without a role get sales.
+func (repo *UserRepository) AddUser(user model.User) (int, error) {
+	var userId int
+	if user.Role == "" {
+		user.Role = model.SalesRole
+	}
+	err := repo.db.QueryRow("INSERT INTO users (Email, Name, PasswordHash, Role) VALUES ($1, $2, $3, $4) RETURNING Id",
+		strings.TrimSpace(user.Email), user.Name, user.PasswordHash, user.Role).Scan(&userId)
+	if err != nil {
+		return 0, fmt.Errorf("error inserting user %s: %v", user.Email, err)
+	}
+	return userId, nil
+}
+
+// GetUserByEmail looks a user up by email, ignoring case
+func (repo *UserRepository) GetUserByEmail(email string) (model.User, error) {
+	var user model.User
+	err := repo.db.QueryRow("SELECT Id, Email, Name, PasswordHash, Role, CreatedAt, UpdatedAt FROM users WHERE LOWER(Email) = LOWER($1)",
+		strings.TrimSpace(email)).Scan(&user.Id, &user.Email, &user.Name, &user.PasswordHash, &user.Role, &user.CreatedAt, &user.UpdatedAt)
+	return user, err
+}
+
+func (repo *UserRepository) GetUserById(id int) (model.User, error) {
+	var user model.User
+	err := repo.db.QueryRow("SELECT Id, Email, Name, PasswordHash, Role, CreatedAt, UpdatedAt FROM users WHERE Id = $1",
+		id).Scan(&user.Id, &user.Email, &user.Name, &user.PasswordHash, &user.Role, &user.CreatedAt, &user.UpdatedAt)
+	return user, err
+}
+
+func (repo *UserRepository) GetAllUsers() ([]model.User, error) {
+	rows, err := repo.db.Query("SELECT Id, Email, Name, Role, CreatedAt, UpdatedAt FROM users ORDER BY Name")
+	if err != nil {
+		return nil, fmt.Errorf("error querying users: %v", err)
+	}
+	defer rows.Close()
+
+	var users []model.User
+	for rows.Next() {
+		var user model.User
+		if err := rows.Scan(&user.Id, &user.Email, &user.Name, &user.Role, &user.CreatedAt, &user.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning user: %v", err)
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// ErrLastAdmin is returned when a role change would leave nobody able to manage users
+var ErrLastAdmin = errors.New("there must always be at least one admin")
+
+// UpdateUserRole changes a user's role, refusing to demote the last admin
+func (repo *UserRepository) UpdateUserRole(id int, role model.Role) (model.User, error) {
+	var user model.User
+
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return user, fmt.Errorf("error starting user role transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the admins so two demotions at once cannot both pass the check
+	var otherAdmins int
+	err = tx.QueryRow("SELECT COUNT(*) FROM (SELECT Id FROM users WHERE Role = $1 AND Id <> $2 FOR UPDATE) admins", model.AdminRole, id).Scan(&otherAdmins)
+	if err != nil {
+		return user, fmt.Errorf("error counting admins: %v", err)
+	}
+
+	err = tx.QueryRow("UPDATE users SET Role = $1, UpdatedAt = CURRENT_TIMESTAMP WHERE Id = $2 RETURNING Id, Email, Name, Role, CreatedAt, UpdatedAt",
+		role, id).Scan(&user.Id, &user.Email, &user.Name, &user.Role, &user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		return user, err
+	}
+	if role != model.AdminRole && otherAdmins == 0 {
+		return user, ErrLastAdmin
+	}
+
+	return user, tx.Commit()
+}
@@ -0,0 +1,119 @@
+// Package scheduler runs periodic background jobs inside the app. When several instances share a database
+// only one of them, the leader, runs the jobs: leadership is a Postgres advisory lock held on a dedicated
+// connection, so it is released as soon as the leader stops or loses its connection and another instance takes over.
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"log"
+	"time"
+)
+
+// lockKey is the advisory lock id held by the instance running the jobs, it must differ from the migration lock
+const lockKey = 72_634_002
+
+// Job is a piece of work run every so often by the leader
+type Job struct {
+	Name  string
+	Every time.Duration
+	Run   func(ctx context.Context) error
+}
+
+type Scheduler struct {
+	db   *sql.DB
+	tick time.Duration // How often leadership is checked and jobs are looked at
+	jobs []Job
+	conn *sql.Conn // Holds the advisory lock while this instance is the leader
+}
+
+// New builds a scheduler that checks for leadership and due jobs every tick
+func New(db *sql.DB, tick time.Duration, jobs ...Job) *Scheduler {
+	return &Scheduler{db: db, tick: tick, jobs: jobs}
+}
+
+// Run checks every tick whether this instance is the leader and runs any jobs that are due, until ctx is cancelled.
+// Jobs run one at a time, a failing job is logged and tried again when it is next due.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.tick)
+	defer ticker.Stop()
+	defer s.resign()
+
+	lastRun := make(map[string]time.Time, len(s.jobs))
+	for {
+		if s.lead(ctx) {
+			for _, job := range s.jobs {
+				if time.Since(lastRun[job.Name]) < job.Every {
+					continue
+				}
+				lastRun[job.Name] = time.Now()
+				if err := job.Run(ctx); err != nil {
+					log.Printf("Scheduled job %s failed: %v\n", job.Name, err)
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// lead reports whether this instance is the leader, trying to become it when it isn't
+func (s *Scheduler) lead(ctx context.Context) bool {
+	if s.conn != nil {
+		// A dropped connection loses the lock with it, so check it is still there before running anything
+		if err := s.conn.PingContext(ctx); err == nil {
+			return true
+		}
+		log.Println("Scheduler lost its database connection, giving up leadership")
+		s.resign()
+	}
+
+	acquired, err := s.acquire(ctx)
+	if err != nil {
+		log.Printf("Scheduler could not check leadership: %v\n", err)
+		return false
+	}
+	if acquired {
+		log.Println("Scheduler is now the leader, running background jobs on this instance")
+	}
+	return acquired
+}
+
+// acquire tries to take the advisory lock on a connection kept for as long as this instance leads
+func (s *Scheduler) acquire(ctx context.Context) (bool, error) {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return false, fmt.Errorf("error getting database connection: %v", err)
+	}
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", lockKey).Scan(&acquired); err != nil {
+		conn.Close()
+		return false, fmt.Errorf("error acquiring scheduler lock: %v", err)
+	}
+	if !acquired {
+		conn.Close()
+		return false, nil
+	}
+	s.conn = conn
+	return true, nil
+}
+
+// resign releases the advisory lock so another instance can take over
+func (s *Scheduler) resign() {
+	if s.conn == nil {
+		return
+	}
+	if _, err := s.conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockKey); err != nil {
+		// The connection can't go back to the pool still holding the lock, so throw it away and the lock ends with its session
+		s.conn.Raw(func(any) error { return driver.ErrBadConn })
+	}
+	s.conn.Close()
+	s.conn = nil
+}
@@ -1,15 +1,24 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
+	"github.com/MrAjMann/crm/generator"
 	"github.com/MrAjMann/crm/internal/handler"
+	"github.com/MrAjMann/crm/internal/invoicemail"
+	"github.com/MrAjMann/crm/internal/mailer"
+	"github.com/MrAjMann/crm/internal/model"
 	"github.com/MrAjMann/crm/internal/repository"
+	"github.com/MrAjMann/crm/internal/scheduler"
 
+	"github.com/gorilla/securecookie"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
@@ -35,10 +44,25 @@ func main() {
 		log.Fatalf("Failed to initialize database tables: %v", err)
 	}
 
-	// Initialize database tables
-	err = CreateTables(db)
+	// Subcommands run against the database and exit
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(db, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Bring the database schema up to date
+	err = migrateUp(db)
 	if err != nil {
-		log.Fatalf("Failed to create database tables: %v", err)
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "user" {
+		if err := runUserCommand(db, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
 	if err != nil {
@@ -61,6 +85,10 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	sideBarTmpl, err = sideBarTmpl.ParseGlob("src/templates/partials/*.html")
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	customerRepo := repository.NewCustomerRepository(db)
 	if customerRepo == nil {
@@ -72,15 +100,125 @@ func main() {
 		println("Creating customers table")
 	}
 
-	invoiceRepo := repository.NewInvoiceRepository(db)
+	invoiceNumbering, err := repository.InvoiceNumberingFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	invoiceRepo := repository.NewInvoiceRepository(db, invoiceNumbering)
 	if invoiceRepo == nil {
 		println("Creating customers table")
 	}
 
-	dashboardHandler := handler.NewDashboardHandler(sideBarTmpl)
-	customerHandler := handler.NewCustomerHandler(customerRepo, sideBarTmpl)
-	leadHandler := handler.NewLeadHandler(leadRepo, sideBarTmpl)
-	invoiceHandler := handler.NewInvoiceHandler(invoiceRepo, sideBarTmpl)
+	estimateNumbering, err := repository.EstimateNumberingFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	estimateRepo := repository.NewEstimateRepository(db, estimateNumbering, invoiceRepo)
+
+	creditNoteNumbering, err := repository.CreditNoteNumberingFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	creditNoteRepo := repository.NewCreditNoteRepository(db, creditNoteNumbering)
+	recurringRepo := repository.NewRecurringInvoiceRepository(db, invoiceRepo)
+
+	noteRepo := repository.NewNoteRepository(db)
+	userRepo := repository.NewUserRepository(db)
+	addressRepo := repository.NewAddressRepository(db)
+	taxCodeRepo := repository.NewTaxCodeRepository(db)
+	productRepo := repository.NewProductRepository(db)
+	paymentRepo := repository.NewPaymentRepository(db)
+	reminderRepo := repository.NewReminderRepository(db)
+
+	business := generator.BusinessFromEnv()
+	invoicePDF := generator.NewInvoiceGenerator(business)
+
+	// Email is turned off unless SMTP_HOST is set
+	mail, err := mailer.FromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	emailTemplates, err := invoicemail.ParseTemplates("src/templates/email")
+	if err != nil {
+		log.Fatal(err)
+	}
+	invoiceSender := invoicemail.NewSender(invoiceRepo, reminderRepo, invoicePDF, mail, emailTemplates, business)
+
+	// Background jobs, only one instance runs them when several share the database
+	overdueEvery, err := durationFromEnv("OVERDUE_CHECK_INTERVAL", time.Hour)
+	if err != nil {
+		log.Fatal(err)
+	}
+	expiryEvery, err := durationFromEnv("ESTIMATE_EXPIRY_INTERVAL", time.Hour)
+	if err != nil {
+		log.Fatal(err)
+	}
+	reminderEvery, err := durationFromEnv("REMINDER_CHECK_INTERVAL", time.Hour)
+	if err != nil {
+		log.Fatal(err)
+	}
+	recurringEvery, err := durationFromEnv("RECURRING_CHECK_INTERVAL", time.Hour)
+	if err != nil {
+		log.Fatal(err)
+	}
+	jobs := scheduler.New(db, time.Minute,
+		scheduler.Job{Name: "raise recurring invoices", Every: recurringEvery, Run: func(ctx context.Context) error {
+			raised, err := recurringRepo.RaiseDueInvoices(time.Now())
+			if len(raised) > 0 {
+				log.Printf("Raised %d recurring invoices", len(raised))
+			}
+			if err != nil {
+				return err
+			}
+			sent, err := invoiceSender.SendRecurringInvoices(raised)
+			if sent > 0 {
+				log.Printf("Emailed %d recurring invoices", sent)
+			}
+			return err
+		}},
+		scheduler.Job{Name: "mark overdue invoices", Every: overdueEvery, Run: func(ctx context.Context) error {
+			marked, err := invoiceRepo.MarkOverdueInvoices()
+			if marked > 0 {
+				log.Printf("Marked %d invoices overdue", marked)
+			}
+			return err
+		}},
+		scheduler.Job{Name: "expire estimates", Every: expiryEvery, Run: func(ctx context.Context) error {
+			expired, err := estimateRepo.ExpireEstimates()
+			if expired > 0 {
+				log.Printf("Marked %d estimates expired", expired)
+			}
+			return err
+		}},
+		scheduler.Job{Name: "send payment reminders", Every: reminderEvery, Run: func(ctx context.Context) error {
+			sent, err := invoiceSender.SendDueReminders()
+			if sent > 0 {
+				log.Printf("Sent %d payment reminders", sent)
+			}
+			return err
+		}},
+	)
+	go jobs.Run(context.Background())
+
+	authHandler := handler.NewAuthHandler(userRepo, sideBarTmpl, sessionKey(), os.Getenv("SESSION_SECURE") == "true")
+	dashboardHandler := handler.NewDashboardHandler(leadRepo, sideBarTmpl)
+	customerHandler := handler.NewCustomerHandler(customerRepo, noteRepo, addressRepo, invoiceRepo, sideBarTmpl)
+	leadHandler := handler.NewLeadHandler(leadRepo, noteRepo, sideBarTmpl)
+	invoiceHandler := handler.NewInvoiceHandler(invoiceRepo, taxCodeRepo, productRepo, sideBarTmpl, invoicePDF, invoiceSender)
+	estimateHandler := handler.NewEstimateHandler(estimateRepo, taxCodeRepo, productRepo, sideBarTmpl, invoicePDF)
+	creditNoteHandler := handler.NewCreditNoteHandler(creditNoteRepo, invoiceRepo, sideBarTmpl, invoicePDF)
+	recurringHandler := handler.NewRecurringInvoiceHandler(recurringRepo, invoiceRepo, taxCodeRepo, productRepo, sideBarTmpl)
+	noteHandler := handler.NewNoteHandler(noteRepo, sideBarTmpl)
+	userHandler := handler.NewUserHandler(userRepo, sideBarTmpl)
+	addressHandler := handler.NewAddressHandler(addressRepo, sideBarTmpl)
+	taxCodeHandler := handler.NewTaxCodeHandler(taxCodeRepo, sideBarTmpl)
+	productHandler := handler.NewProductHandler(productRepo, taxCodeRepo, sideBarTmpl)
+	paymentHandler := handler.NewPaymentHandler(paymentRepo, sideBarTmpl)
+	invoiceEmailHandler := handler.NewInvoiceEmailHandler(invoiceRepo, invoiceSender, sideBarTmpl)
+	reminderHandler := handler.NewReminderHandler(reminderRepo, invoiceSender, sideBarTmpl)
+
+	// can wraps a handler so only roles with the permission reach it
+	can := authHandler.Require
 
 	// Setup routes
 	// Handlers
@@ -91,24 +229,109 @@ func main() {
 
 	http.Handle("/src/", http.StripPrefix("/src/", fs))
 	http.Handle("/css/", http.StripPrefix("/css/", css))
+	// Auth Routes
+	http.HandleFunc("/login", authHandler.Login)
+	http.HandleFunc("/logout", authHandler.Logout)
+
 	// Dashboard Routes
 	http.HandleFunc("/", dashboardHandler.Dashboard)
 
 	// Customer Routes
-	http.HandleFunc("/customers", customerHandler.GetAllCustomers)              // Customers page
-	http.HandleFunc("/customer/", customerHandler.GetCustomer)                  // Handle getting a customer
-	http.HandleFunc("/add-customer/", customerHandler.AddCustomer)              // Handle adding a customer
-	http.HandleFunc("/search-customers", customerHandler.HandleSearchCustomers) // Handle searching for a customer
-	http.HandleFunc("/customer/delete/", customerHandler.DeleteCustomer)        // Handle searching for a customer
+	http.HandleFunc("/customers", customerHandler.GetAllCustomers)                                      // Customers page
+	http.HandleFunc("/customer/", customerHandler.GetCustomer)                                          // Handle getting a customer
+	http.HandleFunc("/add-customer/", can(model.ManageCustomers, customerHandler.AddCustomer))          // Handle adding a customer
+	http.HandleFunc("/search-customers", customerHandler.HandleSearchCustomers)                         // Handle searching for a customer
+	http.HandleFunc("/customer/delete/", can(model.ArchiveCustomers, customerHandler.DeleteCustomer))   // Handle archiving a customer
+	http.HandleFunc("/customers/archived", customerHandler.GetArchivedCustomers)                        // Archived customers page
+	http.HandleFunc("/customer/restore/", can(model.ArchiveCustomers, customerHandler.RestoreCustomer)) // Handle restoring an archived customer
+	http.HandleFunc("/customer/purge/", can(model.PurgeCustomers, customerHandler.PurgeCustomer))       // Handle permanently deleting an archived customer
+	http.HandleFunc("/customer/edit/", can(model.ManageCustomers, customerHandler.EditCustomer))        // Handle getting the customer edit form
+	http.HandleFunc("/customer/update/", can(model.ManageCustomers, customerHandler.UpdateCustomer))    // Handle updating a customer
 
 	// Lead Routes
-	http.HandleFunc("/leads", leadHandler.GetAllLeads) // Leads page
-	http.HandleFunc("/lead/", leadHandler.GetLead)     // Handle getting a lead
-	http.HandleFunc("/add-lead/", leadHandler.AddLead) // Handle adding a lead
+	http.HandleFunc("/leads", leadHandler.GetAllLeads)                                     // Leads page
+	http.HandleFunc("/lead/", leadHandler.GetLead)                                         // Handle getting a lead
+	http.HandleFunc("/add-lead/", can(model.ManageLeads, leadHandler.AddLead))             // Handle adding a lead
+	http.HandleFunc("/lead/status/", can(model.ManageLeads, leadHandler.UpdateLeadStatus)) // Handle moving a lead between statuses
+	http.HandleFunc("/lead/convert/", can(model.ManageLeads, leadHandler.ConvertLead))     // Handle converting a lead into a customer
+	http.HandleFunc("/search-leads", leadHandler.SearchLeads)                              // Handle searching for a lead
+	http.HandleFunc("/lead/delete/", can(model.ManageLeads, leadHandler.DeleteLead))       // Handle archiving a lead
+	http.HandleFunc("/leads/archived", leadHandler.GetArchivedLeads)                       // Archived leads page
+	http.HandleFunc("/lead/restore/", can(model.ManageLeads, leadHandler.RestoreLead))     // Handle restoring an archived lead
+	http.HandleFunc("/lead/purge/", can(model.PurgeLeads, leadHandler.PurgeLead))          // Handle permanently deleting an archived lead
 
 	//Invoice Routes
-	http.HandleFunc("/invoices", invoiceHandler.GetAllInvoices)
-	http.HandleFunc("/add-invoice/", invoiceHandler.AddNewInvoice)
+	http.HandleFunc("/invoices", can(model.ViewInvoices, invoiceHandler.GetAllInvoices))
+	http.HandleFunc("/add-invoice/", can(model.IssueInvoices, invoiceHandler.AddNewInvoice))
+	http.HandleFunc("/create-invoice", can(model.IssueInvoices, invoiceHandler.CreateInvoice))   // Create invoice page
+	http.HandleFunc("/invoice/view/", can(model.ViewInvoices, invoiceHandler.GetInvoice))        // Handle getting an invoice with its items
+	http.HandleFunc("/invoice/", can(model.ViewInvoices, invoiceHandler.GetInvoicePDF))          // Handle /invoice/{id}/pdf
+	http.HandleFunc("/search-invoices", can(model.ViewInvoices, invoiceHandler.SearchInvoices))  // Handle searching invoices
+	http.HandleFunc("/invoice/edit/", can(model.IssueInvoices, invoiceHandler.EditInvoice))      // Edit draft invoice page
+	http.HandleFunc("/invoice/update/", can(model.IssueInvoices, invoiceHandler.UpdateInvoice))  // Handle saving a draft invoice
+	http.HandleFunc("/invoice/issue/", can(model.IssueInvoices, invoiceHandler.IssueInvoice))    // Handle issuing a draft invoice
+	http.HandleFunc("/invoice/void/", can(model.IssueInvoices, invoiceHandler.VoidInvoice))      // Handle voiding an issued invoice
+	http.HandleFunc("/invoice/delete/", can(model.IssueInvoices, invoiceHandler.DeleteInvoice))  // Handle deleting a draft invoice
+	http.HandleFunc("/invoice/send/", can(model.IssueInvoices, invoiceEmailHandler.SendInvoice)) // Handle emailing an invoice to the customer
+
+	// Estimate Routes
+	http.HandleFunc("/estimates", can(model.ManageEstimates, estimateHandler.GetAllEstimates))             // Estimates page
+	http.HandleFunc("/create-estimate", can(model.ManageEstimates, estimateHandler.CreateEstimate))        // Create estimate page
+	http.HandleFunc("/add-estimate/", can(model.ManageEstimates, estimateHandler.AddNewEstimate))          // Handle adding an estimate
+	http.HandleFunc("/estimate/view/", can(model.ManageEstimates, estimateHandler.GetEstimate))            // Handle getting an estimate with its items
+	http.HandleFunc("/estimate/", can(model.ManageEstimates, estimateHandler.GetEstimatePDF))              // Handle /estimate/{id}/pdf
+	http.HandleFunc("/estimate/status/", can(model.ManageEstimates, estimateHandler.UpdateEstimateStatus)) // Handle marking an estimate sent, accepted or declined
+	http.HandleFunc("/estimate/convert/", can(model.IssueInvoices, estimateHandler.ConvertEstimate))       // Handle converting an estimate into an invoice
+
+	// Credit Note Routes
+	http.HandleFunc("/create-credit-note/", can(model.IssueInvoices, creditNoteHandler.CreateCreditNote)) // Create credit note page for an invoice
+	http.HandleFunc("/add-credit-note/", can(model.IssueInvoices, creditNoteHandler.AddCreditNote))       // Handle crediting an invoice
+	http.HandleFunc("/credit-note/view/", can(model.ViewInvoices, creditNoteHandler.GetCreditNote))       // Handle getting a credit note with its lines
+	http.HandleFunc("/credit-note/", can(model.ViewInvoices, creditNoteHandler.GetCreditNotePDF))         // Handle /credit-note/{id}/pdf
+
+	// Recurring Invoice Routes
+	http.HandleFunc("/recurring-invoices", can(model.ViewInvoices, recurringHandler.GetAllRecurringInvoices))           // Recurring invoices page
+	http.HandleFunc("/create-recurring-invoice", can(model.IssueInvoices, recurringHandler.CreateRecurringInvoice))     // Create recurring invoice page
+	http.HandleFunc("/add-recurring-invoice/", can(model.IssueInvoices, recurringHandler.AddRecurringInvoice))          // Handle adding a recurring invoice schedule
+	http.HandleFunc("/recurring-invoice/view/", can(model.ViewInvoices, recurringHandler.GetRecurringInvoice))          // Handle getting a schedule with the invoices it raised
+	http.HandleFunc("/recurring-invoice/active/", can(model.IssueInvoices, recurringHandler.SetRecurringInvoiceActive)) // Handle pausing or resuming a schedule
+
+	// Payment Routes
+	http.HandleFunc("/invoice/payment/", can(model.IssueInvoices, paymentHandler.RecordPayment)) // Handle recording a payment against an invoice
+	http.HandleFunc("/payment/delete/", can(model.IssueInvoices, paymentHandler.DeletePayment))  // Handle deleting a payment recorded by mistake
+
+	// Payment Reminder Routes
+	http.HandleFunc("/reminders", can(model.ManageReminders, reminderHandler.GetReminderSteps))               // Payment reminder sequence page
+	http.HandleFunc("/add-reminder-step/", can(model.ManageReminders, reminderHandler.AddReminderStep))       // Handle adding a reminder step
+	http.HandleFunc("/reminder-step/update/", can(model.ManageReminders, reminderHandler.UpdateReminderStep)) // Handle updating a reminder step
+
+	// Product and Tax Code Routes
+	http.HandleFunc("/products", can(model.ManageProducts, productHandler.GetAllProducts))        // Products page
+	http.HandleFunc("/add-product/", can(model.ManageProducts, productHandler.AddProduct))        // Handle adding a product
+	http.HandleFunc("/product/update/", can(model.ManageProducts, productHandler.UpdateProduct))  // Handle updating a product
+	http.HandleFunc("/tax-codes", can(model.ManageTaxCodes, taxCodeHandler.GetAllTaxCodes))       // Tax codes page
+	http.HandleFunc("/add-tax-code/", can(model.ManageTaxCodes, taxCodeHandler.AddTaxCode))       // Handle adding a tax code
+	http.HandleFunc("/tax-code/update/", can(model.ManageTaxCodes, taxCodeHandler.UpdateTaxCode)) // Handle updating a tax code
+
+	// Note Routes
+	http.HandleFunc("/notes", noteHandler.GetNotes)                                  // Handle listing notes, filtered by category
+	http.HandleFunc("/add-note/", can(model.ManageNotes, noteHandler.AddNote))       // Handle adding a note to a customer or lead
+	http.HandleFunc("/note/", noteHandler.GetNote)                                   // Handle getting a note
+	http.HandleFunc("/note/edit/", can(model.ManageNotes, noteHandler.EditNote))     // Handle getting the note edit form
+	http.HandleFunc("/note/update/", can(model.ManageNotes, noteHandler.UpdateNote)) // Handle updating a note
+	http.HandleFunc("/note/delete/", can(model.ManageNotes, noteHandler.DeleteNote)) // Handle deleting a note
+
+	// Address Routes
+	http.HandleFunc("/addresses", addressHandler.GetAddressOptions)                               // Handle listing a customer's addresses to bill to
+	http.HandleFunc("/add-address/", can(model.ManageCustomers, addressHandler.AddAddress))       // Handle adding an address to a customer
+	http.HandleFunc("/address/", addressHandler.GetAddress)                                       // Handle getting an address
+	http.HandleFunc("/address/edit/", can(model.ManageCustomers, addressHandler.EditAddress))     // Handle getting the address edit form
+	http.HandleFunc("/address/update/", can(model.ManageCustomers, addressHandler.UpdateAddress)) // Handle updating an address
+	http.HandleFunc("/address/delete/", can(model.ManageCustomers, addressHandler.DeleteAddress)) // Handle deleting an address
+
+	// User Routes
+	http.HandleFunc("/users", can(model.ManageUsers, userHandler.GetAllUsers))         // Users page
+	http.HandleFunc("/user/role/", can(model.ManageUsers, userHandler.UpdateUserRole)) // Handle changing a user's role
 
 	// Session Routes
 	http.HandleFunc("/customer-session", customerHandler.HandleSessionStore)
@@ -123,19 +346,37 @@ func main() {
 		http.ServeFile(w, r, modalPath)
 	})
 
-	http.HandleFunc("/create-invoice", func(w http.ResponseWriter, r *http.Request) {
-		// Execute the template that includes the sidebar
-		err := sideBarTmpl.ExecuteTemplate(w, "createInvoice.html", nil)
-		if err != nil {
-			http.Error(w, "Error executing template", http.StatusInternalServerError)
-			log.Println(err)
-		}
-	})
-
 	// Server startup and error handling remain unchanged
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080" // Default port if not specified
 	}
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	// Every route apart from static assets and the login page needs a logged in user
+	log.Fatal(http.ListenAndServe(":"+port, authHandler.RequireAuth(http.DefaultServeMux)))
+}
+
+// durationFromEnv reads a duration such as "30m" or "1h" from the environment, using fallback when it isn't set
+func durationFromEnv(name string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration such as 30m or 1h, got %q", name, value)
+	}
+	return d, nil
+}
+
+// sessionKey reads the key used to sign login cookies. Without SESSION_KEY a random key
+// is used, which logs everyone out whenever the server restarts.
+func sessionKey() []byte {
+	if key := os.Getenv("SESSION_KEY"); key != "" {
+		if len(key) < 32 {
+			log.Fatal("SESSION_KEY must be at least 32 characters")
+		}
+		return []byte(key)
+	}
+	log.Println("SESSION_KEY is not set, using a random key")
+	return securecookie.GenerateRandomKey(32)
 }
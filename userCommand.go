@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/MrAjMann/crm/internal/auth"
+	"github.com/MrAjMann/crm/internal/model"
+	"github.com/MrAjMann/crm/internal/repository"
+)
+
+// runUserCommand handles `user add [-role role] <email> <name>`, reading the password from stdin
+func runUserCommand(db *sql.DB, args []string) error {
+	usage := fmt.Errorf("usage: user add [-role admin|sales|technician|bookkeeper] <email> <name>")
+	if len(args) < 1 || args[0] != "add" {
+		return usage
+	}
+
+	flags := flag.NewFlagSet("user add", flag.ContinueOnError)
+	role := flags.String("role", string(model.SalesRole), "role given to the new user")
+	if err := flags.Parse(args[1:]); err != nil {
+		return usage
+	}
+	args = flags.Args()
+	if len(args) < 2 || !model.Role(*role).Valid() {
+		return usage
+	}
+
+	fmt.Print("Password: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		return fmt.Errorf("error reading password: %v", err)
+	}
+
+	hash, err := auth.HashPassword(strings.TrimRight(password, "\r\n"))
+	if err != nil {
+		return err
+	}
+
+	user := model.User{
+		Email:        args[0],
+		Name:         strings.Join(args[1:], " "),
+		PasswordHash: hash,
+		Role:         model.Role(*role),
+	}
+	userId, err := repository.NewUserRepository(db).AddUser(user)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Added user %d (%s) as %s\n", userId, user.Email, user.Role)
+	return nil
+}